@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"math"
+	"math/rand"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
@@ -25,23 +27,92 @@ type CacheObj interface {
 
 type CacheWithTTL interface {
 	Cache
-	GetOrCreateWithTTL(key string, ttl time.Duration, creator Creater, out any) error
+	GetOrCreateWithTTL(key string, ttl time.Duration, creator Creater, out any, opts ...GetOptionFunc) error
 	SetWithTTL(key string, ttl time.Duration, value any) error
 }
 
 type CacheObjWithTTL interface {
 	CacheObj
-	GetOrCreateWithTTLObj(key string, ttl time.Duration, creator Creater) (any, error)
+	GetOrCreateWithTTLObj(key string, ttl time.Duration, creator Creater, opts ...GetOptionFunc) (any, error)
+}
+
+// GetOption controls how GetOrCreateWithTTL refreshes the value. The concurrent creations of
+// the same key are always deduplicated in the process, so the creator runs once for them.
+type GetOption struct {
+	// StaleTTL serves the expired value for at most StaleTTL while one goroutine refreshes it in background.
+	// It applies to the values stored with it, and the stale value is kept if the refresh fails.
+	StaleTTL time.Duration
+	// NegativeTTL is the ttl of the errors returned by the creator, the zero caches them as long as
+	// the values and the negative does not cache them.
+	NegativeTTL time.Duration
+	// EarlyExpirationBeta refreshes the value in background before it expires, with the probability
+	// growing as the expiry approaches and with the time the creator took, 1 is the usual choice and
+	// the zero disables it. See "Optimal Probabilistic Cache Stampede Prevention".
+	EarlyExpirationBeta float64
+}
+
+type GetOptionFunc func(*GetOption)
+
+func WithStaleWhileRevalidate(staleTTL time.Duration) GetOptionFunc {
+	return func(o *GetOption) {
+		o.StaleTTL = staleTTL
+	}
+}
+
+func WithNegativeTTL(ttl time.Duration) GetOptionFunc {
+	return func(o *GetOption) {
+		o.NegativeTTL = ttl
+	}
+}
+
+func WithEarlyExpiration(beta float64) GetOptionFunc {
+	return func(o *GetOption) {
+		o.EarlyExpirationBeta = beta
+	}
+}
+
+func packGetOption(opts ...GetOptionFunc) *GetOption {
+	o := &GetOption{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ttlOf returns the ttl of the created payload, and false if it should not be cached.
+func (o *GetOption) ttlOf(p payload, ttl time.Duration) (time.Duration, bool) {
+	if p.Error == nil {
+		return ttl, true
+	}
+	if o.NegativeTTL < 0 {
+		return 0, false
+	}
+	if o.NegativeTTL > 0 {
+		return o.NegativeTTL, true
+	}
+	return ttl, true
+}
+
+// refreshEarly reports whether the value expiring at expireAt, which took delta to create,
+// should be refreshed now.
+func (o *GetOption) refreshEarly(now, expireAt time.Time, delta time.Duration) bool {
+	if o.EarlyExpirationBeta <= 0 || expireAt.IsZero() || delta <= 0 {
+		return false
+	}
+	gap := time.Duration(float64(delta) * o.EarlyExpirationBeta * -math.Log(1-rand.Float64()))
+	return !now.Add(gap).Before(expireAt)
 }
 
 type payload struct {
 	Content any   `json:"content"`
-	Error   error `json:"error,omitempty"`
+	Error   error `json:"-"`
+	// ErrMsg keeps the error in json, since the error itself can not be unmarshaled
+	ErrMsg string `json:"error,omitempty"`
 }
 
 func (p payload) Get(out any) error {
-	if p.Error != nil {
-		return p.Error
+	if err := p.err(); err != nil {
+		return err
 	}
 
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
@@ -56,16 +127,26 @@ func (p payload) Get(out any) error {
 }
 
 func (p payload) GetObj() (any, error) {
-	if p.Error != nil {
-		return nil, p.Error
+	if err := p.err(); err != nil {
+		return nil, err
 	}
 
 	return p.Content, nil
 }
 
+func (p payload) err() error {
+	if p.Error != nil {
+		return p.Error
+	}
+	if p.ErrMsg != "" {
+		return errors.New(p.ErrMsg)
+	}
+	return nil
+}
+
 func newPayload(content any, err error) payload {
 	if err != nil {
-		return payload{Error: err}
+		return payload{Error: err, ErrMsg: err.Error()}
 	}
 
 	return payload{Content: content}
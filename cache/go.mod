@@ -10,6 +10,7 @@ require (
 	github.com/superwhys/venkit/lg/v2 v2.2.11
 	github.com/superwhys/venkit/v2 v2.2.13
 	github.com/superwhys/venkit/vredis/v2 v2.2.6
+	golang.org/x/sync v0.7.0
 )
 
 require (
@@ -89,7 +90,6 @@ require (
 	golang.org/x/exp v0.0.0-20240613232115-7f521ea00fb8 // indirect
 	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/oauth2 v0.21.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
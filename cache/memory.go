@@ -3,8 +3,12 @@ package cache
 import (
 	"context"
 	"errors"
-	"sync"
+	"hash/maphash"
+	"sync/atomic"
 	"time"
+
+	"github.com/superwhys/venkit/lg/v2"
+	"golang.org/x/sync/singleflight"
 )
 
 var _ CacheObjWithTTL = (*MemoryCache)(nil)
@@ -12,6 +16,10 @@ var _ CacheObjWithTTL = (*MemoryCache)(nil)
 type payloadWithExpire struct {
 	payload  payload
 	expireAt time.Time
+	// staleUntil is the time until which the expired payload may be served
+	staleUntil time.Time
+	// delta is the time the creator took
+	delta time.Duration
 }
 
 func (p *payloadWithExpire) IsExpire() bool {
@@ -26,6 +34,10 @@ func (p *payloadWithExpire) IsExpire() bool {
 	return !time.Now().Before(p.expireAt)
 }
 
+func (p *payloadWithExpire) IsStale() bool {
+	return p.IsExpire() && time.Now().Before(p.staleUntil)
+}
+
 func (p *payloadWithExpire) Get(out any) error {
 	return p.payload.Get(out)
 }
@@ -34,24 +46,111 @@ func (p *payloadWithExpire) GetObj() (any, error) {
 	return p.payload.GetObj()
 }
 
+const DefaultMemoryCacheShards = 16
+
 type MemoryCache struct {
-	lock             sync.RWMutex
-	payload          map[string]payloadWithExpire
+	shards           []*memoryShard
+	seed             maphash.Seed
+	group            singleflight.Group
 	cancel           func()
 	rotationInterval time.Duration
+
+	shardCount int
+	maxEntries int
+	maxBytes   int64
+	policy     EvictionPolicy
+	sizer      func(key string, value any) int64
+	onEvict    func(key string, value any, reason EvictionReason)
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+}
+
+type MemoryCacheOption func(mc *MemoryCache)
+
+// WithMaxEntries limits the number of the entries, the zero means no limit.
+func WithMaxEntries(n int) MemoryCacheOption {
+	return func(mc *MemoryCache) {
+		mc.maxEntries = n
+	}
+}
+
+// WithMaxBytes limits the approximate bytes of the entries, the zero means no limit.
+func WithMaxBytes(n int64) MemoryCacheOption {
+	return func(mc *MemoryCache) {
+		mc.maxBytes = n
+	}
+}
+
+// WithEvictionPolicy sets the policy evicting the entries when the cache is full, default is LRU.
+func WithEvictionPolicy(p EvictionPolicy) MemoryCacheOption {
+	return func(mc *MemoryCache) {
+		mc.policy = p
+	}
+}
+
+// WithSizer sets the func measuring the bytes of the entries for WithMaxBytes,
+// the default walks the value by reflection. It is not called without WithMaxBytes.
+func WithSizer(sizer func(key string, value any) int64) MemoryCacheOption {
+	return func(mc *MemoryCache) {
+		mc.sizer = sizer
+	}
+}
+
+// WithOnEvict sets the callback of the entries evicted or swept, which is called without holding the locks.
+func WithOnEvict(fn func(key string, value any, reason EvictionReason)) MemoryCacheOption {
+	return func(mc *MemoryCache) {
+		mc.onEvict = fn
+	}
+}
+
+// WithShards sets the number of the shards, which is rounded up to a power of 2.
+// The capacity is split evenly among the shards, so the limits are approximate.
+func WithShards(n int) MemoryCacheOption {
+	return func(mc *MemoryCache) {
+		mc.shardCount = n
+	}
 }
 
-func NewMemoryCache(rotationInterval time.Duration) *MemoryCache {
+func NewMemoryCache(rotationInterval time.Duration, opts ...MemoryCacheOption) *MemoryCache {
 	mc := &MemoryCache{
-		payload:          make(map[string]payloadWithExpire),
+		seed:             maphash.MakeSeed(),
 		rotationInterval: rotationInterval,
+		sizer:            approximateSize,
+	}
+	for _, opt := range opts {
+		opt(mc)
+	}
+
+	shardCount := mc.shardCount
+	if shardCount <= 0 {
+		shardCount = DefaultMemoryCacheShards
+		// keep enough entries in a shard for the policy to work
+		if mc.maxEntries > 0 {
+			shardCount = min(shardCount, max(1, mc.maxEntries/64))
+		}
+	}
+	n := 1
+	for n < shardCount {
+		n <<= 1
 	}
+	mc.shards = make([]*memoryShard, n)
+	for i := range mc.shards {
+		mc.shards[i] = newMemoryShard(mc.policy, (mc.maxEntries+n-1)/n, (mc.maxBytes+int64(n)-1)/int64(n))
+	}
+
 	ctx, cancel := context.WithCancel(context.TODO())
 	mc.cancel = cancel
 	go mc.runRotation(ctx, rotationInterval)
 	return mc
 }
 
+func (mc *MemoryCache) shard(key string) *memoryShard {
+	return mc.shards[maphash.String(mc.seed, key)&uint64(len(mc.shards)-1)]
+}
+
 func (mc *MemoryCache) runRotation(ctx context.Context, rotationInterval time.Duration) {
 	ticker := time.NewTicker(rotationInterval)
 	defer ticker.Stop()
@@ -60,21 +159,47 @@ func (mc *MemoryCache) runRotation(ctx context.Context, rotationInterval time.Du
 		if ctx.Err() != nil {
 			return
 		}
-		mc.lock.Lock()
-		for key, value := range mc.payload {
-			if value.IsExpire() {
-				delete(mc.payload, key)
-			}
+		for _, s := range mc.shards {
+			mc.evicted(s.sweep())
 		}
-		mc.lock.Unlock()
 	}
 }
 
-func (mc *MemoryCache) Get(key string, out any) error {
-	mc.lock.RLock()
-	p, ok := mc.payload[key]
-	mc.lock.RUnlock()
+func (mc *MemoryCache) evicted(entries []evictedEntry) {
+	for _, e := range entries {
+		if e.reason == EvictionExpired {
+			mc.expirations.Add(1)
+		} else {
+			mc.evictions.Add(1)
+		}
+		if mc.onEvict != nil {
+			mc.onEvict(e.key, e.value, e.reason)
+		}
+	}
+}
 
+// lookup returns the payload of the key even if it expires, and counts the hit or miss.
+func (mc *MemoryCache) lookup(key string) (payloadWithExpire, bool) {
+	p, ok := mc.shard(key).get(key)
+	if ok && !p.IsExpire() {
+		mc.hits.Add(1)
+	} else {
+		mc.misses.Add(1)
+	}
+	return p, ok
+}
+
+func (mc *MemoryCache) store(key string, p payloadWithExpire) {
+	// the sizer walks the value, so it is skipped if the bytes are not limited
+	var size int64
+	if mc.maxBytes > 0 {
+		size = mc.sizer(key, p.payload.Content)
+	}
+	mc.evicted(mc.shard(key).set(key, p, size))
+}
+
+func (mc *MemoryCache) Get(key string, out any) error {
+	p, ok := mc.lookup(key)
 	if !ok || p.IsExpire() {
 		return errors.New("not found")
 	}
@@ -83,10 +208,7 @@ func (mc *MemoryCache) Get(key string, out any) error {
 }
 
 func (mc *MemoryCache) GetObj(key string) (any, error) {
-	mc.lock.RLock()
-	p, ok := mc.payload[key]
-	mc.lock.RUnlock()
-
+	p, ok := mc.lookup(key)
 	if !ok || p.IsExpire() {
 		return nil, errors.New("not found")
 	}
@@ -107,25 +229,53 @@ func (mc *MemoryCache) GetOrCreateObj(key string, creater Creater) (any, error)
 }
 
 func (mc *MemoryCache) Delete(key string) error {
-	mc.lock.Lock()
-	_, ok := mc.payload[key]
-	if ok {
-		delete(mc.payload, key)
-	}
-	mc.lock.Unlock()
+	mc.shard(key).delete(key)
 	return nil
 }
 
 func (mc *MemoryCache) Close() error {
 	mc.cancel()
-	mc.lock.Lock()
-	mc.payload = nil
-	mc.lock.Unlock()
+	for _, s := range mc.shards {
+		s.close()
+	}
 	return nil
 }
 
-func (mc *MemoryCache) GetOrCreateWithTTLObj(key string, ttl time.Duration, creater Creater) (any, error) {
-	np, err := mc.getOrCreate(key, ttl, creater)
+// MemoryCacheStats is the statistics of the MemoryCache, the stale values served count as the hits.
+type MemoryCacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+	Entries     int
+	// Bytes is only measured when the cache is created WithMaxBytes
+	Bytes int64
+}
+
+func (s MemoryCacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (mc *MemoryCache) Stats() MemoryCacheStats {
+	stats := MemoryCacheStats{
+		Hits:        mc.hits.Load(),
+		Misses:      mc.misses.Load(),
+		Evictions:   mc.evictions.Load(),
+		Expirations: mc.expirations.Load(),
+	}
+	for _, s := range mc.shards {
+		entries, bytes := s.stats()
+		stats.Entries += entries
+		stats.Bytes += bytes
+	}
+	return stats
+}
+
+func (mc *MemoryCache) GetOrCreateWithTTLObj(key string, ttl time.Duration, creater Creater, opts ...GetOptionFunc) (any, error) {
+	np, err := mc.getOrCreate(key, ttl, creater, packGetOption(opts...))
 	if err != nil {
 		return nil, err
 	}
@@ -133,8 +283,8 @@ func (mc *MemoryCache) GetOrCreateWithTTLObj(key string, ttl time.Duration, crea
 	return np.GetObj()
 }
 
-func (mc *MemoryCache) GetOrCreateWithTTL(key string, ttl time.Duration, creater Creater, out any) error {
-	np, err := mc.getOrCreate(key, ttl, creater)
+func (mc *MemoryCache) GetOrCreateWithTTL(key string, ttl time.Duration, creater Creater, out any, opts ...GetOptionFunc) error {
+	np, err := mc.getOrCreate(key, ttl, creater, packGetOption(opts...))
 	if err != nil {
 		return err
 	}
@@ -142,48 +292,81 @@ func (mc *MemoryCache) GetOrCreateWithTTL(key string, ttl time.Duration, creater
 	return np.Get(out)
 }
 
-func (mc *MemoryCache) getOrCreate(key string, ttl time.Duration, creater Creater) (payload, error) {
-	mc.lock.RLock()
-	p, ok := mc.payload[key]
-	mc.lock.RUnlock()
+func (mc *MemoryCache) getOrCreate(key string, ttl time.Duration, creater Creater, opt *GetOption) (payload, error) {
+	p, ok := mc.shard(key).get(key)
+	if ok && (!p.IsExpire() || p.IsStale()) {
+		mc.hits.Add(1)
+	} else {
+		mc.misses.Add(1)
+	}
 
 	if ok && !p.IsExpire() {
+		if opt.refreshEarly(time.Now(), p.expireAt, p.delta) {
+			mc.refresh(key, ttl, creater, opt)
+		}
+		return p.payload, nil
+	}
+	if ok && p.IsStale() {
+		mc.refresh(key, ttl, creater, opt)
 		return p.payload, nil
 	}
 
+	// the concurrent misses of the key share one creation
+	v, _, _ := mc.group.Do(key, func() (any, error) {
+		p, ok := mc.shard(key).get(key)
+		// created by the last flight
+		if ok && !p.IsExpire() {
+			return p.payload, nil
+		}
+		return mc.create(key, ttl, creater, opt, false), nil
+	})
+	return v.(payload), nil
+}
+
+// refresh creates the value of the key in background, unless it is being created.
+func (mc *MemoryCache) refresh(key string, ttl time.Duration, creater Creater, opt *GetOption) {
+	mc.group.DoChan(key, func() (any, error) {
+		return mc.create(key, ttl, creater, opt, true), nil
+	})
+}
+
+// create runs the creater and stores the payload, the failed refresh keeps the stale one.
+func (mc *MemoryCache) create(key string, ttl time.Duration, creater Creater, opt *GetOption, refresh bool) payload {
+	start := time.Now()
 	np := newPayload(creater())
-	mc.lock.Lock()
+	delta := time.Since(start)
 
-	var expireAt time.Time
-	if ttl > 0 {
-		expireAt = time.Now().Add(ttl)
+	if np.Error != nil && refresh {
+		lg.Warnf("MemoryCache refresh %v error: %v", key, np.Error)
+		return np
+	}
+	ttl, ok := opt.ttlOf(np, ttl)
+	if !ok {
+		return np
 	}
 
-	mc.payload[key] = payloadWithExpire{
-		payload:  np,
-		expireAt: expireAt,
+	pe := payloadWithExpire{payload: np, delta: delta}
+	if ttl > 0 {
+		pe.expireAt = time.Now().Add(ttl)
+		pe.staleUntil = pe.expireAt.Add(opt.StaleTTL)
 	}
-	mc.lock.Unlock()
 
-	return np, nil
+	mc.store(key, pe)
+	return np
 }
 
 func (mc *MemoryCache) SetWithTTL(key string, ttl time.Duration, value any) error {
 	p := payload{Content: value}
 
-	mc.lock.Lock()
-
 	var expireAt time.Time
 	if ttl > 0 {
 		expireAt = time.Now().Add(ttl)
 	}
 
-	mc.payload[key] = payloadWithExpire{
+	mc.store(key, payloadWithExpire{
 		payload:  p,
 		expireAt: expireAt,
-	}
-
-	mc.lock.Unlock()
+	})
 
 	return nil
 }
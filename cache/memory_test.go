@@ -1,6 +1,12 @@
 package cache
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	
@@ -57,3 +63,288 @@ func TestMemoryCacheInterfaceValue(t *testing.T) {
 	assert.Equal(t, getPeople(now).SayHello(), resp.SayHello())
 	lg.Info(resp.SayHello())
 }
+
+func TestMemoryCacheSingleflight(t *testing.T) {
+	c := NewMemoryCache(time.Second * 10)
+	defer c.Close()
+
+	var (
+		calls int32
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp string
+			err := c.GetOrCreateWithTTL("sf_key", time.Minute, func() (any, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return "value", nil
+			}, &resp)
+			assert.Nil(t, err)
+			assert.Equal(t, "value", resp)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestMemoryCacheStaleWhileRevalidate(t *testing.T) {
+	c := NewMemoryCache(time.Second * 10)
+	defer c.Close()
+
+	var version int32
+	creater := func() (any, error) {
+		return atomic.AddInt32(&version, 1), nil
+	}
+	v, err := c.GetOrCreateWithTTLObj("swr_key", 50*time.Millisecond, creater, WithStaleWhileRevalidate(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), v)
+
+	time.Sleep(60 * time.Millisecond)
+	v, err = c.GetOrCreateWithTTLObj("swr_key", 50*time.Millisecond, creater, WithStaleWhileRevalidate(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), v)
+
+	assert.Eventually(t, func() bool {
+		v, err := c.GetObj("swr_key")
+		return err == nil && v == int32(2)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMemoryCacheStaleKeptOnRefreshError(t *testing.T) {
+	c := NewMemoryCache(time.Second * 10)
+	defer c.Close()
+
+	_, err := c.GetOrCreateWithTTLObj("swr_err_key", 20*time.Millisecond, func() (any, error) {
+		return "good", nil
+	}, WithStaleWhileRevalidate(time.Second))
+	assert.Nil(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	failed := make(chan struct{})
+	v, err := c.GetOrCreateWithTTLObj("swr_err_key", 20*time.Millisecond, func() (any, error) {
+		defer close(failed)
+		return nil, errors.New("backend down")
+	}, WithStaleWhileRevalidate(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, "good", v)
+
+	<-failed
+	time.Sleep(10 * time.Millisecond)
+	v, err = c.GetOrCreateWithTTLObj("swr_err_key", 20*time.Millisecond, func() (any, error) {
+		return "new", nil
+	}, WithStaleWhileRevalidate(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, "good", v)
+}
+
+func TestMemoryCacheNegativeTTL(t *testing.T) {
+	c := NewMemoryCache(time.Second * 10)
+	defer c.Close()
+
+	var calls int32
+	creater := func() (any, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("not exists")
+	}
+	for i := 0; i < 3; i++ {
+		_, err := c.GetOrCreateWithTTLObj("neg_key", time.Minute, creater, WithNegativeTTL(30*time.Millisecond))
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	time.Sleep(40 * time.Millisecond)
+	_, err := c.GetOrCreateWithTTLObj("neg_key", time.Minute, creater, WithNegativeTTL(30*time.Millisecond))
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the negative ttl disables the negative caching
+	for i := 0; i < 2; i++ {
+		_, err = c.GetOrCreateWithTTLObj("neg_key_off", time.Minute, creater, WithNegativeTTL(-1))
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestEarlyExpiration(t *testing.T) {
+	opt := packGetOption(WithEarlyExpiration(1))
+	now := time.Now()
+
+	assert.False(t, opt.refreshEarly(now, time.Time{}, time.Second))
+	assert.False(t, opt.refreshEarly(now, now.Add(time.Hour), time.Millisecond))
+	assert.True(t, opt.refreshEarly(now, now, time.Millisecond))
+
+	early := 0
+	for i := 0; i < 1000; i++ {
+		if opt.refreshEarly(now, now.Add(time.Second), time.Second) {
+			early++
+		}
+	}
+	// the probability is 1/e
+	assert.InDelta(t, 368, early, 100)
+	assert.False(t, packGetOption().refreshEarly(now, now, time.Second))
+}
+
+func TestMemoryCacheLRU(t *testing.T) {
+	var evicted []string
+	c := NewMemoryCache(time.Second*10, WithMaxEntries(3), WithShards(1), WithOnEvict(func(key string, value any, reason EvictionReason) {
+		assert.Equal(t, EvictionCapacity, reason)
+		evicted = append(evicted, key)
+	}))
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	_, err := c.GetObj("a")
+	assert.Nil(t, err)
+	c.Set("d", 4)
+
+	assert.Equal(t, []string{"b"}, evicted)
+	_, err = c.GetObj("b")
+	assert.NotNil(t, err)
+
+	stats := c.Stats()
+	assert.Equal(t, 3, stats.Entries)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, 0.5, stats.HitRatio())
+}
+
+func TestMemoryCacheLFU(t *testing.T) {
+	c := NewMemoryCache(time.Second*10, WithMaxEntries(3), WithShards(1), WithEvictionPolicy(PolicyLFU))
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	for i := 0; i < 3; i++ {
+		c.GetObj("a")
+		c.GetObj("c")
+	}
+	c.GetObj("b")
+	c.Set("d", 4)
+	c.Set("e", 5)
+
+	_, err := c.GetObj("a")
+	assert.Nil(t, err)
+	_, err = c.GetObj("c")
+	assert.Nil(t, err)
+	_, err = c.GetObj("b")
+	assert.NotNil(t, err)
+	_, err = c.GetObj("d")
+	assert.NotNil(t, err)
+}
+
+func TestMemoryCacheTinyLFUScanResistance(t *testing.T) {
+	c := NewMemoryCache(time.Second*10, WithMaxEntries(100), WithShards(1), WithEvictionPolicy(PolicyTinyLFU))
+	defer c.Close()
+
+	for i := 0; i < 50; i++ {
+		c.Set(fmt.Sprintf("hot-%d", i), i)
+	}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			c.GetObj(fmt.Sprintf("hot-%d", i))
+		}
+	}
+	// a scan of the keys used once
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("scan-%d", i), i)
+	}
+
+	hot := 0
+	for i := 0; i < 50; i++ {
+		if _, err := c.GetObj(fmt.Sprintf("hot-%d", i)); err == nil {
+			hot++
+		}
+	}
+	assert.Greater(t, hot, 45)
+	assert.LessOrEqual(t, c.Stats().Entries, 100)
+}
+
+func TestMemoryCacheMaxBytes(t *testing.T) {
+	c := NewMemoryCache(time.Second*10, WithMaxBytes(1000), WithShards(1), WithSizer(func(key string, value any) int64 {
+		return int64(len(value.(string)))
+	}))
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), strings.Repeat("x", 100))
+	}
+	stats := c.Stats()
+	assert.Equal(t, 10, stats.Entries)
+	assert.Equal(t, int64(1000), stats.Bytes)
+	assert.Equal(t, uint64(10), stats.Evictions)
+
+	c.Delete("key-19")
+	assert.Equal(t, int64(900), c.Stats().Bytes)
+
+	// the replaced one does not take the room
+	c.Set("key-18", strings.Repeat("x", 50))
+	stats = c.Stats()
+	assert.Equal(t, 9, stats.Entries)
+	assert.Equal(t, int64(850), stats.Bytes)
+}
+
+func TestMemoryCacheNoSizerWithoutMaxBytes(t *testing.T) {
+	c := NewMemoryCache(time.Second*10, WithSizer(func(key string, value any) int64 {
+		t.Fatal("sizer called without max bytes")
+		return 0
+	}))
+	defer c.Close()
+
+	c.Set("key", "value")
+	assert.Equal(t, 1, c.Stats().Entries)
+	assert.Zero(t, c.Stats().Bytes)
+}
+
+func TestMemoryCacheExpiredCallback(t *testing.T) {
+	expired := make(chan string, 1)
+	c := NewMemoryCache(20*time.Millisecond, WithOnEvict(func(key string, value any, reason EvictionReason) {
+		if reason == EvictionExpired {
+			expired <- key
+		}
+	}))
+	defer c.Close()
+
+	c.SetWithTTL("ttl_key", 10*time.Millisecond, "value")
+	select {
+	case key := <-expired:
+		assert.Equal(t, "ttl_key", key)
+	case <-time.After(time.Second):
+		t.Fatal("not expired")
+	}
+	assert.Equal(t, uint64(1), c.Stats().Expirations)
+}
+
+func TestApproximateSize(t *testing.T) {
+	type user struct {
+		Name string
+		Tags []string
+		Data []byte
+	}
+	small := approximateSize("k", &user{Name: "a"})
+	large := approximateSize("k", &user{Name: "a", Tags: []string{"x", "y"}, Data: make([]byte, 1024)})
+	assert.Greater(t, large-small, int64(1024))
+	assert.Equal(t, int64(entryOverhead+1+16+5), approximateSize("k", "hello"))
+}
+
+func BenchmarkMemoryCacheParallelGet(b *testing.B) {
+	c := NewMemoryCache(time.Minute)
+	defer c.Close()
+	for i := 0; i < 1024; i++ {
+		c.Set(strconv.Itoa(i), i)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.GetObj(strconv.Itoa(i & 1023))
+			i++
+		}
+	})
+}
@@ -0,0 +1,346 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"hash/maphash"
+)
+
+// EvictionPolicy decides which entry is evicted when the MemoryCache is full.
+type EvictionPolicy int
+
+const (
+	// PolicyLRU evicts the least recently used entry.
+	PolicyLRU EvictionPolicy = iota
+	// PolicyLFU evicts the least frequently used entry, the least recently used one among the ties.
+	PolicyLFU
+	// PolicyTinyLFU is W-TinyLFU, which keeps the new entries in a small LRU window and only
+	// admits them into the main SLRU if they are used more frequently than the entries they replace.
+	// It resists the scans better than LRU and adapts to the changes better than LFU.
+	PolicyTinyLFU
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case PolicyLRU:
+		return "lru"
+	case PolicyLFU:
+		return "lfu"
+	case PolicyTinyLFU:
+		return "tinylfu"
+	}
+	return "unknown"
+}
+
+// policy tracks the usage of the keys in a shard, it is guarded by the lock of the shard.
+type policy interface {
+	add(key string)
+	access(key string)
+	remove(key string)
+	// victim returns the key to evict
+	victim() (string, bool)
+}
+
+func newPolicy(p EvictionPolicy, capacity int) policy {
+	switch p {
+	case PolicyLFU:
+		return newLFU()
+	case PolicyTinyLFU:
+		return newTinyLFU(capacity)
+	}
+	return newLRU()
+}
+
+// lruList is a list of the keys ordered by the recency, the front is the most recent.
+type lruList struct {
+	ll    *list.List
+	elems map[string]*list.Element
+}
+
+func newLRUList() *lruList {
+	return &lruList{ll: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (l *lruList) pushFront(key string) {
+	l.elems[key] = l.ll.PushFront(key)
+}
+
+func (l *lruList) moveToFront(key string) bool {
+	e, ok := l.elems[key]
+	if ok {
+		l.ll.MoveToFront(e)
+	}
+	return ok
+}
+
+func (l *lruList) remove(key string) bool {
+	e, ok := l.elems[key]
+	if ok {
+		l.ll.Remove(e)
+		delete(l.elems, key)
+	}
+	return ok
+}
+
+func (l *lruList) back() (string, bool) {
+	e := l.ll.Back()
+	if e == nil {
+		return "", false
+	}
+	return e.Value.(string), true
+}
+
+func (l *lruList) len() int {
+	return l.ll.Len()
+}
+
+type lru struct {
+	*lruList
+}
+
+func newLRU() *lru {
+	return &lru{newLRUList()}
+}
+
+func (l *lru) add(key string) {
+	l.pushFront(key)
+}
+
+func (l *lru) access(key string) {
+	l.moveToFront(key)
+}
+
+func (l *lru) remove(key string) {
+	l.lruList.remove(key)
+}
+
+func (l *lru) victim() (string, bool) {
+	return l.back()
+}
+
+type lfuItem struct {
+	key   string
+	freq  uint64
+	seq   uint64
+	index int
+}
+
+// lfu is a min heap of the keys by the frequency and then the recency.
+type lfu struct {
+	items []*lfuItem
+	byKey map[string]*lfuItem
+	seq   uint64
+}
+
+func newLFU() *lfu {
+	return &lfu{byKey: make(map[string]*lfuItem)}
+}
+
+func (l *lfu) Len() int { return len(l.items) }
+
+func (l *lfu) Less(i, j int) bool {
+	if l.items[i].freq != l.items[j].freq {
+		return l.items[i].freq < l.items[j].freq
+	}
+	return l.items[i].seq < l.items[j].seq
+}
+
+func (l *lfu) Swap(i, j int) {
+	l.items[i], l.items[j] = l.items[j], l.items[i]
+	l.items[i].index = i
+	l.items[j].index = j
+}
+
+func (l *lfu) Push(x any) {
+	item := x.(*lfuItem)
+	item.index = len(l.items)
+	l.items = append(l.items, item)
+}
+
+func (l *lfu) Pop() any {
+	item := l.items[len(l.items)-1]
+	l.items[len(l.items)-1] = nil
+	l.items = l.items[:len(l.items)-1]
+	return item
+}
+
+func (l *lfu) add(key string) {
+	l.seq++
+	item := &lfuItem{key: key, freq: 1, seq: l.seq}
+	l.byKey[key] = item
+	heap.Push(l, item)
+}
+
+func (l *lfu) access(key string) {
+	item, ok := l.byKey[key]
+	if !ok {
+		return
+	}
+	l.seq++
+	item.freq++
+	item.seq = l.seq
+	heap.Fix(l, item.index)
+}
+
+func (l *lfu) remove(key string) {
+	item, ok := l.byKey[key]
+	if !ok {
+		return
+	}
+	heap.Remove(l, item.index)
+	delete(l.byKey, key)
+}
+
+func (l *lfu) victim() (string, bool) {
+	if len(l.items) == 0 {
+		return "", false
+	}
+	return l.items[0].key, true
+}
+
+// countMinSketch estimates the frequencies of the keys with 4 rows of counters,
+// which are halved periodically so that the old frequencies fade out.
+type countMinSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	seed      maphash.Seed
+	additions int
+	resetAt   int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	s := &countMinSketch{mask: uint64(width - 1), seed: maphash.MakeSeed(), resetAt: width * 10}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) indexes(key string) [4]uint64 {
+	h := maphash.String(s.seed, key)
+	lo, hi := h&0xffffffff, h>>32
+	var idx [4]uint64
+	for i := range idx {
+		idx[i] = (lo + uint64(i)*hi) & s.mask
+	}
+	return idx
+}
+
+func (s *countMinSketch) increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < 15 {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		for i := range s.rows {
+			for j := range s.rows[i] {
+				s.rows[i][j] >>= 1
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	est := uint8(15)
+	for i, idx := range s.indexes(key) {
+		est = min(est, s.rows[i][idx])
+	}
+	return est
+}
+
+// tinyLFU keeps 1% of the entries in the window LRU, and the rest in the main SLRU,
+// whose protected segment holds 80% of it.
+type tinyLFU struct {
+	sketch    *countMinSketch
+	window    *lruList
+	probation *lruList
+	protected *lruList
+	// candidate is the last one moved from the window to the probation,
+	// which competes with the victim of the probation when the shard is full
+	candidate string
+	// capacity is the max entries, the segments are sized by the current entries if it is zero
+	capacity int
+}
+
+func newTinyLFU(capacity int) *tinyLFU {
+	width := capacity
+	if width <= 0 {
+		width = 1024
+	}
+	return &tinyLFU{
+		capacity:  capacity,
+		sketch:    newCountMinSketch(width),
+		window:    newLRUList(),
+		probation: newLRUList(),
+		protected: newLRUList(),
+	}
+}
+
+func (t *tinyLFU) add(key string) {
+	t.sketch.increment(key)
+	t.window.pushFront(key)
+
+	if t.window.len() > max(1, t.limit()/100) {
+		candidate, _ := t.window.back()
+		t.window.remove(candidate)
+		t.probation.pushFront(candidate)
+		t.candidate = candidate
+	}
+}
+
+func (t *tinyLFU) limit() int {
+	if t.capacity > 0 {
+		return t.capacity
+	}
+	return t.window.len() + t.probation.len() + t.protected.len()
+}
+
+func (t *tinyLFU) access(key string) {
+	t.sketch.increment(key)
+	if t.window.moveToFront(key) || t.protected.moveToFront(key) {
+		return
+	}
+	if !t.probation.remove(key) {
+		return
+	}
+	t.protected.pushFront(key)
+	// demote the least recent protected one when the protected segment is full
+	if t.protected.len() > max(1, t.limit()*8/10) {
+		demoted, _ := t.protected.back()
+		t.protected.remove(demoted)
+		t.probation.pushFront(demoted)
+	}
+}
+
+func (t *tinyLFU) remove(key string) {
+	if key == t.candidate {
+		t.candidate = ""
+	}
+	if !t.window.remove(key) && !t.probation.remove(key) {
+		t.protected.remove(key)
+	}
+}
+
+func (t *tinyLFU) victim() (string, bool) {
+	victim, ok := t.probation.back()
+	if !ok {
+		if victim, ok = t.protected.back(); !ok {
+			return t.window.back()
+		}
+	}
+
+	// the candidate is only admitted if it is used more frequently than the victim
+	candidate := t.candidate
+	t.candidate = ""
+	if candidate != "" && candidate != victim && t.sketch.estimate(candidate) <= t.sketch.estimate(victim) {
+		return candidate, true
+	}
+	return victim, true
+}
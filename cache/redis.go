@@ -7,7 +7,9 @@ import (
 	
 	"github.com/gomodule/redigo/redis"
 	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
 	"github.com/superwhys/venkit/vredis/v2"
+	"golang.org/x/sync/singleflight"
 )
 
 var _ Cache = (*RedisCache)(nil)
@@ -19,6 +21,7 @@ var (
 type RedisCache struct {
 	*vredis.RedisClient
 	prefix string
+	group  singleflight.Group
 }
 
 type RedisCacheOption func(c *RedisCache)
@@ -65,7 +68,7 @@ func (c *RedisCache) Delete(key string) error {
 
 func (c *RedisCache) setWithTTL(conn redis.Conn, key string, value any, ttl time.Duration) (err error) {
 	if ttl > 0 {
-		_, err = conn.Do("SET", key, value, "EX", int(ttl.Seconds()))
+		_, err = conn.Do("SET", key, value, "PX", ttl.Milliseconds())
 	} else {
 		_, err = conn.Do("SET", key, value)
 	}
@@ -81,41 +84,115 @@ func (c *RedisCache) packKey(key string) string {
 	return key
 }
 
-func (c *RedisCache) GetOrCreateWithTTL(key string, ttl time.Duration, creator Creater, out any) error {
-	conn := c.GetConn()
-	defer conn.Close()
+// redisPayload keeps the expiry in the value, since the key lives longer than it for the stale ttl.
+type redisPayload struct {
+	payload
+	// ExpireAt is the unix milliseconds the value expires at, zero means never
+	ExpireAt int64 `json:"expire_at,omitempty"`
+	// Delta is the milliseconds the creator took
+	Delta int64 `json:"delta,omitempty"`
+}
+
+func (c *RedisCache) load(conn redis.Conn, key string) (*redisPayload, error) {
+	data, err := redis.Bytes(conn.Do("GET", key))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "do.redis.get")
+	}
 	
+	p := &redisPayload{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal.redisData")
+	}
+	return p, nil
+}
+
+// GetOrCreateWithTTL gets the value of the key, or creates it if not found.
+// The concurrent creations of the key are deduplicated in the process, not across the processes.
+func (c *RedisCache) GetOrCreateWithTTL(key string, ttl time.Duration, creator Creater, out any, opts ...GetOptionFunc) error {
+	opt := packGetOption(opts...)
 	key = c.packKey(key)
 	
-	var p payload
-	data, err := redis.Bytes(conn.Do("GET", key))
-	// no data in redis
-	if err != nil && errors.Is(err, redis.ErrNil) {
-		p = newPayload(creator())
-		data, err = json.Marshal(p)
-		if err != nil {
-			return errors.Wrap(err, "json.Marshal.redisData")
+	conn := c.GetConn()
+	p, err := c.load(conn, key)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	
+	if p != nil {
+		now := time.Now()
+		var expireAt time.Time
+		if p.ExpireAt > 0 {
+			expireAt = time.UnixMilli(p.ExpireAt)
 		}
-		
-		if err = c.setWithTTL(conn, key, data, ttl); err != nil {
-			return errors.Wrap(err, "redis.setTTL")
+		// the expired value is only kept by the stale ttl
+		if (!expireAt.IsZero() && !now.Before(expireAt)) ||
+			opt.refreshEarly(now, expireAt, time.Duration(p.Delta)*time.Millisecond) {
+			c.refresh(key, ttl, creator, opt)
 		}
-		return nil
+		return p.Get(out)
 	}
 	
-	// other error
+	v, err, _ := c.group.Do(key, func() (any, error) {
+		return c.create(key, ttl, creator, opt, false)
+	})
 	if err != nil {
-		return errors.Wrap(err, "do.redis.get")
+		return err
 	}
 	
-	// get data from redis
-	if err := json.Unmarshal(data, &p); err != nil {
+	p = &redisPayload{}
+	if err := json.Unmarshal(v.([]byte), p); err != nil {
 		return errors.Wrap(err, "json.Unmarshal.redisData")
 	}
-	
 	return p.Get(out)
 }
 
+// refresh creates the value of the key in background, unless it is being created.
+func (c *RedisCache) refresh(key string, ttl time.Duration, creator Creater, opt *GetOption) {
+	c.group.DoChan(key, func() (any, error) {
+		data, err := c.create(key, ttl, creator, opt, true)
+		if err != nil {
+			lg.Warnf("RedisCache refresh %v error: %v", key, err)
+		}
+		return data, err
+	})
+}
+
+// create runs the creator and stores the payload, the failed refresh keeps the stale one.
+func (c *RedisCache) create(key string, ttl time.Duration, creator Creater, opt *GetOption, refresh bool) ([]byte, error) {
+	start := time.Now()
+	p := redisPayload{payload: newPayload(creator())}
+	p.Delta = time.Since(start).Milliseconds()
+	
+	if p.Error != nil && refresh {
+		return nil, p.Error
+	}
+	ttl, ok := opt.ttlOf(p.payload, ttl)
+	keyTTL := ttl
+	if ttl > 0 {
+		p.ExpireAt = time.Now().Add(ttl).UnixMilli()
+		keyTTL += opt.StaleTTL
+	}
+	
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "json.Marshal.redisData")
+	}
+	if !ok {
+		return data, nil
+	}
+	
+	conn := c.GetConn()
+	defer conn.Close()
+	if err = c.setWithTTL(conn, key, data, keyTTL); err != nil {
+		return nil, errors.Wrap(err, "redis.setTTL")
+	}
+	return data, nil
+}
+
 func (c *RedisCache) SetWithTTL(key string, value any, ttl time.Duration) error {
 	p := payload{Content: value}
 	data, err := json.Marshal(p)
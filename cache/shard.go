@@ -0,0 +1,247 @@
+package cache
+
+import (
+	"reflect"
+	"sync"
+)
+
+// EvictionReason is why an entry is removed from the MemoryCache by itself.
+type EvictionReason int
+
+const (
+	// EvictionCapacity means the entry is evicted by the policy since the cache is full.
+	EvictionCapacity EvictionReason = iota
+	// EvictionExpired means the expired entry is swept.
+	EvictionExpired
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionCapacity:
+		return "capacity"
+	case EvictionExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// entryOverhead is the approximate bytes of the bookkeeping of an entry.
+const entryOverhead = 64
+
+type memoryEntry struct {
+	payloadWithExpire
+	size int64
+}
+
+type evictedEntry struct {
+	key    string
+	value  any
+	reason EvictionReason
+}
+
+// memoryShard holds a part of the keys of the MemoryCache, so that the keys in the
+// other shards are not blocked. The capacity is split evenly among the shards.
+type memoryShard struct {
+	lock    sync.RWMutex
+	entries map[string]*memoryEntry
+	// policy is nil if the shard is unbounded
+	policy     policy
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+}
+
+func newMemoryShard(p EvictionPolicy, maxEntries int, maxBytes int64) *memoryShard {
+	s := &memoryShard{
+		entries:    make(map[string]*memoryEntry),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+	if maxEntries > 0 || maxBytes > 0 {
+		s.policy = newPolicy(p, maxEntries)
+	}
+	return s
+}
+
+// get returns the payload of the key even if it expires, the access is recorded if it does not.
+// The unbounded shard reads under the read lock, since it has no policy to update.
+func (s *memoryShard) get(key string) (payloadWithExpire, bool) {
+	if s.policy == nil {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		e, ok := s.entries[key]
+		if !ok {
+			return payloadWithExpire{}, false
+		}
+		return e.payloadWithExpire, true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return payloadWithExpire{}, false
+	}
+	if !e.IsExpire() {
+		s.policy.access(key)
+	}
+	return e.payloadWithExpire, true
+}
+
+// set stores the payload, and returns the entries evicted for it.
+func (s *memoryShard) set(key string, p payloadWithExpire, size int64) []evictedEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// closed
+	if s.entries == nil {
+		return nil
+	}
+
+	// the old one is replaced, so it does not take the room
+	old, exists := s.entries[key]
+	if exists {
+		delete(s.entries, key)
+		s.bytes -= old.size
+	}
+
+	// the room is made before adding the new key, otherwise the new key is the victim of LFU
+	var evicted []evictedEntry
+	for s.policy != nil && s.full(size) {
+		victim, ok := s.policy.victim()
+		if !ok {
+			break
+		}
+		e, ok := s.entries[victim]
+		if !ok {
+			// the replaced key
+			s.policy.remove(victim)
+			exists = false
+			continue
+		}
+		s.removeLocked(victim)
+		evicted = append(evicted, evictedEntry{key: victim, value: e.payload.Content, reason: EvictionCapacity})
+	}
+
+	if s.policy != nil {
+		if exists {
+			s.policy.access(key)
+		} else {
+			s.policy.add(key)
+		}
+	}
+	s.entries[key] = &memoryEntry{payloadWithExpire: p, size: size}
+	s.bytes += size
+	return evicted
+}
+
+// full reports whether the shard overflows after an entry of the size is added.
+func (s *memoryShard) full(size int64) bool {
+	if len(s.entries) == 0 {
+		return false
+	}
+	return (s.maxEntries > 0 && len(s.entries)+1 > s.maxEntries) || (s.maxBytes > 0 && s.bytes+size > s.maxBytes)
+}
+
+func (s *memoryShard) removeLocked(key string) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	s.bytes -= e.size
+	if s.policy != nil {
+		s.policy.remove(key)
+	}
+}
+
+func (s *memoryShard) delete(key string) {
+	s.lock.Lock()
+	s.removeLocked(key)
+	s.lock.Unlock()
+}
+
+// sweep removes the expired entries which can not be served as stale.
+func (s *memoryShard) sweep() []evictedEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var expired []evictedEntry
+	for key, e := range s.entries {
+		if e.IsExpire() && !e.IsStale() {
+			s.removeLocked(key)
+			expired = append(expired, evictedEntry{key: key, value: e.payload.Content, reason: EvictionExpired})
+		}
+	}
+	return expired
+}
+
+func (s *memoryShard) close() {
+	s.lock.Lock()
+	s.entries = nil
+	s.bytes = 0
+	s.lock.Unlock()
+}
+
+func (s *memoryShard) stats() (int, int64) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.entries), s.bytes
+}
+
+// approximateSize estimates the bytes of the entry by walking the value, which does not
+// count the padding and the shared memory exactly.
+func approximateSize(key string, value any) int64 {
+	return entryOverhead + int64(len(key)) + sizeOf(reflect.ValueOf(value), 0)
+}
+
+func sizeOf(v reflect.Value, depth int) int64 {
+	if !v.IsValid() {
+		return 0
+	}
+	size := int64(v.Type().Size())
+	// the deep or cyclic values are counted by their headers only
+	if depth > 8 {
+		return size
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return size + int64(v.Len())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return size
+		}
+		return size + sizeOf(v.Elem(), depth+1)
+	case reflect.Slice:
+		return size + elemsSize(v, depth)
+	case reflect.Array:
+		return elemsSize(v, depth)
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			size += sizeOf(iter.Key(), depth+1) + sizeOf(iter.Value(), depth+1)
+		}
+		return size
+	case reflect.Struct:
+		size = 0
+		for i := 0; i < v.NumField(); i++ {
+			size += sizeOf(v.Field(i), depth+1)
+		}
+		return size
+	}
+	return size
+}
+
+func elemsSize(v reflect.Value, depth int) int64 {
+	switch v.Type().Elem().Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return int64(v.Len()) * int64(v.Type().Elem().Size())
+	}
+	var size int64
+	for i := 0; i < v.Len(); i++ {
+		size += sizeOf(v.Index(i), depth+1)
+	}
+	return size
+}
@@ -1,13 +1,24 @@
 package dialer
 
 import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"os"
 	"time"
 
+	"github.com/pkg/errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+const (
+	DefaultMaxIdleConns    = 10
+	DefaultMaxOpenConns    = 100
+	DefaultConnMaxLifetime = time.Hour
+)
+
 type DialOption struct {
 	User     string
 	Password string
@@ -15,6 +26,30 @@ type DialOption struct {
 
 	SqliteCache bool
 	Logger      logger.Interface
+
+	// SSLMode is the sslmode of postgres, e.g. disable, require, verify-full
+	SSLMode string
+	// SearchPath is the schemas searched by postgres in order
+	SearchPath []string
+
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	ConnectTimeout time.Duration
+	// ReadTimeout and WriteTimeout are the io timeouts, which are supported by mysql only
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	TLSConfig *tls.Config
+	// TimeZone is the location of the time values, e.g. Asia/Shanghai, default is Local
+	TimeZone string
+	// Params are the extra params of the dsn, which override the default ones such as charset
+	Params map[string]string
+
+	// Replicas are the services of the mysql read replicas
+	Replicas []string
 }
 
 type OptionFunc func(*DialOption)
@@ -38,6 +73,79 @@ func WithSqliteCache() OptionFunc {
 	}
 }
 
+// WithSSLMode sets the sslmode of postgres, default is disable.
+func WithSSLMode(mode string) OptionFunc {
+	return func(do *DialOption) {
+		do.SSLMode = mode
+	}
+}
+
+// WithSearchPath sets the schemas searched by postgres.
+func WithSearchPath(schemas ...string) OptionFunc {
+	return func(do *DialOption) {
+		do.SearchPath = schemas
+	}
+}
+
+// WithReplicas routes the reads to the read replicas registered as the services,
+// the writes and transactions are still sent to the primary.
+func WithReplicas(services ...string) OptionFunc {
+	return func(do *DialOption) {
+		do.Replicas = append(do.Replicas, services...)
+	}
+}
+
+// WithPool sets the max idle and open connections, the zero keeps the default.
+func WithPool(maxIdle, maxOpen int) OptionFunc {
+	return func(do *DialOption) {
+		do.MaxIdleConns = maxIdle
+		do.MaxOpenConns = maxOpen
+	}
+}
+
+func WithConnMaxLifetime(d time.Duration) OptionFunc {
+	return func(do *DialOption) {
+		do.ConnMaxLifetime = d
+	}
+}
+
+func WithConnMaxIdleTime(d time.Duration) OptionFunc {
+	return func(do *DialOption) {
+		do.ConnMaxIdleTime = d
+	}
+}
+
+// WithTimeouts sets the connect, read and write timeouts, the zero means no timeout.
+func WithTimeouts(connect, read, write time.Duration) OptionFunc {
+	return func(do *DialOption) {
+		do.ConnectTimeout = connect
+		do.ReadTimeout = read
+		do.WriteTimeout = write
+	}
+}
+
+func WithTLSConfig(conf *tls.Config) OptionFunc {
+	return func(do *DialOption) {
+		do.TLSConfig = conf
+	}
+}
+
+func WithTimeZone(tz string) OptionFunc {
+	return func(do *DialOption) {
+		do.TimeZone = tz
+	}
+}
+
+// WithParam adds an extra param to the dsn.
+func WithParam(key, value string) OptionFunc {
+	return func(do *DialOption) {
+		if do.Params == nil {
+			do.Params = make(map[string]string)
+		}
+		do.Params[key] = value
+	}
+}
+
 func WithLogger(log logger.Interface) OptionFunc {
 	return func(do *DialOption) {
 		do.Logger = log
@@ -57,10 +165,52 @@ func packDialOption(opts ...OptionFunc) *DialOption {
 	return opt
 }
 
-func configDB(sqlDB *sql.DB) {
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetConnMaxLifetime(time.Hour)
+func configDB(sqlDB *sql.DB, opt *DialOption) {
+	sqlDB.SetMaxIdleConns(cmp.Or(opt.MaxIdleConns, DefaultMaxIdleConns))
+	sqlDB.SetMaxOpenConns(cmp.Or(opt.MaxOpenConns, DefaultMaxOpenConns))
+	sqlDB.SetConnMaxLifetime(cmp.Or(opt.ConnMaxLifetime, DefaultConnMaxLifetime))
+	sqlDB.SetConnMaxIdleTime(opt.ConnMaxIdleTime)
+}
+
+// TLSFiles is the tls of the database connection loaded from files, which is enabled once any of
+// its fields is set, e.g. only the ServerName for the server certificate signed by the system CAs.
+type TLSFiles struct {
+	CAFile             string `desc:"tls ca file, the system CAs are used if it is empty"`
+	CertFile           string `desc:"tls client certificate file"`
+	KeyFile            string `desc:"tls client key file"`
+	ServerName         string `desc:"tls server name"`
+	InsecureSkipVerify bool   `desc:"tls skips the verification of the server certificate"`
+}
+
+func (f *TLSFiles) Enabled() bool {
+	return *f != TLSFiles{}
+}
+
+// Load returns the tls config, the system CAs are used if CAFile is empty.
+func (f *TLSFiles) Load() (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         f.ServerName,
+		InsecureSkipVerify: f.InsecureSkipVerify,
+	}
+	if f.CAFile != "" {
+		ca, err := os.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.Errorf("no certificate in ca file %s", f.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	if f.CertFile != "" || f.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
 }
 
 func defaultGormConfig(opt *DialOption) *gorm.Config {
@@ -0,0 +1,155 @@
+package dialer
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []OptionFunc
+		wantUser   string
+		wantPasswd string
+		wantDB     string
+		wantParams map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "default",
+			wantUser:   DefaultUserName,
+			wantParams: map[string]string{"charset": "utf8mb4", "loc": "Local"},
+		},
+		{
+			name:       "auth",
+			opts:       []OptionFunc{WithAuth("user", "p@ss:w/rd?"), WithDBName("app")},
+			wantUser:   "user",
+			wantPasswd: "p@ss:w/rd?",
+			wantDB:     "app",
+			wantParams: map[string]string{"charset": "utf8mb4", "loc": "Local"},
+		},
+		{
+			name:       "params",
+			opts:       []OptionFunc{WithTimeZone("UTC"), WithParam("charset", "utf8"), WithParam("sql_mode", "ANSI")},
+			wantUser:   DefaultUserName,
+			wantParams: map[string]string{"charset": "utf8", "loc": "UTC", "sql_mode": "ANSI"},
+		},
+		{
+			name:    "invalid-time-zone",
+			opts:    []OptionFunc{WithTimeZone("Mars/Olympus")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn, err := generateDSN("127.0.0.1:3306", packDialOption(tt.opts...))
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+
+			conf, err := gomysql.ParseDSN(dsn)
+			assert.Nil(t, err)
+			assert.Equal(t, "127.0.0.1:3306", conf.Addr)
+			assert.Equal(t, tt.wantUser, conf.User)
+			assert.Equal(t, tt.wantPasswd, conf.Passwd)
+			assert.Equal(t, tt.wantDB, conf.DBName)
+			assert.True(t, conf.ParseTime)
+			for key, value := range tt.wantParams {
+				if key == "charset" || key == "loc" {
+					// parsed into the fields of the config
+					continue
+				}
+				assert.Equal(t, value, conf.Params[key])
+			}
+			loc, _ := time.LoadLocation(tt.wantParams["loc"])
+			assert.Equal(t, loc.String(), conf.Loc.String())
+
+			redacted := mysqlDSNRedacted(conf)
+			if tt.wantPasswd != "" {
+				assert.NotContains(t, redacted, tt.wantPasswd)
+				assert.Contains(t, redacted, "xxxxxx")
+			} else {
+				assert.NotContains(t, redacted, "xxxxxx")
+			}
+		})
+	}
+}
+
+func TestGenerateDSNTLS(t *testing.T) {
+	conf1 := &tls.Config{ServerName: "db1"}
+	conf2 := &tls.Config{ServerName: "db2"}
+
+	// the configs of the same address do not overwrite each other
+	tlsName := func(conf *tls.Config) string {
+		dsn, err := generateDSN("127.0.0.1:3306", packDialOption(WithTLSConfig(conf)))
+		assert.Nil(t, err)
+		parsed, err := gomysql.ParseDSN(dsn)
+		assert.Nil(t, err)
+		assert.Equal(t, conf.ServerName, parsed.TLS.ServerName)
+		return parsed.TLSConfig
+	}
+	name1, name2 := tlsName(conf1), tlsName(conf2)
+	assert.NotEqual(t, name1, name2)
+	assert.Equal(t, name1, tlsName(conf1))
+}
+
+func TestGeneratePostgresDSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []OptionFunc
+		wantParams map[string]string
+	}{
+		{
+			name:       "default",
+			opts:       []OptionFunc{WithAuth("user", "p@ss:w/rd?"), WithDBName("app")},
+			wantParams: map[string]string{},
+		},
+		{
+			name: "options",
+			opts: []OptionFunc{
+				WithAuth("user", "p@ss:w/rd?"),
+				WithDBName("app"),
+				WithSearchPath("tenant", "public"),
+				WithTimeouts(1500*time.Millisecond, 0, 0),
+				WithTimeZone("UTC"),
+				WithParam("application_name", "venkit"),
+			},
+			wantParams: map[string]string{
+				"search_path":      "tenant,public",
+				"timezone":         "UTC",
+				"application_name": "venkit",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := packDialOption(tt.opts...)
+			conf, err := pgx.ParseConfig(generatePostgresDSN("127.0.0.1:5432", opt))
+			assert.Nil(t, err)
+			assert.Equal(t, "127.0.0.1", conf.Host)
+			assert.EqualValues(t, 5432, conf.Port)
+			assert.Equal(t, "user", conf.User)
+			assert.Equal(t, "p@ss:w/rd?", conf.Password)
+			assert.Equal(t, "app", conf.Database)
+			// sslmode disable
+			assert.Nil(t, conf.TLSConfig)
+			for key, value := range tt.wantParams {
+				assert.Equal(t, value, conf.RuntimeParams[key])
+			}
+			if opt.ConnectTimeout > 0 {
+				assert.Equal(t, time.Second, conf.ConnectTimeout)
+			}
+
+			redacted := postgresURL("127.0.0.1:5432", opt).Redacted()
+			assert.NotContains(t, redacted, "p@ss")
+			assert.Contains(t, redacted, "user:xxxxx@")
+		})
+	}
+}
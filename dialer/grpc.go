@@ -2,14 +2,43 @@ package dialer
 
 import (
 	"context"
+	"net"
 	"time"
 	
+	"github.com/pkg/errors"
 	"github.com/superwhys/venkit/lg/v2"
 	"github.com/superwhys/venkit/v2/discover"
+	"github.com/superwhys/venkit/v2/resilience"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// grpcTarget returns the address itself if it is a host:port, otherwise the
+// venkit target, which is resolved and kept up to date by the service finder.
+func grpcTarget(service, tag string) string {
+	if _, _, err := net.SplitHostPort(service); err == nil {
+		return service
+	}
+	return discover.GrpcTarget(service, tag)
+}
+
+// resilienceOptions retries the calls and the creation of the streams within the retry budget of
+// the default resilience manager. The breakers are checked by the balancer set in the service config.
+func resilienceOptions(service, tag string) []grpc.DialOption {
+	name := service
+	if _, _, err := net.SplitHostPort(service); err != nil && tag != "" {
+		name = service + ":" + tag
+	}
+	m := resilience.Default()
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(resilience.UnaryClientInterceptor(m, name)),
+		grpc.WithChainStreamInterceptor(resilience.StreamClientInterceptor(m, name)),
+	}
+}
+
+// DialGrpc dials the service with the default GrpcDialConfig, which guards the calls with the circuit
+// breakers and the retry budget, dial by the GrpcDialConfig with DisableResilience set to opt out.
 func DialGrpc(service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	return DialGrpcWithTimeOut(10*time.Second, service, opts...)
 }
@@ -46,41 +75,64 @@ func DialGrpcWithTagContext(ctx context.Context, service string, tag string, opt
 }
 
 func dialGrpcWithTagContextUnblock(ctx context.Context, service string, tag string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	options := append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	options = append(options, opts...)
-	
-	address := discover.GetServiceFinder().GetAddressWithTag(service, tag)
-	
-	conn, err := grpc.DialContext(
-		ctx,
-		address,
-		options...,
-	)
-	
-	if tag != "" {
-		lg.Debugc(ctx, "dial grpc service %s with tag %s. Addr=%s", service, tag, address)
-	} else {
-		lg.Debugc(ctx, "dial grpc service %s. Addr=%s", service, address)
-	}
-	return conn, err
+	conf := &GrpcDialConfig{}
+	return conf.Dial(ctx, service, tag, opts...)
 }
 
 func dialGrpcWithTagContext(ctx context.Context, service, tag string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	options := append(opts, grpc.WithBlock(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conf := &GrpcDialConfig{}
+	conn, err := conf.Dial(ctx, service, tag, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := waitReady(ctx, conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "dial grpc service %s", service)
+	}
+	return conn, nil
+}
+
+// Dial creates the connection to the service by the config, which connects lazily on the first call.
+// The opts are applied after the ones of the config.
+func (c *GrpcDialConfig) Dial(ctx context.Context, service, tag string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	options, err := c.DialOptions()
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	options = append(options, opts...)
+	// the retries of the service config would be multiplied by the ones of the interceptor
+	if !c.DisableResilience && c.retryPolicy() == nil {
+		options = append(options, resilienceOptions(service, tag)...)
+	}
 	
-	address := discover.GetServiceFinder().GetAddressWithTag(service, tag)
-	
-	conn, err := grpc.DialContext(
-		ctx,
-		address,
-		options...,
-	)
-	
+	address := grpcTarget(service, tag)
 	if tag != "" {
 		lg.Debugc(ctx, "dial grpc service %s with tag %s. Addr=%s", service, tag, address)
 	} else {
 		lg.Debugc(ctx, "dial grpc service %s. Addr=%s", service, address)
 	}
-	return conn, err
+	
+	conn, err := grpc.NewClient(address, options...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new grpc client of %s", service)
+	}
+	return conn, nil
+}
+
+// waitReady connects the conn and waits until it is ready, which replaces the deprecated grpc.WithBlock.
+func waitReady(ctx context.Context, conn *grpc.ClientConn) error {
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if state == connectivity.Shutdown {
+			return errors.New("grpc connection is shutdown")
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
 }
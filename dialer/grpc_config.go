@@ -0,0 +1,289 @@
+package dialer
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/resilience"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	DefaultGrpcLoadBalancing     = "round_robin"
+	DefaultGrpcInitialBackoff    = 100 * time.Millisecond
+	DefaultGrpcMaxBackoff        = time.Second
+	DefaultGrpcBackoffMultiplier = 2
+)
+
+// GrpcRetryPolicy is the retry policy of the grpc service config, which retries the calls
+// failed with the retryable status codes before any response is received.
+type GrpcRetryPolicy struct {
+	MaxAttempts          int           `desc:"grpc max attempts of a call including the original one, 0 disables the retry"`
+	InitialBackoff       time.Duration `desc:"grpc initial backoff of the retry (default 100ms)"`
+	MaxBackoff           time.Duration `desc:"grpc max backoff of the retry (default 1s)"`
+	BackoffMultiplier    float64       `desc:"grpc backoff multiplier of the retry (default 2)"`
+	RetryableStatusCodes []string      `desc:"grpc status codes to retry (default UNAVAILABLE)"`
+}
+
+// GrpcDialConfig configures the grpc client connections, it can be loaded by vflags,
+// e.g. vflags.Struct("grpcClient", &dialer.GrpcDialConfig{}, "grpc client config").
+type GrpcDialConfig struct {
+	LoadBalancing string `desc:"grpc load balancing policy (default round_robin)"`
+	Retry         GrpcRetryPolicy
+	Timeout       time.Duration `desc:"grpc default timeout of the calls, 0 means no timeout"`
+	// MethodTimeouts are the timeouts by the method like pkg.Service/Method, or by the service like pkg.Service
+	MethodTimeouts map[string]time.Duration
+
+	KeepaliveTime                time.Duration `desc:"grpc keepalive ping interval, 0 disables the keepalive"`
+	KeepaliveTimeout             time.Duration `desc:"grpc keepalive ping timeout"`
+	KeepalivePermitWithoutStream bool          `desc:"grpc sends keepalive pings without active calls"`
+
+	MaxRecvMsgSize int    `desc:"grpc max received message size in bytes (default 4MB)"`
+	MaxSendMsgSize int    `desc:"grpc max sent message size in bytes"`
+	UserAgent      string `desc:"grpc user agent"`
+	Compression    string `desc:"grpc compressor of the calls, e.g. gzip"`
+
+	// The calls are guarded with the circuit breakers of the addresses and the retry budget of the default
+	// resilience manager unless DisableResilience is set. The addresses are balanced by resilience.BalancerName
+	// in turn, so LoadBalancing should be empty or round_robin. The calls are retried by the Retry policy
+	// instead of the retry budget if its MaxAttempts is set.
+	DisableResilience bool `desc:"do not guard the calls with the circuit breakers and the retry budget"`
+
+	UnaryInterceptors  []grpc.UnaryClientInterceptor  `vflags:"-" json:"-"`
+	StreamInterceptors []grpc.StreamClientInterceptor `vflags:"-" json:"-"`
+}
+
+type grpcMethodName struct {
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+}
+
+type grpcRetryPolicyConfig struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+type grpcMethodConfig struct {
+	Name        []grpcMethodName       `json:"name"`
+	Timeout     string                 `json:"timeout,omitempty"`
+	RetryPolicy *grpcRetryPolicyConfig `json:"retryPolicy,omitempty"`
+}
+
+type grpcServiceConfig struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig"`
+	MethodConfig        []grpcMethodConfig    `json:"methodConfig,omitempty"`
+}
+
+// grpcDuration formats the duration like 1.5s as required by the service config.
+func grpcDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+func (c *GrpcDialConfig) retryPolicy() *grpcRetryPolicyConfig {
+	if c.Retry.MaxAttempts < 2 {
+		return nil
+	}
+	policy := &grpcRetryPolicyConfig{
+		MaxAttempts:          c.Retry.MaxAttempts,
+		InitialBackoff:       grpcDuration(DefaultGrpcInitialBackoff),
+		MaxBackoff:           grpcDuration(DefaultGrpcMaxBackoff),
+		BackoffMultiplier:    DefaultGrpcBackoffMultiplier,
+		RetryableStatusCodes: []string{"UNAVAILABLE"},
+	}
+	if c.Retry.InitialBackoff > 0 {
+		policy.InitialBackoff = grpcDuration(c.Retry.InitialBackoff)
+	}
+	if c.Retry.MaxBackoff > 0 {
+		policy.MaxBackoff = grpcDuration(c.Retry.MaxBackoff)
+	}
+	if c.Retry.BackoffMultiplier > 0 {
+		policy.BackoffMultiplier = c.Retry.BackoffMultiplier
+	}
+	if len(c.Retry.RetryableStatusCodes) != 0 {
+		policy.RetryableStatusCodes = nil
+		for _, code := range c.Retry.RetryableStatusCodes {
+			policy.RetryableStatusCodes = append(policy.RetryableStatusCodes, strings.ToUpper(strings.TrimSpace(code)))
+		}
+	}
+	return policy
+}
+
+// ServiceConfig returns the default service config json of the connections.
+func (c *GrpcDialConfig) ServiceConfig() (string, error) {
+	lb := DefaultGrpcLoadBalancing
+	if c.LoadBalancing != "" {
+		lb = c.LoadBalancing
+	}
+	if !c.DisableResilience {
+		if lb != DefaultGrpcLoadBalancing {
+			return "", errors.Errorf("grpc load balancing %s conflicts with resilience, which balances in turn, set DisableResilience to use it", lb)
+		}
+		lb = resilience.BalancerName
+	}
+	conf := grpcServiceConfig{
+		LoadBalancingConfig: []map[string]struct{}{{lb: {}}},
+	}
+
+	retry := c.retryPolicy()
+	if c.Timeout > 0 || retry != nil {
+		mc := grpcMethodConfig{Name: []grpcMethodName{{}}, RetryPolicy: retry}
+		if c.Timeout > 0 {
+			mc.Timeout = grpcDuration(c.Timeout)
+		}
+		conf.MethodConfig = append(conf.MethodConfig, mc)
+	}
+	for name, timeout := range c.MethodTimeouts {
+		service, method, _ := strings.Cut(strings.Trim(name, "/"), "/")
+		if service == "" {
+			return "", errors.Errorf("invalid grpc method name %q", name)
+		}
+		// the method config does not inherit the retry policy of the default one, so it is set again
+		conf.MethodConfig = append(conf.MethodConfig, grpcMethodConfig{
+			Name:        []grpcMethodName{{Service: service, Method: method}},
+			Timeout:     grpcDuration(timeout),
+			RetryPolicy: retry,
+		})
+	}
+
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal service config")
+	}
+	return string(b), nil
+}
+
+// DialOptions returns the dial options of the config, without the credentials and the target.
+func (c *GrpcDialConfig) DialOptions() ([]grpc.DialOption, error) {
+	serviceConfig, err := c.ServiceConfig()
+	if err != nil {
+		return nil, err
+	}
+	options := []grpc.DialOption{grpc.WithDefaultServiceConfig(serviceConfig)}
+
+	if c.KeepaliveTime > 0 {
+		options = append(options, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.KeepaliveTime,
+			Timeout:             c.KeepaliveTimeout,
+			PermitWithoutStream: c.KeepalivePermitWithoutStream,
+		}))
+	}
+
+	var callOptions []grpc.CallOption
+	if c.MaxRecvMsgSize > 0 {
+		callOptions = append(callOptions, grpc.MaxCallRecvMsgSize(c.MaxRecvMsgSize))
+	}
+	if c.MaxSendMsgSize > 0 {
+		callOptions = append(callOptions, grpc.MaxCallSendMsgSize(c.MaxSendMsgSize))
+	}
+	if c.Compression != "" {
+		callOptions = append(callOptions, grpc.UseCompressor(c.Compression))
+	}
+	if len(callOptions) != 0 {
+		options = append(options, grpc.WithDefaultCallOptions(callOptions...))
+	}
+
+	if c.UserAgent != "" {
+		options = append(options, grpc.WithUserAgent(c.UserAgent))
+	}
+	if len(c.UnaryInterceptors) != 0 {
+		options = append(options, grpc.WithChainUnaryInterceptor(c.UnaryInterceptors...))
+	}
+	if len(c.StreamInterceptors) != 0 {
+		options = append(options, grpc.WithChainStreamInterceptor(c.StreamInterceptors...))
+	}
+	return options, nil
+}
+
+// GrpcPool caches the connections by the service and tag, so that the callers share the connections.
+// It is safe for concurrent use.
+type GrpcPool struct {
+	mu     sync.Mutex
+	conf   *GrpcDialConfig
+	conns  map[string]*grpc.ClientConn
+	closed bool
+}
+
+// NewGrpcPool returns the pool dialing the connections by the conf, a nil conf means the default one.
+func NewGrpcPool(conf *GrpcDialConfig) *GrpcPool {
+	if conf == nil {
+		conf = &GrpcDialConfig{}
+	}
+	return &GrpcPool{
+		conf:  conf,
+		conns: make(map[string]*grpc.ClientConn),
+	}
+}
+
+var (
+	defaultGrpcPoolMu sync.RWMutex
+	defaultGrpcPool   = NewGrpcPool(nil)
+)
+
+// DefaultGrpcPool returns the pool closed by the service at shutdown.
+func DefaultGrpcPool() *GrpcPool {
+	defaultGrpcPoolMu.RLock()
+	defer defaultGrpcPoolMu.RUnlock()
+	return defaultGrpcPool
+}
+
+// SetDefaultGrpcPool replaces the default pool, e.g. with the one of the config loaded by vflags.
+func SetDefaultGrpcPool(p *GrpcPool) {
+	defaultGrpcPoolMu.Lock()
+	defer defaultGrpcPoolMu.Unlock()
+	defaultGrpcPool = p
+}
+
+// Get returns the cached connection of the service and tag, which is created on the first call.
+func (p *GrpcPool) Get(service, tag string) (*grpc.ClientConn, error) {
+	key := service + ":" + tag
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errors.New("grpc pool closed")
+	}
+	if conn, exists := p.conns[key]; exists && conn.GetState() != connectivity.Shutdown {
+		return conn, nil
+	}
+
+	conn, err := p.conf.Dial(context.Background(), service, tag)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[key] = conn
+	return conn, nil
+}
+
+// Close closes all the connections, and the pool can not be used any more.
+func (p *GrpcPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	var firstErr error
+	for key, conn := range p.conns {
+		if err := conn.Close(); err != nil {
+			lg.Errorf("GrpcPool close %v error: %v", key, err)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "close %v", key)
+			}
+		}
+	}
+	p.conns = nil
+	return firstErr
+}
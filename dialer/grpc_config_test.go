@@ -0,0 +1,195 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superwhys/venkit/v2/resilience"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type testGrpcServer struct {
+	addr    string
+	calls   atomic.Int32
+	failing atomic.Int32
+	code    atomic.Uint32
+	delay   atomic.Int64
+}
+
+func startGrpcServer(t *testing.T) *testGrpcServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testGrpcServer{addr: lis.Addr().String()}
+	s.code.Store(uint32(codes.Unavailable))
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		s.calls.Add(1)
+		select {
+		case <-time.After(time.Duration(s.delay.Load())):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if s.failing.Add(-1) >= 0 {
+			return nil, status.Error(codes.Code(s.code.Load()), "failing")
+		}
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return s
+}
+
+func newHealthClient(t *testing.T, conf *GrpcDialConfig, addr string) healthpb.HealthClient {
+	options, err := conf.DialOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options = append(options, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient("passthrough:///"+addr, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestGrpcDialConfigRetryPolicy(t *testing.T) {
+	srv := startGrpcServer(t)
+	// the backoffs and the retryable codes are the defaults
+	client := newHealthClient(t, &GrpcDialConfig{Retry: GrpcRetryPolicy{MaxAttempts: 3}}, srv.addr)
+	ctx := context.Background()
+
+	srv.failing.Store(2)
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, srv.calls.Load())
+
+	// no more than the max attempts
+	srv.calls.Store(0)
+	srv.failing.Store(3)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.EqualValues(t, 3, srv.calls.Load())
+
+	// the codes not retryable are not retried
+	srv.calls.Store(0)
+	srv.failing.Store(1)
+	srv.code.Store(uint32(codes.Internal))
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.EqualValues(t, 1, srv.calls.Load())
+}
+
+func TestGrpcDialConfigRetryableStatusCodes(t *testing.T) {
+	srv := startGrpcServer(t)
+	client := newHealthClient(t, &GrpcDialConfig{Retry: GrpcRetryPolicy{
+		MaxAttempts:          2,
+		InitialBackoff:       time.Millisecond,
+		RetryableStatusCodes: []string{" internal "},
+	}}, srv.addr)
+
+	srv.failing.Store(1)
+	srv.code.Store(uint32(codes.Internal))
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, srv.calls.Load())
+}
+
+func TestGrpcDialConfigMethodTimeouts(t *testing.T) {
+	srv := startGrpcServer(t)
+	srv.delay.Store(int64(200 * time.Millisecond))
+	tests := []struct {
+		name     string
+		conf     *GrpcDialConfig
+		wantCode codes.Code
+	}{
+		{name: "no-timeout", conf: &GrpcDialConfig{}, wantCode: codes.OK},
+		{name: "default-timeout", conf: &GrpcDialConfig{Timeout: 20 * time.Millisecond}, wantCode: codes.DeadlineExceeded},
+		{
+			name: "method-timeout",
+			conf: &GrpcDialConfig{
+				Timeout:        20 * time.Millisecond,
+				MethodTimeouts: map[string]time.Duration{"/grpc.health.v1.Health/Check": time.Second},
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "service-timeout",
+			conf: &GrpcDialConfig{
+				MethodTimeouts: map[string]time.Duration{"grpc.health.v1.Health": 20 * time.Millisecond},
+			},
+			wantCode: codes.DeadlineExceeded,
+		},
+		{
+			name: "other-method-timeout",
+			conf: &GrpcDialConfig{
+				MethodTimeouts: map[string]time.Duration{"grpc.health.v1.Health/Watch": 20 * time.Millisecond},
+			},
+			wantCode: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newHealthClient(t, tt.conf, srv.addr)
+			_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestGrpcDialConfigInvalid(t *testing.T) {
+	_, err := (&GrpcDialConfig{MethodTimeouts: map[string]time.Duration{"/": time.Second}}).DialOptions()
+	assert.NotNil(t, err)
+	_, err = (&GrpcDialConfig{LoadBalancing: "pick_first"}).DialOptions()
+	assert.NotNil(t, err)
+	_, err = (&GrpcDialConfig{LoadBalancing: "pick_first", DisableResilience: true}).DialOptions()
+	assert.Nil(t, err)
+}
+
+func TestGrpcDialConfigResilienceRetry(t *testing.T) {
+	old := resilience.Default()
+	t.Cleanup(func() { resilience.SetDefault(old) })
+	srv := startGrpcServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		conf      *GrpcDialConfig
+		wantCalls int32
+	}{
+		{name: "retry-budget", conf: &GrpcDialConfig{}, wantCalls: 3},
+		// the retries of the service config are not multiplied by the ones of the retry budget
+		{name: "retry-policy", conf: &GrpcDialConfig{Retry: GrpcRetryPolicy{MaxAttempts: 2}}, wantCalls: 2},
+		{name: "disabled", conf: &GrpcDialConfig{DisableResilience: true}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resilience.SetDefault(resilience.NewManager(resilience.WithRetryPolicy(resilience.RetryPolicy{
+				MaxAttempts: 3,
+				Backoff:     time.Millisecond,
+			})))
+			conn, err := tt.conf.Dial(ctx, srv.addr, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			srv.calls.Store(0)
+			srv.failing.Store(10)
+			_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+			assert.Equal(t, tt.wantCalls, srv.calls.Load())
+		})
+	}
+}
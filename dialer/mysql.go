@@ -1,41 +1,105 @@
 package dialer
 
 import (
+	"cmp"
+	"crypto/tls"
 	"database/sql"
 	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
 	
-	_ "github.com/go-sql-driver/mysql"
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/superwhys/venkit/lg/v2"
 	"github.com/superwhys/venkit/v2/discover"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
 const (
 	DefaultUserName = "root"
 )
 
-func generateDSN(address string, opt *DialOption) string {
-	dsn := fmt.Sprintf(
-		"%v:%v@tcp(%v)/%v?charset=utf8mb4&parseTime=True&loc=Local",
-		opt.User,
-		opt.Password,
-		address,
-		opt.DBName,
-	)
-	lg.Debugc(lg.Ctx, "sql dsn generate. dsn=%v", dsn)
-	return dsn
+var (
+	mysqlTLSNames sync.Map
+	mysqlTLSCount atomic.Int64
+)
+
+// registerMysqlTLS registers the tls config into the mysql driver, whose names are global in the process,
+// so each config is registered once by its own name.
+func registerMysqlTLS(conf *tls.Config) (string, error) {
+	if name, ok := mysqlTLSNames.Load(conf); ok {
+		return name.(string), nil
+	}
+	name := fmt.Sprintf("venkit-%d", mysqlTLSCount.Add(1))
+	if err := gomysql.RegisterTLSConfig(name, conf); err != nil {
+		return "", errors.Wrap(err, "register tls config")
+	}
+	if actual, loaded := mysqlTLSNames.LoadOrStore(conf, name); loaded {
+		gomysql.DeregisterTLSConfig(name)
+		return actual.(string), nil
+	}
+	return name, nil
+}
+
+// mysqlDSNRedacted returns the dsn without the password to be logged.
+func mysqlDSNRedacted(conf *gomysql.Config) string {
+	redacted := conf.Clone()
+	if redacted.Passwd != "" {
+		redacted.Passwd = "xxxxxx"
+	}
+	return redacted.FormatDSN()
+}
+
+func generateDSN(address string, opt *DialOption) (string, error) {
+	conf := gomysql.NewConfig()
+	conf.User = opt.User
+	conf.Passwd = opt.Password
+	conf.Net = "tcp"
+	conf.Addr = address
+	conf.DBName = opt.DBName
+	conf.ParseTime = true
+	conf.Timeout = opt.ConnectTimeout
+	conf.ReadTimeout = opt.ReadTimeout
+	conf.WriteTimeout = opt.WriteTimeout
+	
+	// loc is passed by name, since the name of time.Local may not be loadable
+	loc := cmp.Or(opt.TimeZone, "Local")
+	if _, err := time.LoadLocation(loc); err != nil {
+		return "", errors.Wrap(err, "load time zone")
+	}
+	conf.Params = map[string]string{"charset": "utf8mb4", "loc": loc}
+	maps.Copy(conf.Params, opt.Params)
+	
+	if opt.TLSConfig != nil {
+		name, err := registerMysqlTLS(opt.TLSConfig)
+		if err != nil {
+			return "", err
+		}
+		conf.TLSConfig = name
+	}
+	
+	lg.Debugc(lg.Ctx, "sql dsn generate. dsn=%v", mysqlDSNRedacted(conf))
+	return conf.FormatDSN(), nil
 }
 
 func DialMysqlGorm(service string, opts ...OptionFunc) (*gorm.DB, error) {
-	address := discover.GetServiceFinder().GetAddress(service)
+	address, err := discover.GetServiceFinder().FindAddressWithTag(service, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "discover mysql")
+	}
 	lg.Debugc(lg.Ctx, "Discover mysql addr. Addr=%v", address)
 	
 	opt := packDialOption(opts...)
 	
-	dsn := generateDSN(address, opt)
+	dsn, err := generateDSN(address, opt)
+	if err != nil {
+		return nil, err
+	}
 	db, err := gorm.Open(mysql.Open(dsn), defaultGormConfig(opt))
 	if err != nil {
 		return nil, err
@@ -46,18 +110,63 @@ func DialMysqlGorm(service string, opts ...OptionFunc) (*gorm.DB, error) {
 		return nil, err
 	}
 	
-	configDB(sqlDB)
+	configDB(sqlDB, opt)
+	if err := useMysqlReplicas(db, opt); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
+// useMysqlReplicas registers the resolver which routes the reads to the addresses of the replicas randomly,
+// the writes, transactions and the queries with dbresolver.Write are sent to the primary.
+func useMysqlReplicas(db *gorm.DB, opt *DialOption) error {
+	var replicas []gorm.Dialector
+	for _, service := range opt.Replicas {
+		addrs := discover.GetServiceFinder().GetAllAddress(service)
+		if len(addrs) == 0 {
+			lg.Warnc(lg.Ctx, "No address of mysql replica %s discovered, the reads fall back to the primary", service)
+			continue
+		}
+		lg.Debugc(lg.Ctx, "Discover mysql replica addrs. Service=%v Addrs=%v", service, addrs)
+		
+		for _, addr := range addrs {
+			dsn, err := generateDSN(addr, opt)
+			if err != nil {
+				return err
+			}
+			replicas = append(replicas, mysql.Open(dsn))
+		}
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+	
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.RandomPolicy{},
+	}).
+		SetMaxIdleConns(cmp.Or(opt.MaxIdleConns, DefaultMaxIdleConns)).
+		SetMaxOpenConns(cmp.Or(opt.MaxOpenConns, DefaultMaxOpenConns)).
+		SetConnMaxLifetime(cmp.Or(opt.ConnMaxLifetime, DefaultConnMaxLifetime)).
+		SetConnMaxIdleTime(opt.ConnMaxIdleTime)
+	return errors.Wrap(db.Use(resolver), "use mysql replicas")
+}
+
 // Deprecated: use DialMysqlGorm replace
 func DialGorm(service string, opts ...OptionFunc) (*gorm.DB, error) {
-	address := discover.GetServiceFinder().GetAddress(service)
+	address, err := discover.GetServiceFinder().FindAddressWithTag(service, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "discover mysql")
+	}
 	lg.Debugc(lg.Ctx, "Discover mysql addr. Addr=%v", address)
 	
 	opt := packDialOption(opts...)
 	
-	dsn := generateDSN(address, opt)
+	dsn, err := generateDSN(address, opt)
+	if err != nil {
+		return nil, err
+	}
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
 		PrepareStmt: true,
 	})
@@ -70,16 +179,22 @@ func DialGorm(service string, opts ...OptionFunc) (*gorm.DB, error) {
 		return nil, err
 	}
 	
-	configDB(sqlDB)
+	configDB(sqlDB, opt)
 	return db, nil
 }
 
 func DialMysql(service string, opts ...OptionFunc) (*sql.DB, error) {
-	address := discover.GetServiceFinder().GetAddress(service)
+	address, err := discover.GetServiceFinder().FindAddressWithTag(service, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "discover mysql")
+	}
 	
 	opt := packDialOption(opts...)
 	
-	dsn := generateDSN(address, opt)
+	dsn, err := generateDSN(address, opt)
+	if err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
@@ -90,7 +205,7 @@ func DialMysql(service string, opts ...OptionFunc) (*sql.DB, error) {
 		return nil, errors.Wrap(err, "db ping")
 	}
 	
-	configDB(db)
+	configDB(db, opt)
 	return db, nil
 }
 
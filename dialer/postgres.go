@@ -0,0 +1,121 @@
+package dialer
+
+import (
+	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/discover"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultPostgresUserName = "postgres"
+	DefaultPostgresSSLMode  = "disable"
+)
+
+func generatePostgresDSN(address string, opt *DialOption) string {
+	u := postgresURL(address, opt)
+	lg.Debugc(lg.Ctx, "postgres dsn generate. dsn=%v", u.Redacted())
+	return u.String()
+}
+
+func postgresURL(address string, opt *DialOption) *url.URL {
+	sslMode := opt.SSLMode
+	if sslMode == "" {
+		sslMode = DefaultPostgresSSLMode
+	}
+	query := url.Values{}
+	query.Set("sslmode", sslMode)
+	if len(opt.SearchPath) != 0 {
+		query.Set("search_path", strings.Join(opt.SearchPath, ","))
+	}
+	if opt.ConnectTimeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(max(int(opt.ConnectTimeout.Seconds()), 1)))
+	}
+	if opt.TimeZone != "" {
+		query.Set("timezone", opt.TimeZone)
+	}
+	for key, value := range opt.Params {
+		query.Set(key, value)
+	}
+
+	return &url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(opt.User, opt.Password),
+		Host:     address,
+		Path:     "/" + opt.DBName,
+		RawQuery: query.Encode(),
+	}
+}
+
+// openPostgres opens the sql.DB of the postgres service, the tls config of the option
+// overrides the one of the sslmode.
+func openPostgres(service string, opts []OptionFunc) (*sql.DB, *DialOption, error) {
+	address, err := discover.GetServiceFinder().FindAddressWithTag(service, "")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "discover postgres")
+	}
+	lg.Debugc(lg.Ctx, "Discover postgres addr. Addr=%v", address)
+
+	opt := packDialOption(append([]OptionFunc{WithAuth(DefaultPostgresUserName, "")}, opts...)...)
+	if opt.TLSConfig != nil && opt.SSLMode == "" {
+		opt.SSLMode = "require"
+	}
+	conf, err := pgx.ParseConfig(generatePostgresDSN(address, opt))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse postgres dsn")
+	}
+	if opt.TLSConfig != nil {
+		conf.TLSConfig = opt.TLSConfig.Clone()
+		conf.Fallbacks = nil
+	}
+
+	db := stdlib.OpenDB(*conf)
+	configDB(db, opt)
+	return db, opt, nil
+}
+
+func DialPostgresGorm(service string, opts ...OptionFunc) (*gorm.DB, error) {
+	sqlDB, opt, err := openPostgres(service, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), defaultGormConfig(opt))
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func DialPostgres(service string, opts ...OptionFunc) (*sql.DB, error) {
+	db, _, err := openPostgres(service, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "db ping")
+	}
+	return db, nil
+}
+
+func DialPostgresX(service string, opts ...OptionFunc) (*sqlx.DB, error) {
+	db, err := DialPostgres(service, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlx.NewDb(db, "pgx"), nil
+}
@@ -1,39 +1,225 @@
 package dialer
 
 import (
+	"crypto/tls"
+	"net"
 	"time"
-	
+
 	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
 	"github.com/superwhys/venkit/lg/v2"
 	"github.com/superwhys/venkit/v2/discover"
 )
 
+const (
+	DefaultRedisIdleTimeout    = 300 * time.Second
+	DefaultRedisConnectTimeout = 5 * time.Second
+	DefaultRedisPingIdleAfter  = time.Minute
+)
+
+type RedisDialOption struct {
+	DB int
+	// Username is the ACL user of redis 6+, the password is used alone if it is empty
+	Username  string
+	Password  string
+	TLSConfig *tls.Config
+
+	ConnectTimeout time.Duration
+
+	// SentinelUsername and SentinelPassword are the auth of the sentinels
+	SentinelUsername string
+	SentinelPassword string
+
+	// MaxActive is the max connections of the pool, zero means no limit
+	MaxActive int
+	// Wait makes Get wait for a connection to be returned when the pool is at MaxActive,
+	// otherwise Get returns redis.ErrPoolExhausted
+	Wait            bool
+	IdleTimeout     time.Duration
+	MaxConnLifetime time.Duration
+	// PingIdleAfter pings the connections idled longer than it when they are borrowed,
+	// the negative disables the ping
+	PingIdleAfter time.Duration
+}
+
+type RedisOptionFunc func(*RedisDialOption)
+
+func WithRedisDB(db int) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.DB = db
+	}
+}
+
+func WithRedisAuth(username, password string) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.Username = username
+		o.Password = password
+	}
+}
+
+func WithRedisTLS(conf *tls.Config) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.TLSConfig = conf
+	}
+}
+
+func WithRedisConnectTimeout(d time.Duration) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.ConnectTimeout = d
+	}
+}
+
+// WithSentinelAuth sets the auth of the sentinels, which may differ from the one of the master.
+func WithSentinelAuth(username, password string) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.SentinelUsername = username
+		o.SentinelPassword = password
+	}
+}
+
+// WithRedisMaxActive limits the connections of the pool, Get waits for a returned connection
+// when wait is true, otherwise fails with redis.ErrPoolExhausted.
+func WithRedisMaxActive(maxActive int, wait bool) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.MaxActive = maxActive
+		o.Wait = wait
+	}
+}
+
+func WithRedisIdleTimeout(d time.Duration) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.IdleTimeout = d
+	}
+}
+
+func WithRedisMaxConnLifetime(d time.Duration) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.MaxConnLifetime = d
+	}
+}
+
+// WithRedisPingIdleAfter pings the connections idled longer than d when they are borrowed,
+// so that the stale ones are dropped after redis restarts. The negative disables the ping.
+func WithRedisPingIdleAfter(d time.Duration) RedisOptionFunc {
+	return func(o *RedisDialOption) {
+		o.PingIdleAfter = d
+	}
+}
+
+func packRedisDialOption(opts ...RedisOptionFunc) *RedisDialOption {
+	o := &RedisDialOption{
+		ConnectTimeout: DefaultRedisConnectTimeout,
+		IdleTimeout:    DefaultRedisIdleTimeout,
+		PingIdleAfter:  DefaultRedisPingIdleAfter,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *RedisDialOption) dial(addr string, db int, username, password string) (redis.Conn, error) {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(o.ConnectTimeout),
+	}
+	if o.TLSConfig != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(o.TLSConfig))
+	}
+	if username == "" {
+		options = append(options, redis.DialDatabase(db))
+		if password != "" {
+			options = append(options, redis.DialPassword(password))
+		}
+		return redis.Dial("tcp", addr, options...)
+	}
+
+	// the ACL user is authenticated after dialing, since not every redigo version has the dial option of it
+	conn, err := redis.Dial("tcp", addr, options...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Do("AUTH", username, password); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "auth")
+	}
+	if db != 0 {
+		if _, err := conn.Do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "select db")
+		}
+	}
+	return conn, nil
+}
+
 func DialRedisPool(addr string, db int, maxIdle int, password ...string) *redis.Pool {
+	opts := []RedisOptionFunc{WithRedisDB(db)}
+	if len(password) > 0 && password[0] != "" {
+		opts = append(opts, WithRedisAuth("", password[0]))
+	}
+	return DialRedisPoolWithOptions(addr, maxIdle, opts...)
+}
+
+// DialRedisPoolWithOptions dials the standalone redis, the addr may be a service or a host with port.
+func DialRedisPoolWithOptions(addr string, maxIdle int, opts ...RedisOptionFunc) *redis.Pool {
+	opt := packRedisDialOption(opts...)
+	return opt.newPool(maxIdle, consulRedisDial(addr, opt), opt.pingIdle)
+}
+
+func (o *RedisDialOption) newPool(maxIdle int, dial func() (redis.Conn, error), test func(redis.Conn, time.Time) error) *redis.Pool {
 	return &redis.Pool{
-		MaxIdle:     maxIdle,
-		IdleTimeout: 300 * time.Second,
-		Dial:        consulRedisDial(addr, db, password...),
+		MaxIdle:         maxIdle,
+		MaxActive:       o.MaxActive,
+		Wait:            o.Wait,
+		IdleTimeout:     o.IdleTimeout,
+		MaxConnLifetime: o.MaxConnLifetime,
+		Dial:            dial,
+		TestOnBorrow:    test,
+	}
+}
+
+func (o *RedisDialOption) pingIdle(conn redis.Conn, t time.Time) error {
+	if o.PingIdleAfter < 0 || time.Since(t) < o.PingIdleAfter {
+		return nil
 	}
+	_, err := conn.Do("PING")
+	return err
 }
 
-func consulRedisDial(addr string, db int, password ...string) func() (redis.Conn, error) {
+func consulRedisDial(addr string, opt *RedisDialOption) func() (redis.Conn, error) {
 	return func() (redis.Conn, error) {
-		var serviceAddr string
-		serviceAddr = discover.GetServiceFinder().GetAddress(addr)
-		if serviceAddr == "" {
-			serviceAddr = addr
+		serviceAddr, err := discoverRedis(addr)
+		if err != nil {
+			return nil, err
 		}
 		lg.Debugf("Discover redis addr: %v", serviceAddr)
-		
-		options := []redis.DialOption{
-			redis.DialDatabase(db),
-			redis.DialConnectTimeout(5 * time.Second),
+
+		return opt.dial(serviceAddr, opt.DB, opt.Username, opt.Password)
+	}
+}
+
+func discoverRedis(addr string) (string, error) {
+	serviceAddr, err := discover.GetServiceFinder().FindAddressWithTag(addr, "")
+	if err != nil {
+		// the addr may be a host name with port
+		if _, _, splitErr := net.SplitHostPort(addr); splitErr != nil {
+			return "", errors.Wrap(err, "discover redis")
+		}
+		serviceAddr = addr
+	}
+	return serviceAddr, nil
+}
+
+// discoverRedisNodes returns all the addresses of the nodes, each of which may be a service or a host with port.
+func discoverRedisNodes(nodes []string) []string {
+	var addrs []string
+	for _, node := range nodes {
+		if found := discover.GetServiceFinder().GetAllAddress(node); len(found) != 0 {
+			addrs = append(addrs, found...)
+			continue
 		}
-		
-		if len(password) > 0 && password[0] != "" {
-			options = append(options, redis.DialPassword(password[0]))
+		if _, _, err := net.SplitHostPort(node); err == nil {
+			addrs = append(addrs, node)
 		}
-		
-		return redis.Dial("tcp", serviceAddr, options...)
 	}
+	return addrs
 }
@@ -0,0 +1,550 @@
+package dialer
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+const (
+	RedisClusterSlots = 16384
+
+	redisClusterMaxRedirects    = 5
+	redisClusterRefreshInterval = 100 * time.Millisecond
+)
+
+var errClusterConnClosed = errors.New("redis cluster: connection closed")
+
+// DialClusterPool dials the redis cluster from the seed nodes, each of which may be a service or a host with port.
+// The connections of the pool route every command to the master serving the hash slot of its key, and follow
+// the MOVED and ASK redirections. The commands of a transaction are sent to the node of the first key,
+// so all of its keys should be in the same slot, e.g. by the hash tag like {user}:name and {user}:age.
+// Only the Do out of a pipeline or transaction follows the redirections, the redirected replies of the commands
+// sent by Send are returned by Receive as they are, though a MOVED one updates the slot table for the next commands.
+func DialClusterPool(nodes []string, maxIdle int, opts ...RedisOptionFunc) *redis.Pool {
+	cluster := &redisCluster{
+		nodes: nodes,
+		opt:   packRedisDialOption(opts...),
+	}
+	// the broken connections to the nodes are redialed by the cluster connection itself, so it is not pinged
+	return cluster.opt.newPool(maxIdle, func() (redis.Conn, error) {
+		if err := cluster.ensureSlots(); err != nil {
+			return nil, err
+		}
+		return &clusterConn{cluster: cluster, conns: make(map[string]redis.Conn)}, nil
+	}, nil)
+}
+
+// RedisHashSlot returns the cluster slot of the key, only the hash tag is hashed if the key has one.
+func RedisHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % RedisClusterSlots)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) used by redis cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// redisCluster is the slot table shared by the connections of a pool.
+type redisCluster struct {
+	nodes []string
+	opt   *RedisDialOption
+
+	mu      sync.RWMutex
+	slots   [RedisClusterSlots]string
+	masters []string
+
+	refreshMu  sync.Mutex
+	refreshed  time.Time
+	refreshing atomic.Bool
+}
+
+func (c *redisCluster) dial(addr string) (redis.Conn, error) {
+	return c.opt.dial(addr, 0, c.opt.Username, c.opt.Password)
+}
+
+func (c *redisCluster) ensureSlots() error {
+	c.mu.RLock()
+	loaded := len(c.masters) != 0
+	c.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return c.refresh()
+}
+
+// refresh reloads the slot table from the known masters or the seed nodes.
+func (c *redisCluster) refresh() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if time.Since(c.refreshed) < redisClusterRefreshInterval {
+		return nil
+	}
+
+	c.mu.RLock()
+	candidates := append([]string{}, c.masters...)
+	c.mu.RUnlock()
+	candidates = append(candidates, discoverRedisNodes(c.nodes)...)
+	if len(candidates) == 0 {
+		return errors.Errorf("no redis cluster node of %v discovered", c.nodes)
+	}
+
+	var lastErr error
+	for _, addr := range candidates {
+		slots, masters, err := c.loadSlots(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.mu.Lock()
+		c.slots = *slots
+		c.masters = masters
+		c.mu.Unlock()
+		c.refreshed = time.Now()
+		lg.Debugf("Load redis cluster slots from %v, masters: %v", addr, masters)
+		return nil
+	}
+	return errors.Wrap(lastErr, "load redis cluster slots")
+}
+
+func (c *redisCluster) refreshAsync() {
+	if !c.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.refreshing.Store(false)
+		if err := c.refresh(); err != nil {
+			lg.Warnf("Refresh redis cluster slots error: %v", err)
+		}
+	}()
+}
+
+func (c *redisCluster) loadSlots(addr string) (*[RedisClusterSlots]string, []string, error) {
+	conn, err := c.dial(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cluster slots from %v", addr)
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	slots := new([RedisClusterSlots]string)
+	var masters []string
+	seen := make(map[string]bool)
+	for _, r := range ranges {
+		fields, err := redis.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, nil, errors.Errorf("unexpected slot range %v", r)
+		}
+		start, err1 := redis.Int(fields[0], nil)
+		end, err2 := redis.Int(fields[1], nil)
+		node, err3 := redis.Values(fields[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(node) < 2 || start < 0 || end >= RedisClusterSlots {
+			return nil, nil, errors.Errorf("unexpected slot range %v", r)
+		}
+		ip, _ := redis.String(node[0], nil)
+		port, _ := redis.Int(node[1], nil)
+		// the empty ip means the node which is asked
+		if ip == "" || ip == "?" {
+			ip = host
+		}
+		master := net.JoinHostPort(ip, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = master
+		}
+		if !seen[master] {
+			seen[master] = true
+			masters = append(masters, master)
+		}
+	}
+	if len(masters) == 0 {
+		return nil, nil, errors.Errorf("no slot served by %v", addr)
+	}
+	return slots, masters, nil
+}
+
+func (c *redisCluster) slotAddr(slot int) (string, error) {
+	c.mu.RLock()
+	addr := c.slots[slot]
+	c.mu.RUnlock()
+	if addr != "" {
+		return addr, nil
+	}
+
+	if err := c.refresh(); err != nil {
+		return "", err
+	}
+	c.mu.RLock()
+	addr = c.slots[slot]
+	c.mu.RUnlock()
+	if addr == "" {
+		return "", errors.Errorf("redis cluster slot %d is not served", slot)
+	}
+	return addr, nil
+}
+
+func (c *redisCluster) anyAddr() (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.masters) == 0 {
+		return "", errors.New("no redis cluster master")
+	}
+	return c.masters[rand.Intn(len(c.masters))], nil
+}
+
+func (c *redisCluster) moved(slot int, addr string) {
+	c.mu.Lock()
+	c.slots[slot] = addr
+	c.mu.Unlock()
+	c.refreshAsync()
+}
+
+// parseRedirect parses the MOVED and ASK errors like `MOVED 3999 127.0.0.1:6381`.
+func parseRedirect(err error) (kind string, slot int, addr string, ok bool) {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return "", 0, "", false
+	}
+	fields := strings.Fields(string(rerr))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, "", false
+	}
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil {
+		return "", 0, "", false
+	}
+	return fields[0], slot, fields[2], true
+}
+
+// commandKey returns the key which decides the slot of the command.
+func commandKey(cmd string, args []any) (string, bool) {
+	switch strings.ToUpper(cmd) {
+	case "", "PING", "ECHO", "INFO", "TIME", "DBSIZE", "FLUSHDB", "FLUSHALL", "RANDOMKEY", "KEYS", "SCAN",
+		"MULTI", "EXEC", "DISCARD", "UNWATCH", "SCRIPT", "FUNCTION", "CLUSTER", "CONFIG", "CLIENT",
+		"ASKING", "READONLY", "READWRITE", "ROLE", "SELECT", "AUTH", "HELLO", "QUIT":
+		return "", false
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		if len(args) > 2 {
+			if n, err := strconv.Atoi(argString(args[1])); err == nil && n > 0 {
+				return argString(args[2]), true
+			}
+		}
+		return "", false
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.EqualFold(argString(arg), "STREAMS") && i+1 < len(args) {
+				return argString(args[i+1]), true
+			}
+		}
+		return "", false
+	}
+	if len(args) == 0 {
+		return "", false
+	}
+	return argString(args[0]), true
+}
+
+func argString(arg any) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case redis.Argument:
+		return argString(v.RedisArg())
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// clusterConn keeps a connection to each node it has sent commands to.
+type clusterConn struct {
+	cluster *redisCluster
+	conns   map[string]redis.Conn
+	closed  bool
+
+	// pending are the nodes of the replies not received, in the order of the commands
+	pending []*string
+	// pinned is the node of the transaction, from WATCH or the first key after MULTI to EXEC or DISCARD
+	pinned string
+	multi  bool
+	// deferredMulti is the MULTI waiting for the first key to decide the node
+	deferredMulti *string
+	// last is the node which the last command was sent to, used by Receive of the pubsub
+	last  string
+	dirty map[string]bool
+}
+
+func (c *clusterConn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	var err error
+	for addr, conn := range c.conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(c.conns, addr)
+	}
+	return err
+}
+
+func (c *clusterConn) Err() error {
+	if c.closed {
+		return errClusterConnClosed
+	}
+	return nil
+}
+
+func (c *clusterConn) nodeConn(addr string) (redis.Conn, error) {
+	if c.closed {
+		return nil, errClusterConnClosed
+	}
+	if conn, ok := c.conns[addr]; ok {
+		if conn.Err() == nil {
+			return conn, nil
+		}
+		conn.Close()
+		delete(c.conns, addr)
+	}
+	conn, err := c.cluster.dial(addr)
+	if err != nil {
+		c.cluster.refreshAsync()
+		return nil, errors.Wrapf(err, "dial redis cluster node %v", addr)
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// checkReply updates the slot table by the reply and drops the broken connection.
+func (c *clusterConn) checkReply(addr string, conn redis.Conn, err error) {
+	if kind, slot, to, ok := parseRedirect(err); ok && kind == "MOVED" {
+		c.cluster.moved(slot, to)
+	}
+	if conn.Err() != nil {
+		conn.Close()
+		delete(c.conns, addr)
+		c.cluster.refreshAsync()
+	}
+}
+
+func (c *clusterConn) route(cmd string, args []any) (string, error) {
+	if c.pinned != "" {
+		return c.pinned, nil
+	}
+	if key, ok := commandKey(cmd, args); ok {
+		return c.cluster.slotAddr(RedisHashSlot(key))
+	}
+	if c.last != "" {
+		return c.last, nil
+	}
+	return c.cluster.anyAddr()
+}
+
+func (c *clusterConn) Do(cmd string, args ...any) (any, error) {
+	if cmd == "" {
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+		return c.receiveAll()
+	}
+	if len(c.pending) != 0 || c.pinned != "" || c.multi {
+		if err := c.Send(cmd, args...); err != nil {
+			return nil, err
+		}
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+		return c.receiveAll()
+	}
+
+	addr, err := c.route(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	asking := false
+	for redirects := 0; ; redirects++ {
+		conn, err := c.nodeConn(addr)
+		if err != nil {
+			return nil, err
+		}
+		if asking {
+			if err := conn.Send("ASKING"); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := conn.Do(cmd, args...)
+		c.checkReply(addr, conn, err)
+		c.last = addr
+		if strings.EqualFold(cmd, "WATCH") {
+			c.pinned = addr
+		}
+
+		kind, _, to, ok := parseRedirect(err)
+		if !ok || redirects >= redisClusterMaxRedirects {
+			return reply, err
+		}
+		asking = kind == "ASK"
+		addr = to
+	}
+}
+
+func (c *clusterConn) Send(cmd string, args ...any) error {
+	upper := strings.ToUpper(cmd)
+	if upper == "MULTI" && c.pinned == "" {
+		// the node is decided by the first key of the transaction
+		c.multi = true
+		c.deferredMulti = new(string)
+		c.pending = append(c.pending, c.deferredMulti)
+		return nil
+	}
+
+	if c.deferredMulti != nil {
+		addr, err := c.resolveMulti(cmd, args)
+		if err != nil {
+			return err
+		}
+		c.pinned = addr
+	}
+
+	addr, err := c.route(cmd, args)
+	if err != nil {
+		return err
+	}
+	if err := c.send(addr, cmd, args...); err != nil {
+		return err
+	}
+
+	// the replies are received from the nodes recorded in pending, so the transaction ends once it is sent
+	switch upper {
+	case "MULTI":
+		c.multi = true
+	case "WATCH":
+		c.pinned = addr
+	case "EXEC", "DISCARD":
+		c.multi = false
+		c.pinned = ""
+	case "UNWATCH":
+		if !c.multi {
+			c.pinned = ""
+		}
+	}
+	return nil
+}
+
+// resolveMulti sends the deferred MULTI to the node of the command.
+func (c *clusterConn) resolveMulti(cmd string, args []any) (string, error) {
+	addr, err := c.route(cmd, args)
+	if err != nil {
+		return "", err
+	}
+	if err := c.send(addr, "MULTI"); err != nil {
+		return "", err
+	}
+	// the MULTI has been counted in pending when it was deferred
+	c.pending = c.pending[:len(c.pending)-1]
+	*c.deferredMulti = addr
+	c.deferredMulti = nil
+	return addr, nil
+}
+
+func (c *clusterConn) send(addr, cmd string, args ...any) error {
+	conn, err := c.nodeConn(addr)
+	if err != nil {
+		return err
+	}
+	if err := conn.Send(cmd, args...); err != nil {
+		c.checkReply(addr, conn, err)
+		return err
+	}
+	if c.dirty == nil {
+		c.dirty = make(map[string]bool)
+	}
+	c.dirty[addr] = true
+	c.last = addr
+	node := addr
+	c.pending = append(c.pending, &node)
+	return nil
+}
+
+func (c *clusterConn) Flush() error {
+	if c.deferredMulti != nil {
+		if _, err := c.resolveMulti("MULTI", nil); err != nil {
+			return err
+		}
+	}
+	for addr := range c.dirty {
+		conn, err := c.nodeConn(addr)
+		if err != nil {
+			return err
+		}
+		if err := conn.Flush(); err != nil {
+			c.checkReply(addr, conn, err)
+			return err
+		}
+		delete(c.dirty, addr)
+	}
+	return nil
+}
+
+func (c *clusterConn) Receive() (any, error) {
+	addr := c.last
+	if len(c.pending) != 0 {
+		addr = *c.pending[0]
+		c.pending = c.pending[1:]
+	}
+	if addr == "" {
+		return nil, errors.New("redis cluster: no command sent")
+	}
+	conn, ok := c.conns[addr]
+	if !ok {
+		return nil, errors.Errorf("redis cluster: connection to %v is lost", addr)
+	}
+	reply, err := conn.Receive()
+	c.checkReply(addr, conn, err)
+	return reply, err
+}
+
+// receiveAll receives the pending replies and returns the last one, like the Do of redis.Conn.
+func (c *clusterConn) receiveAll() (reply any, err error) {
+	for len(c.pending) != 0 {
+		reply, err = c.Receive()
+		if err != nil {
+			var rerr redis.Error
+			if !errors.As(err, &rerr) {
+				return nil, err
+			}
+		}
+	}
+	return reply, err
+}
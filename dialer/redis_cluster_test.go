@@ -0,0 +1,316 @@
+package dialer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisHashSlot(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{name: "crc16-vector", key: "123456789", want: 12739},
+		{name: "foo", key: "foo", want: 12182},
+		{name: "bar", key: "bar", want: 5061},
+		{name: "empty", key: "", want: 0},
+		{name: "hash-tag", key: "{user1000}.following", want: RedisHashSlot("user1000")},
+		{name: "first-hash-tag", key: "foo{bar}{zap}", want: RedisHashSlot("bar")},
+		{name: "nested-brace", key: "foo{{bar}}zap", want: RedisHashSlot("{bar")},
+		{name: "empty-hash-tag", key: "foo{}{bar}", want: int(crc16("foo{}{bar}") % RedisClusterSlots)},
+		{name: "unclosed-brace", key: "foo{bar", want: int(crc16("foo{bar") % RedisClusterSlots)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, RedisHashSlot(tt.key))
+		})
+	}
+}
+
+func TestCommandKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    string
+		args   []any
+		want   string
+		wantOk bool
+	}{
+		{name: "get", cmd: "GET", args: []any{"foo"}, want: "foo", wantOk: true},
+		{name: "lower-case", cmd: "set", args: []any{[]byte("foo"), "bar"}, want: "foo", wantOk: true},
+		{name: "no-args", cmd: "GET", wantOk: false},
+		{name: "keyless", cmd: "PING", args: []any{"foo"}, wantOk: false},
+		{name: "multi", cmd: "MULTI", wantOk: false},
+		{name: "eval", cmd: "EVAL", args: []any{"return 1", 1, "foo", "bar"}, want: "foo", wantOk: true},
+		{name: "eval-no-keys", cmd: "EVALSHA", args: []any{"sha", "0", "bar"}, wantOk: false},
+		{name: "xread", cmd: "XREAD", args: []any{"COUNT", 1, "streams", "s1", "0"}, want: "s1", wantOk: true},
+		{name: "xread-no-streams", cmd: "XREADGROUP", args: []any{"GROUP", "g", "c"}, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := commandKey(tt.cmd, tt.args)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantKind string
+		wantSlot int
+		wantAddr string
+		wantOk   bool
+	}{
+		{name: "moved", err: redis.Error("MOVED 3999 127.0.0.1:6381"), wantKind: "MOVED", wantSlot: 3999, wantAddr: "127.0.0.1:6381", wantOk: true},
+		{name: "ask", err: redis.Error("ASK 3999 127.0.0.1:6381"), wantKind: "ASK", wantSlot: 3999, wantAddr: "127.0.0.1:6381", wantOk: true},
+		{name: "wrapped", err: errors.Wrap(redis.Error("MOVED 1 n:1"), "get"), wantKind: "MOVED", wantSlot: 1, wantAddr: "n:1", wantOk: true},
+		{name: "nil", err: nil},
+		{name: "other-error", err: redis.Error("ERR unknown command")},
+		{name: "not-redis-error", err: errors.New("MOVED 3999 127.0.0.1:6381")},
+		{name: "bad-slot", err: redis.Error("MOVED abc 127.0.0.1:6381")},
+		{name: "missing-addr", err: redis.Error("MOVED 3999")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, slot, addr, ok := parseRedirect(tt.err)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantKind, kind)
+			assert.Equal(t, tt.wantSlot, slot)
+			assert.Equal(t, tt.wantAddr, addr)
+		})
+	}
+}
+
+// testNodeConn replies the commands by the handler, and records them as "cmd key" in the order sent.
+type testNodeConn struct {
+	addr    string
+	sent    []string
+	queued  [][]any
+	flushed int
+	handler func(cmd string, args []any) (any, error)
+}
+
+func (c *testNodeConn) record(cmd string, args []any) {
+	if len(args) != 0 {
+		cmd = fmt.Sprintf("%s %v", cmd, args[0])
+	}
+	c.sent = append(c.sent, cmd)
+}
+
+func (c *testNodeConn) reply(cmd string, args []any) (any, error) {
+	if c.handler != nil {
+		return c.handler(cmd, args)
+	}
+	return c.addr + ":" + cmd, nil
+}
+
+func (c *testNodeConn) Close() error { return nil }
+
+func (c *testNodeConn) Err() error { return nil }
+
+func (c *testNodeConn) Do(cmd string, args ...any) (any, error) {
+	c.record(cmd, args)
+	return c.reply(cmd, args)
+}
+
+func (c *testNodeConn) Send(cmd string, args ...any) error {
+	c.record(cmd, args)
+	c.queued = append(c.queued, append([]any{cmd}, args...))
+	return nil
+}
+
+func (c *testNodeConn) Flush() error {
+	c.flushed++
+	return nil
+}
+
+func (c *testNodeConn) Receive() (any, error) {
+	if len(c.queued) == 0 {
+		return nil, errors.New("no reply")
+	}
+	next := c.queued[0]
+	c.queued = c.queued[1:]
+	return c.reply(next[0].(string), next[1:])
+}
+
+// newTestClusterConn serves the lower half of the slots by n1 and the upper half by n2.
+func newTestClusterConn() (*clusterConn, map[string]*testNodeConn) {
+	cluster := &redisCluster{masters: []string{"n1", "n2"}, refreshed: time.Now().Add(time.Hour)}
+	for slot := range cluster.slots {
+		cluster.slots[slot] = "n1"
+		if slot >= RedisClusterSlots/2 {
+			cluster.slots[slot] = "n2"
+		}
+	}
+	nodes := map[string]*testNodeConn{"n1": {addr: "n1"}, "n2": {addr: "n2"}}
+	conn := &clusterConn{cluster: cluster, conns: map[string]redis.Conn{"n1": nodes["n1"], "n2": nodes["n2"]}}
+	return conn, nodes
+}
+
+func testNodeOf(key string) string {
+	if RedisHashSlot(key) < RedisClusterSlots/2 {
+		return "n1"
+	}
+	return "n2"
+}
+
+func TestClusterConnPipeline(t *testing.T) {
+	conn, nodes := newTestClusterConn()
+	// bar is served by n1 and foo by n2
+	assert.Equal(t, "n1", testNodeOf("bar"))
+	assert.Equal(t, "n2", testNodeOf("foo"))
+
+	assert.Nil(t, conn.Send("SET", "foo", 1))
+	assert.Nil(t, conn.Send("SET", "bar", 2))
+	assert.Nil(t, conn.Send("GET", "foo"))
+	assert.Equal(t, []string{"n2", "n1", "n2"}, pendingNodes(conn))
+	assert.Nil(t, conn.Flush())
+	assert.Equal(t, 1, nodes["n1"].flushed)
+	assert.Equal(t, 1, nodes["n2"].flushed)
+
+	// the replies are received in the order of the commands from their nodes
+	for _, want := range []string{"n2:SET", "n1:SET", "n2:GET"} {
+		reply, err := conn.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, want, reply)
+	}
+	assert.Empty(t, conn.pending)
+}
+
+func TestClusterConnDeferredMulti(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmds     [][]any
+		wantNode string
+		wantSent []string
+	}{
+		{
+			name:     "pinned-by-first-key",
+			cmds:     [][]any{{"MULTI"}, {"SET", "{foo}a", 1}, {"SET", "{foo}b", 2}, {"EXEC"}},
+			wantNode: "n2",
+			wantSent: []string{"MULTI", "SET {foo}a", "SET {foo}b", "EXEC"},
+		},
+		{
+			name:     "keyless",
+			cmds:     [][]any{{"PING"}, {"MULTI"}, {"PING"}, {"EXEC"}},
+			wantNode: "n1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, nodes := newTestClusterConn()
+			// the keyless commands go to the node of the last command
+			conn.last = "n1"
+			for _, cmd := range tt.cmds {
+				assert.Nil(t, conn.Send(cmd[0].(string), cmd[1:]...))
+			}
+			assert.Nil(t, conn.deferredMulti)
+			assert.Empty(t, conn.pinned)
+			assert.False(t, conn.multi)
+			for _, node := range pendingNodes(conn) {
+				assert.Equal(t, tt.wantNode, node)
+			}
+			assert.Len(t, conn.pending, len(tt.cmds))
+			if tt.wantSent != nil {
+				assert.Equal(t, tt.wantSent, nodes[tt.wantNode].sent)
+			}
+
+			reply, err := conn.Do("")
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantNode+":EXEC", reply)
+			assert.Empty(t, conn.pending)
+		})
+	}
+}
+
+func TestClusterConnFlushDeferredMulti(t *testing.T) {
+	conn, nodes := newTestClusterConn()
+	conn.last = "n2"
+
+	// the MULTI without any command is sent by Flush
+	assert.Nil(t, conn.Send("MULTI"))
+	assert.Equal(t, []string{""}, pendingNodes(conn))
+	assert.Nil(t, conn.Flush())
+	assert.Nil(t, conn.deferredMulti)
+	assert.Equal(t, []string{"n2"}, pendingNodes(conn))
+	assert.Equal(t, []string{"MULTI"}, nodes["n2"].sent)
+
+	reply, err := conn.Receive()
+	assert.Nil(t, err)
+	assert.Equal(t, "n2:MULTI", reply)
+}
+
+func TestClusterConnWatch(t *testing.T) {
+	conn, nodes := newTestClusterConn()
+
+	// the WATCH pins the transaction to the node of its key, even for the keys of other nodes
+	_, err := conn.Do("WATCH", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "n2", conn.pinned)
+	reply, err := conn.Do("GET", "bar")
+	assert.Nil(t, err)
+	assert.Equal(t, "n2:GET", reply)
+	_, err = conn.Do("UNWATCH")
+	assert.Nil(t, err)
+	assert.Empty(t, conn.pinned)
+	assert.Empty(t, nodes["n1"].sent)
+}
+
+func TestClusterConnRedirect(t *testing.T) {
+	conn, nodes := newTestClusterConn()
+	slot := RedisHashSlot("foo")
+	nodes["n2"].handler = func(cmd string, args []any) (any, error) {
+		return nil, redis.Error(fmt.Sprintf("MOVED %d n1", slot))
+	}
+	nodes["n1"].handler = func(cmd string, args []any) (any, error) {
+		return "n1:" + cmd, nil
+	}
+
+	reply, err := conn.Do("GET", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "n1:GET", reply)
+	addr, err := conn.cluster.slotAddr(slot)
+	assert.Nil(t, err)
+	assert.Equal(t, "n1", addr)
+
+	// the ASK is followed with ASKING, and the slot table is kept
+	conn, nodes = newTestClusterConn()
+	nodes["n2"].handler = func(cmd string, args []any) (any, error) {
+		return nil, redis.Error(fmt.Sprintf("ASK %d n1", slot))
+	}
+	reply, err = conn.Do("GET", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "n1:GET", reply)
+	assert.Equal(t, []string{"ASKING", "GET foo"}, nodes["n1"].sent)
+	addr, err = conn.cluster.slotAddr(slot)
+	assert.Nil(t, err)
+	assert.Equal(t, "n2", addr)
+
+	// the pipelined commands do not follow the redirections
+	conn, nodes = newTestClusterConn()
+	nodes["n2"].handler = func(cmd string, args []any) (any, error) {
+		return nil, redis.Error(fmt.Sprintf("MOVED %d n1", slot))
+	}
+	assert.Nil(t, conn.Send("GET", "foo"))
+	assert.Nil(t, conn.Flush())
+	_, err = conn.Receive()
+	_, _, _, ok := parseRedirect(err)
+	assert.True(t, ok)
+	assert.Empty(t, nodes["n1"].sent)
+}
+
+func pendingNodes(conn *clusterConn) []string {
+	var nodes []string
+	for _, node := range conn.pending {
+		nodes = append(nodes, *node)
+	}
+	return nodes
+}
@@ -0,0 +1,153 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+// sentinelRoleCheckInterval is the idle time after which the role of a pooled connection is checked again,
+// so that the connections to a demoted master are dropped after failover.
+const sentinelRoleCheckInterval = time.Second
+
+// DialSentinelPool dials the master monitored by the sentinels, each of which may be a service or a host with port.
+// The master is asked from the sentinels for every new connection, and the pooled connections are dropped
+// once the node is no longer the master, either by the role check of the idle ones or by the READONLY reply,
+// so the pool follows the failovers.
+func DialSentinelPool(masterName string, sentinels []string, maxIdle int, opts ...RedisOptionFunc) *redis.Pool {
+	opt := packRedisDialOption(opts...)
+	dial := func() (redis.Conn, error) {
+		addr, err := sentinelMasterAddr(masterName, sentinels, opt)
+		if err != nil {
+			return nil, err
+		}
+		lg.Debugf("Discover redis master %v addr: %v", masterName, addr)
+
+		conn, err := opt.dial(addr, opt.DB, opt.Username, opt.Password)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRedisMaster(conn); err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "redis master %v", addr)
+		}
+		return &sentinelConn{Conn: conn}, nil
+	}
+	// the role check pings the connection as well
+	return opt.newPool(maxIdle, dial, func(conn redis.Conn, t time.Time) error {
+		if time.Since(t) < sentinelRoleCheckInterval {
+			return nil
+		}
+		return checkRedisMaster(conn)
+	})
+}
+
+// sentinelMasterAddr asks the sentinels in order for the address of the master.
+func sentinelMasterAddr(masterName string, sentinels []string, opt *RedisDialOption) (string, error) {
+	addrs := discoverRedisNodes(sentinels)
+	if len(addrs) == 0 {
+		return "", errors.Errorf("no sentinel of %v discovered", masterName)
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		master, err := askSentinel(addr, masterName, opt)
+		if err == nil {
+			return master, nil
+		}
+		lg.Warnf("Ask sentinel %v for master %v error: %v", addr, masterName, err)
+		lastErr = err
+	}
+	return "", errors.Wrapf(lastErr, "get master %v from sentinels", masterName)
+}
+
+func askSentinel(addr, masterName string, opt *RedisDialOption) (string, error) {
+	conn, err := opt.dial(addr, 0, opt.SentinelUsername, opt.SentinelPassword)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	res, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", masterName))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return "", errors.Errorf("unknown master %v", masterName)
+		}
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", errors.Errorf("unexpected reply %v", res)
+	}
+	return net.JoinHostPort(res[0], res[1]), nil
+}
+
+func checkRedisMaster(conn redis.Conn) error {
+	reply, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return errors.Wrap(err, "role")
+	}
+	if len(reply) == 0 {
+		return errors.New("empty role reply")
+	}
+	role, err := redis.String(reply[0], nil)
+	if err != nil {
+		return errors.Wrap(err, "role")
+	}
+	if !strings.EqualFold(role, "master") {
+		return errors.Errorf("role is %v, not master", role)
+	}
+	return nil
+}
+
+var errRedisDemoted = errors.New("redis master demoted to replica")
+
+// sentinelConn breaks once a READONLY reply is received, so that the pool drops the connection
+// to the demoted master instead of returning it to the idle ones.
+type sentinelConn struct {
+	redis.Conn
+	demoted bool
+}
+
+func (c *sentinelConn) check(reply any, err error) (any, error) {
+	var rerr redis.Error
+	if errors.As(err, &rerr) && strings.HasPrefix(string(rerr), "READONLY") {
+		c.demoted = true
+	}
+	return reply, err
+}
+
+func (c *sentinelConn) Err() error {
+	if c.demoted {
+		return errRedisDemoted
+	}
+	return c.Conn.Err()
+}
+
+func (c *sentinelConn) Do(cmd string, args ...any) (any, error) {
+	return c.check(c.Conn.Do(cmd, args...))
+}
+
+func (c *sentinelConn) DoContext(ctx context.Context, cmd string, args ...any) (any, error) {
+	return c.check(redis.DoContext(c.Conn, ctx, cmd, args...))
+}
+
+func (c *sentinelConn) DoWithTimeout(timeout time.Duration, cmd string, args ...any) (any, error) {
+	return c.check(redis.DoWithTimeout(c.Conn, timeout, cmd, args...))
+}
+
+func (c *sentinelConn) Receive() (any, error) {
+	return c.check(c.Conn.Receive())
+}
+
+func (c *sentinelConn) ReceiveContext(ctx context.Context) (any, error) {
+	return c.check(redis.ReceiveContext(c.Conn, ctx))
+}
+
+func (c *sentinelConn) ReceiveWithTimeout(timeout time.Duration) (any, error) {
+	return c.check(redis.ReceiveWithTimeout(c.Conn, timeout))
+}
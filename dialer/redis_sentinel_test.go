@@ -0,0 +1,26 @@
+package dialer
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSentinelConnReadonly(t *testing.T) {
+	node := &testNodeConn{addr: "n1"}
+	conn := &sentinelConn{Conn: node}
+
+	_, err := conn.Do("GET", "foo")
+	assert.Nil(t, err)
+	assert.Nil(t, conn.Err())
+
+	// the connection breaks once the master is demoted, so that the pool drops it
+	node.handler = func(cmd string, args []any) (any, error) {
+		return nil, redis.Error("READONLY You can't write against a read only replica.")
+	}
+	assert.Nil(t, conn.Send("SET", "foo", 1))
+	_, err = conn.Receive()
+	assert.NotNil(t, err)
+	assert.ErrorIs(t, conn.Err(), errRedisDemoted)
+}
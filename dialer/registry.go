@@ -0,0 +1,340 @@
+package dialer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRegistryClosed  = errors.New("registry closed")
+	ErrUnknownResource = errors.New("unknown resource")
+)
+
+// Resource describes how the registry dials, checks and closes a connection.
+type Resource struct {
+	// Key deduplicates the connections, the resources with the same key share one connection,
+	// e.g. the dsn of a database. The resource is not shared if it is empty.
+	Key  string
+	Dial func() (any, error)
+	// Health checks the connection, the default calls PingContext or Ping(ctx) of the connection if it has one.
+	Health func(ctx context.Context, conn any) error
+	// Close closes the connection, the default calls Close() or Close(ctx) of the connection if it has one.
+	Close func(conn any) error
+}
+
+func GormResource(key string, dial func() (*gorm.DB, error)) Resource {
+	sqlDB := func(conn any) (*sql.DB, error) {
+		return conn.(*gorm.DB).DB()
+	}
+	return Resource{
+		Key:  key,
+		Dial: func() (any, error) { return dial() },
+		Health: func(ctx context.Context, conn any) error {
+			db, err := sqlDB(conn)
+			if err != nil {
+				return err
+			}
+			return db.PingContext(ctx)
+		},
+		Close: func(conn any) error {
+			db, err := sqlDB(conn)
+			if err != nil {
+				return err
+			}
+			return db.Close()
+		},
+	}
+}
+
+func SqlResource(key string, dial func() (*sql.DB, error)) Resource {
+	return Resource{Key: key, Dial: func() (any, error) { return dial() }}
+}
+
+func SqlxResource(key string, dial func() (*sqlx.DB, error)) Resource {
+	return Resource{Key: key, Dial: func() (any, error) { return dial() }}
+}
+
+func RedisResource(key string, dial func() *redis.Pool) Resource {
+	return Resource{
+		Key:  key,
+		Dial: func() (any, error) { return dial(), nil },
+		Health: func(ctx context.Context, conn any) error {
+			c, err := conn.(*redis.Pool).GetContext(ctx)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			_, err = c.Do("PING")
+			return err
+		},
+	}
+}
+
+func GrpcResource(key string, dial func() (*grpc.ClientConn, error)) Resource {
+	return Resource{
+		Key:  key,
+		Dial: func() (any, error) { return dial() },
+		Health: func(ctx context.Context, conn any) error {
+			state := conn.(*grpc.ClientConn).GetState()
+			if state == connectivity.TransientFailure || state == connectivity.Shutdown {
+				return errors.Errorf("grpc connection is %v", state)
+			}
+			return nil
+		},
+	}
+}
+
+func defaultHealth(ctx context.Context, conn any) error {
+	switch c := conn.(type) {
+	case interface{ PingContext(context.Context) error }:
+		return c.PingContext(ctx)
+	case interface{ Ping(context.Context) error }:
+		return c.Ping(ctx)
+	}
+	return nil
+}
+
+func defaultClose(conn any) error {
+	switch c := conn.(type) {
+	case interface{ Close() error }:
+		return c.Close()
+	case interface{ Close(context.Context) error }:
+		return c.Close(context.Background())
+	}
+	return nil
+}
+
+type registryConn struct {
+	name string
+	res  Resource
+
+	mu     sync.Mutex
+	conn   any
+	dialed bool
+}
+
+// Registry owns the named connections, which are dialed on the first Get and closed together by Close.
+// It is safe for concurrent use.
+type Registry struct {
+	mu     sync.Mutex
+	names  map[string]*registryConn
+	keys   map[string]*registryConn
+	dialed []*registryConn
+	closed bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]*registryConn),
+		keys:  make(map[string]*registryConn),
+	}
+}
+
+var defaultRegistry = NewRegistry()
+
+// DefaultRegistry returns the registry closed by the service at shutdown.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// Register adds the resource by the name, the resource shares the connection of
+// the one registered with the same key.
+func (r *Registry) Register(name string, res Resource) error {
+	if res.Dial == nil {
+		return errors.Errorf("resource %v has no dial func", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrRegistryClosed
+	}
+	if _, exists := r.names[name]; exists {
+		return errors.Errorf("resource %v has been registered", name)
+	}
+	r.registerLocked(name, res)
+	return nil
+}
+
+func (r *Registry) registerLocked(name string, res Resource) {
+	if res.Key != "" {
+		if rc, exists := r.keys[res.Key]; exists {
+			r.names[name] = rc
+			return
+		}
+	}
+	if res.Health == nil {
+		res.Health = defaultHealth
+	}
+	if res.Close == nil {
+		res.Close = defaultClose
+	}
+	rc := &registryConn{name: name, res: res}
+	r.names[name] = rc
+	if res.Key != "" {
+		r.keys[res.Key] = rc
+	}
+}
+
+// GetOrRegister returns the connection of the name, and registers the resource first if the name
+// is not registered yet, so that the callers dialing the same connection on demand share it.
+func (r *Registry) GetOrRegister(name string, res Resource) (any, error) {
+	if res.Dial == nil {
+		return nil, errors.Errorf("resource %v has no dial func", name)
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrRegistryClosed
+	}
+	if _, exists := r.names[name]; !exists {
+		r.registerLocked(name, res)
+	}
+	r.mu.Unlock()
+	return r.Get(name)
+}
+
+// Get returns the connection of the name, which is dialed on the first call.
+// The dial is tried again by the next Get if it fails.
+func (r *Registry) Get(name string) (any, error) {
+	r.mu.Lock()
+	rc, exists := r.names[name]
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return nil, ErrRegistryClosed
+	}
+	if !exists {
+		return nil, errors.Wrap(ErrUnknownResource, name)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.dialed {
+		return rc.conn, nil
+	}
+	conn, err := rc.res.Dial()
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial %v", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		rc.res.Close(conn)
+		return nil, ErrRegistryClosed
+	}
+	rc.conn, rc.dialed = conn, true
+	r.dialed = append(r.dialed, rc)
+	lg.Debugf("Registry dialed %v", name)
+	return conn, nil
+}
+
+// GetAs returns the connection of the name as T, e.g. dialer.GetAs[*gorm.DB](registry, "user").
+func GetAs[T any](r *Registry, name string) (T, error) {
+	var zero T
+	conn, err := r.Get(name)
+	if err != nil {
+		return zero, err
+	}
+	t, ok := conn.(T)
+	if !ok {
+		return zero, errors.Errorf("resource %v is %T, not %T", name, conn, zero)
+	}
+	return t, nil
+}
+
+// Health checks the dialed connections by their names, the ones not dialed yet are skipped.
+func (r *Registry) Health(ctx context.Context) map[string]error {
+	r.mu.Lock()
+	conns := make(map[string]*registryConn, len(r.names))
+	for name, rc := range r.names {
+		conns[name] = rc
+	}
+	r.mu.Unlock()
+
+	result := make(map[string]error)
+	for name, rc := range conns {
+		rc.mu.Lock()
+		conn, dialed := rc.conn, rc.dialed
+		rc.mu.Unlock()
+		if !dialed {
+			continue
+		}
+		result[name] = rc.res.Health(ctx, conn)
+	}
+	return result
+}
+
+// ServeHTTP writes the health of the dialed connections, the status is 503 if any of them is unhealthy.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	health := make(map[string]string)
+	for name, err := range r.Health(ctx) {
+		health[name] = "ok"
+		if err != nil {
+			health[name] = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// Names returns the sorted names of the resources.
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the dialed connections in the reverse order of dialing, and the registry can not be used any more.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	dialed := r.dialed
+	r.dialed = nil
+	r.mu.Unlock()
+
+	var firstErr error
+	for i := len(dialed) - 1; i >= 0; i-- {
+		rc := dialed[i]
+		rc.mu.Lock()
+		if err := rc.res.Close(rc.conn); err != nil {
+			lg.Errorf("Registry close %v error: %v", rc.name, err)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "close %v", rc.name)
+			}
+		}
+		rc.conn, rc.dialed = nil, false
+		rc.mu.Unlock()
+	}
+	return firstErr
+}
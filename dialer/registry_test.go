@@ -0,0 +1,175 @@
+package dialer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type testConn struct {
+	id     int
+	closed *[]int
+	mu     *sync.Mutex
+}
+
+func (c *testConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.closed = append(*c.closed, c.id)
+	return nil
+}
+
+type testDialer struct {
+	mu     sync.Mutex
+	dials  atomic.Int32
+	closed []int
+}
+
+func (d *testDialer) resource(key string) Resource {
+	return Resource{
+		Key: key,
+		Dial: func() (any, error) {
+			return &testConn{id: int(d.dials.Add(1)), closed: &d.closed, mu: &d.mu}, nil
+		},
+	}
+}
+
+func TestRegistryConcurrentGet(t *testing.T) {
+	r := NewRegistry()
+	d := &testDialer{}
+	assert.Nil(t, r.Register("db", d.resource("")))
+
+	var wg sync.WaitGroup
+	conns := make([]any, 20)
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := r.Get("db")
+			assert.Nil(t, err)
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, d.dials.Load())
+	for _, conn := range conns {
+		assert.Same(t, conns[0], conn)
+	}
+}
+
+func TestRegistryDedupByKey(t *testing.T) {
+	r := NewRegistry()
+	d := &testDialer{}
+	assert.Nil(t, r.Register("user", d.resource("mysql://db")))
+	assert.Nil(t, r.Register("order", d.resource("mysql://db")))
+	assert.Nil(t, r.Register("cache", d.resource("redis://cache")))
+	assert.NotNil(t, r.Register("user", d.resource("mysql://other")))
+
+	user, err := r.Get("user")
+	assert.Nil(t, err)
+	order, err := r.Get("order")
+	assert.Nil(t, err)
+	cache, err := GetAs[*testConn](r, "cache")
+	assert.Nil(t, err)
+	assert.Same(t, user, order)
+	assert.NotSame(t, user, cache)
+	assert.EqualValues(t, 2, d.dials.Load())
+	assert.Equal(t, []string{"cache", "order", "user"}, r.Names())
+
+	_, err = r.Get("unknown")
+	assert.ErrorIs(t, err, ErrUnknownResource)
+	_, err = GetAs[string](r, "user")
+	assert.NotNil(t, err)
+}
+
+func TestRegistryRetryFailedDial(t *testing.T) {
+	r := NewRegistry()
+	var dials int
+	assert.Nil(t, r.Register("db", Resource{
+		Dial: func() (any, error) {
+			dials++
+			if dials == 1 {
+				return nil, errors.New("connection refused")
+			}
+			return "conn", nil
+		},
+	}))
+
+	_, err := r.Get("db")
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Empty(t, r.Health(context.Background()))
+
+	conn, err := r.Get("db")
+	assert.Nil(t, err)
+	assert.Equal(t, "conn", conn)
+	assert.Equal(t, 2, dials)
+}
+
+func TestRegistryGetOrRegister(t *testing.T) {
+	r := NewRegistry()
+	d := &testDialer{}
+	first, err := r.GetOrRegister("db", d.resource(""))
+	assert.Nil(t, err)
+	second, err := r.GetOrRegister("db", d.resource(""))
+	assert.Nil(t, err)
+	assert.Same(t, first, second)
+	assert.EqualValues(t, 1, d.dials.Load())
+}
+
+func TestRegistryClose(t *testing.T) {
+	r := NewRegistry()
+	d := &testDialer{}
+	for _, name := range []string{"a", "b", "c"} {
+		assert.Nil(t, r.Register(name, d.resource(name)))
+	}
+	// dialed in the order c, a, and b is never dialed
+	for _, name := range []string{"c", "a"} {
+		_, err := r.Get(name)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, r.Close())
+	// closed in the reverse order of dialing
+	assert.Equal(t, []int{2, 1}, d.closed)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, []int{2, 1}, d.closed)
+
+	_, err := r.Get("a")
+	assert.ErrorIs(t, err, ErrRegistryClosed)
+	_, err = r.GetOrRegister("d", d.resource(""))
+	assert.ErrorIs(t, err, ErrRegistryClosed)
+	assert.ErrorIs(t, r.Register("d", d.resource("")), ErrRegistryClosed)
+	assert.EqualValues(t, 2, d.dials.Load())
+}
+
+func TestRegistryCloseWhileDialing(t *testing.T) {
+	r := NewRegistry()
+	d := &testDialer{}
+	dialing, release := make(chan struct{}), make(chan struct{})
+	res := d.resource("")
+	dial := res.Dial
+	res.Dial = func() (any, error) {
+		close(dialing)
+		<-release
+		return dial()
+	}
+	assert.Nil(t, r.Register("db", res))
+
+	errCh := make(chan error)
+	go func() {
+		_, err := r.Get("db")
+		errCh <- err
+	}()
+	<-dialing
+	assert.Nil(t, r.Close())
+	close(release)
+
+	// the connection dialed after Close is closed at once
+	assert.ErrorIs(t, <-errCh, ErrRegistryClosed)
+	assert.Equal(t, []int{1}, d.closed)
+}
@@ -41,6 +41,6 @@ func DialSqlLiteGorm(dbFile string, opts ...OptionFunc) (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	configDB(sqlDb)
+	configDB(sqlDb, opt)
 	return db, nil
 }
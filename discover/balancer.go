@@ -0,0 +1,349 @@
+package discover
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+// BalanceStrategy decides which address of a service is picked by the Balancer.
+type BalanceStrategy int
+
+const (
+	// RoundRobin picks the addresses in turn.
+	RoundRobin BalanceStrategy = iota
+	// Weighted picks the addresses in proportion to their weights, see WeightedFinder.
+	Weighted
+	// LeastOutstanding picks the address with the least requests in flight.
+	LeastOutstanding
+	// ConsistentHash picks the same address for the same key as long as the address is available.
+	ConsistentHash
+)
+
+// WeightMetaKey is the service meta which overrides the passing weight of the consul service.
+const WeightMetaKey = "weight"
+
+const hashReplicas = 100
+
+var ErrNoAddress = errors.New("no available address")
+
+// WeightedFinder is implemented by the finders which know the weight of every address.
+type WeightedFinder interface {
+	Weights(service, tag string) map[string]int
+}
+
+type BalancerOption func(b *Balancer)
+
+// WithStrategy sets the strategy of the balancer, default is RoundRobin.
+func WithStrategy(strategy BalanceStrategy) BalancerOption {
+	return func(b *Balancer) {
+		b.strategy = strategy
+	}
+}
+
+// WithFinder sets the finder of the balancer, default is the default ServiceFinder.
+func WithFinder(finder ServiceFinder) BalancerOption {
+	return func(b *Balancer) {
+		b.finder = finder
+	}
+}
+
+// WithOutlierEjection ejects an address after it fails consecutiveErrors times in a row.
+// The address is ejected for ejectionTime multiplied by the times it has been ejected.
+// Set consecutiveErrors to 0 to disable ejection.
+func WithOutlierEjection(consecutiveErrors int, ejectionTime time.Duration) BalancerOption {
+	return func(b *Balancer) {
+		b.consecutiveErrors = consecutiveErrors
+		b.ejectionTime = ejectionTime
+	}
+}
+
+// WithMaxEjectionPercent sets the max percent of the addresses which can be ejected at the same time, default is 50.
+func WithMaxEjectionPercent(percent int) BalancerOption {
+	return func(b *Balancer) {
+		b.maxEjectionPercent = percent
+	}
+}
+
+type backend struct {
+	addr   string
+	weight int
+
+	// current weight of smooth weighted round robin
+	current     int
+	outstanding int
+
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+func (be *backend) ejected(now time.Time) bool {
+	return now.Before(be.ejectedUntil)
+}
+
+type ringNode struct {
+	hash    uint32
+	backend *backend
+}
+
+// Balancer picks an address of the service for every request, and ejects the
+// addresses which keep failing for a while. The addresses are kept up to date by
+// watching the finder.
+type Balancer struct {
+	service string
+	tag     string
+	finder  ServiceFinder
+
+	strategy           BalanceStrategy
+	consecutiveErrors  int
+	ejectionTime       time.Duration
+	maxEjectionPercent int
+
+	mu       sync.Mutex
+	loaded   bool
+	backends []*backend
+	ring     []ringNode
+	next     int
+
+	watch     <-chan []string
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewBalancer(service, tag string, opts ...BalancerOption) *Balancer {
+	b := &Balancer{
+		service:            service,
+		tag:                tag,
+		finder:             GetServiceFinder(),
+		strategy:           RoundRobin,
+		consecutiveErrors:  5,
+		ejectionTime:       30 * time.Second,
+		maxEjectionPercent: 50,
+		done:               make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.watch = b.finder.Watch(service, tag)
+	go b.run()
+	return b
+}
+
+func (b *Balancer) run() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case addrs, ok := <-b.watch:
+			if !ok {
+				return
+			}
+			b.update(addrs)
+		}
+	}
+}
+
+func (b *Balancer) update(addrs []string) {
+	var weights map[string]int
+	if wf, ok := b.finder.(WeightedFinder); ok {
+		weights = wf.Weights(b.service, b.tag)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old := make(map[string]*backend, len(b.backends))
+	for _, be := range b.backends {
+		old[be.addr] = be
+	}
+
+	backends := make([]*backend, 0, len(addrs))
+	for _, addr := range addrs {
+		be, ok := old[addr]
+		if !ok {
+			be = &backend{addr: addr}
+		}
+		be.weight = max(weights[addr], 1)
+		backends = append(backends, be)
+		delete(old, addr)
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].addr < backends[j].addr
+	})
+
+	b.backends = backends
+	b.ring = buildRing(backends)
+	b.loaded = true
+}
+
+func buildRing(backends []*backend) []ringNode {
+	ring := make([]ringNode, 0, len(backends)*hashReplicas)
+	for _, be := range backends {
+		for i := 0; i < hashReplicas; i++ {
+			ring = append(ring, ringNode{hash: hashKey(fmt.Sprintf("%s#%d", be.addr, i)), backend: be})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	return ring
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Pick returns an address of the service. The key is only used by ConsistentHash.
+// The returned done must be called with the result of the request, which is
+// used by LeastOutstanding and outlier ejection.
+func (b *Balancer) Pick(key string) (addr string, done func(err error), err error) {
+	b.mu.Lock()
+	loaded := b.loaded
+	b.mu.Unlock()
+	if !loaded {
+		b.update(b.finder.GetAllAddressWithTag(b.service, b.tag))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	candidates := b.available(now)
+	if len(candidates) == 0 {
+		return "", nil, errors.Wrapf(ErrNoAddress, "%s:%s", b.service, b.tag)
+	}
+
+	var be *backend
+	switch {
+	case b.strategy == Weighted:
+		be = b.pickWeighted(candidates)
+	case b.strategy == LeastOutstanding:
+		be = b.pickLeastOutstanding(candidates)
+	case b.strategy == ConsistentHash && key != "":
+		be = b.pickHash(key, now)
+	default:
+		be = candidates[b.next%len(candidates)]
+		b.next++
+	}
+
+	be.outstanding++
+	var once sync.Once
+	return be.addr, func(err error) {
+		once.Do(func() {
+			b.finish(be, err)
+		})
+	}, nil
+}
+
+// available returns the addresses which are not ejected, or all of them if all are ejected.
+func (b *Balancer) available(now time.Time) []*backend {
+	candidates := make([]*backend, 0, len(b.backends))
+	for _, be := range b.backends {
+		if !be.ejected(now) {
+			candidates = append(candidates, be)
+		}
+	}
+	if len(candidates) == 0 {
+		return b.backends
+	}
+	return candidates
+}
+
+// pickWeighted is the smooth weighted round robin of nginx.
+func (b *Balancer) pickWeighted(candidates []*backend) *backend {
+	var (
+		best  *backend
+		total int
+	)
+	for _, be := range candidates {
+		be.current += be.weight
+		total += be.weight
+		if best == nil || be.current > best.current {
+			best = be
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func (b *Balancer) pickLeastOutstanding(candidates []*backend) *backend {
+	// start from a different address every time to spread the ties
+	start := b.next % len(candidates)
+	b.next++
+
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		be := candidates[(start+i)%len(candidates)]
+		if be.outstanding < best.outstanding {
+			best = be
+		}
+	}
+	return best
+}
+
+func (b *Balancer) pickHash(key string, now time.Time) *backend {
+	h := hashKey(key)
+	idx := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= h
+	})
+	for i := 0; i < len(b.ring); i++ {
+		node := b.ring[(idx+i)%len(b.ring)]
+		if !node.backend.ejected(now) {
+			return node.backend
+		}
+	}
+	return b.ring[idx%len(b.ring)].backend
+}
+
+func (b *Balancer) finish(be *backend, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	be.outstanding--
+	now := time.Now()
+	if err == nil {
+		be.failures = 0
+		if !be.ejected(now) {
+			be.ejections = 0
+		}
+		return
+	}
+
+	be.failures++
+	if b.consecutiveErrors <= 0 || be.failures < b.consecutiveErrors || be.ejected(now) || !b.canEject(now) {
+		return
+	}
+	be.failures = 0
+	be.ejections++
+	be.ejectedUntil = now.Add(b.ejectionTime * time.Duration(be.ejections))
+	lg.Warnf("Eject %s of %s:%s until %v, since it fails: %v", be.addr, b.service, b.tag, be.ejectedUntil.Format(time.DateTime), err)
+}
+
+func (b *Balancer) canEject(now time.Time) bool {
+	ejected := 0
+	for _, be := range b.backends {
+		if be.ejected(now) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= len(b.backends)*b.maxEjectionPercent
+}
+
+// Close stops watching the addresses of the service.
+func (b *Balancer) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		if u, ok := b.finder.(unwatcher); ok {
+			u.Unwatch(b.watch)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package discover
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type staticFinder struct {
+	*DirectFinder
+	addrs   []string
+	weights map[string]int
+}
+
+func (f *staticFinder) GetAllAddressWithTag(service, tag string) []string {
+	return f.addrs
+}
+
+func (f *staticFinder) Watch(service, tag string) <-chan []string {
+	return make(chan []string)
+}
+
+func (f *staticFinder) Weights(service, tag string) map[string]int {
+	return f.weights
+}
+
+func pickN(t *testing.T, b *Balancer, n int, key string) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		addr, done, err := b.Pick(key)
+		if !assert.Nil(t, err) {
+			return counts
+		}
+		counts[addr]++
+		done(nil)
+	}
+	return counts
+}
+
+func TestBalancer(t *testing.T) {
+	finder := &staticFinder{
+		addrs:   []string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"},
+		weights: map[string]int{"127.0.0.1:1": 1, "127.0.0.1:2": 2, "127.0.0.1:3": 3},
+	}
+
+	t.Run("round-robin", func(t *testing.T) {
+		b := NewBalancer("app", "dev", WithFinder(finder))
+		defer b.Close()
+		assert.Equal(t, map[string]int{"127.0.0.1:1": 2, "127.0.0.1:2": 2, "127.0.0.1:3": 2}, pickN(t, b, 6, ""))
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		b := NewBalancer("app", "dev", WithFinder(finder), WithStrategy(Weighted))
+		defer b.Close()
+		assert.Equal(t, map[string]int{"127.0.0.1:1": 10, "127.0.0.1:2": 20, "127.0.0.1:3": 30}, pickN(t, b, 60, ""))
+	})
+
+	t.Run("least-outstanding", func(t *testing.T) {
+		b := NewBalancer("app", "dev", WithFinder(finder), WithStrategy(LeastOutstanding))
+		defer b.Close()
+		busy, _, _ := b.Pick("")
+		other, _, _ := b.Pick("")
+		assert.NotEqual(t, busy, other)
+		for i := 0; i < 10; i++ {
+			addr, done, err := b.Pick("")
+			assert.Nil(t, err)
+			assert.NotContains(t, []string{busy, other}, addr)
+			done(nil)
+		}
+	})
+
+	t.Run("consistent-hash", func(t *testing.T) {
+		b := NewBalancer("app", "dev", WithFinder(finder), WithStrategy(ConsistentHash))
+		defer b.Close()
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("user-%d", i)
+			assert.Len(t, pickN(t, b, 5, key), 1)
+		}
+		assert.Len(t, pickN(t, b, 3, ""), 3)
+	})
+
+	t.Run("outlier-ejection", func(t *testing.T) {
+		b := NewBalancer("app", "dev", WithFinder(finder), WithOutlierEjection(2, 50*time.Millisecond))
+		defer b.Close()
+		for i := 0; i < 6; i++ {
+			addr, done, _ := b.Pick("")
+			if addr == "127.0.0.1:1" {
+				done(errors.New("connection refused"))
+			} else {
+				done(nil)
+			}
+		}
+		counts := pickN(t, b, 6, "")
+		assert.Equal(t, 0, counts["127.0.0.1:1"])
+
+		time.Sleep(60 * time.Millisecond)
+		assert.Equal(t, 2, pickN(t, b, 6, "")["127.0.0.1:1"])
+	})
+
+	t.Run("max-ejection-percent", func(t *testing.T) {
+		b := NewBalancer("app", "dev", WithFinder(finder), WithOutlierEjection(1, time.Minute))
+		defer b.Close()
+		for i := 0; i < 6; i++ {
+			_, done, _ := b.Pick("")
+			done(errors.New("connection refused"))
+		}
+		assert.Len(t, pickN(t, b, 6, ""), 2)
+	})
+}
+
+func TestBalancerNoAddress(t *testing.T) {
+	b := NewBalancer("app", "dev", WithFinder(&staticFinder{}))
+	defer b.Close()
+	_, _, err := b.Pick("")
+	assert.ErrorIs(t, err, ErrNoAddress)
+}
@@ -0,0 +1,227 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+var (
+	// CatalogWaitTime is the max wait time of a blocking query to consul.
+	CatalogWaitTime = 5 * time.Minute
+	// CatalogFirstFetchTimeout is how long GetAddress waits for the first fetch of a service.
+	CatalogFirstFetchTimeout = 5 * time.Second
+)
+
+// catalog caches the healthy addresses of services, which are kept up to date by blocking queries.
+// The last known good addresses are served while consul is unreachable.
+type catalog struct {
+	client *api.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	services map[string]*catalogService
+}
+
+type catalogService struct {
+	service string
+	tag     string
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.RWMutex
+	addrs   []string
+	weights map[string]int
+	known   bool
+	nextID  int
+	subs    map[int]chan []string
+}
+
+func newCatalog(client *api.Client) *catalog {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &catalog{
+		client:   client,
+		ctx:      ctx,
+		cancel:   cancel,
+		services: make(map[string]*catalogService),
+	}
+}
+
+// get returns the cached service, and starts watching it on first use.
+func (c *catalog) get(service, tag string) *catalogService {
+	key := fmt.Sprintf("%s:%s", service, tag)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.services[key]; ok {
+		return s
+	}
+
+	s := &catalogService{
+		service: service,
+		tag:     tag,
+		ready:   make(chan struct{}),
+		subs:    make(map[int]chan []string),
+	}
+	c.services[key] = s
+	go c.watch(s)
+	return s
+}
+
+// addresses returns the addresses of the service, and waits for the first fetch if it is not done.
+// It returns false if the service has never been fetched successfully.
+func (c *catalog) addresses(service, tag string) ([]string, bool) {
+	s := c.get(service, tag)
+
+	select {
+	case <-s.ready:
+	case <-time.After(CatalogFirstFetchTimeout):
+	}
+	return s.snapshot()
+}
+
+func (c *catalog) subscribe(service, tag string) <-chan []string {
+	s := c.get(service, tag)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan []string, 1)
+	if c.ctx.Err() != nil {
+		close(ch)
+		return ch
+	}
+	s.subs[s.nextID] = ch
+	s.nextID++
+	if s.known {
+		ch <- append([]string(nil), s.addrs...)
+	}
+	return ch
+}
+
+func (c *catalog) unsubscribe(ch <-chan []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.services {
+		s.mu.Lock()
+		for id, sub := range s.subs {
+			if sub == ch {
+				close(sub)
+				delete(s.subs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (c *catalog) close() {
+	c.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.services {
+		s.mu.Lock()
+		for id, ch := range s.subs {
+			close(ch)
+			delete(s.subs, id)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (c *catalog) watch(s *catalogService) {
+	var (
+		index uint64
+		wait  time.Duration
+	)
+	for {
+		opts := (&api.QueryOptions{WaitIndex: index, WaitTime: CatalogWaitTime}).WithContext(c.ctx)
+		entries, meta, err := c.client.Health().Service(s.service, s.tag, true, opts)
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			wait = min(max(2*wait, 500*time.Millisecond), 30*time.Second)
+			lg.Warnc(c.ctx, "Watch %s:%s in consul error: %v, serving the last known addresses, retry after %v", s.service, s.tag, err, wait)
+			s.markReady()
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		wait = 0
+
+		// reset the index if it goes backwards, such as consul restarts
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+		s.update(extractAddresses(entries), extractWeights(entries))
+	}
+}
+
+func (s *catalogService) markReady() {
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
+}
+
+func (s *catalogService) snapshot() ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.addrs...), s.known
+}
+
+func (s *catalogService) weightsSnapshot() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.weights)
+}
+
+func (s *catalogService) update(addrs []string, weights map[string]int) {
+	defer s.markReady()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.weights = weights
+	if s.known && equalAddresses(s.addrs, addrs) {
+		return
+	}
+	s.addrs = addrs
+	s.known = true
+
+	for _, ch := range s.subs {
+		// only keep the latest addresses for slow subscribers
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- append([]string(nil), addrs...)
+	}
+}
+
+func equalAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]int, len(a))
+	for _, addr := range a {
+		set[addr]++
+	}
+	for _, addr := range b {
+		if set[addr] == 0 {
+			return false
+		}
+		set[addr]--
+	}
+	return true
+}
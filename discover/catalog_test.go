@@ -0,0 +1,36 @@
+package discover
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogWatch(t *testing.T) {
+	srv, c := newTestClient(t)
+	srv.AddService("app-1", "app", "", 8080, "dev")
+
+	assert.Equal(t, []string{"127.0.0.1:8080"}, c.GetAllAddressWithTag("app", "dev"))
+
+	ch := c.Watch("app", "dev")
+	assert.Equal(t, []string{"127.0.0.1:8080"}, <-ch)
+
+	srv.AddService("app-2", "app", "", 8081, "dev")
+	select {
+	case addrs := <-ch:
+		assert.ElementsMatch(t, []string{"127.0.0.1:8080", "127.0.0.1:8081"}, addrs)
+	case <-time.After(time.Second):
+		t.Fatal("addresses change not watched")
+	}
+
+	// serve the last known addresses while consul is unreachable
+	srv.SetFailing(true)
+	time.Sleep(50 * time.Millisecond)
+	assert.ElementsMatch(t, []string{"127.0.0.1:8080", "127.0.0.1:8081"}, c.GetAllAddressWithTag("app", "dev"))
+}
+
+func TestDirectFinderWatch(t *testing.T) {
+	f := NewDirectFinder()
+	assert.Equal(t, []string{"127.0.0.1:3306"}, <-f.Watch("127.0.0.1:3306", ""))
+}
@@ -0,0 +1,267 @@
+package discover
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+// ChainFinder finds the services in the finders by order, and the first finder
+// which finds the service wins. It is useful for the mixed environments, such as
+// the services in consul with the fixed hosts.
+//
+// The DirectFinder finds every service, so it shadows the finders after it and should be the last one.
+type ChainFinder struct {
+	finders []ServiceFinder
+
+	mu      sync.Mutex
+	watches map[<-chan []string]*chainWatch
+}
+
+func Chain(finders ...ServiceFinder) *ChainFinder {
+	return &ChainFinder{
+		finders: finders,
+		watches: make(map[<-chan []string]*chainWatch),
+	}
+}
+
+func (c *ChainFinder) GetAddress(service string) string {
+	return c.GetAddressWithTag(service, "")
+}
+
+func (c *ChainFinder) GetAllAddress(service string) []string {
+	return c.GetAllAddressWithTag(service, "")
+}
+
+func (c *ChainFinder) GetAddressWithTag(service, tag string) string {
+	addr, err := c.FindAddressWithTag(service, tag)
+	if err != nil {
+		lg.Errorf("Failed to find %s:%s: %v", service, tag, err)
+		return ""
+	}
+	return addr
+}
+
+func (c *ChainFinder) FindAddressWithTag(service, tag string) (string, error) {
+	_, addrs, err := c.find(service, tag)
+	if err != nil {
+		return "", err
+	}
+	return addrs[rand.Intn(len(addrs))], nil
+}
+
+// allAddressFinder finds all the addresses of the service by one lookup, such as the DNSFinder,
+// so that the chain does not look up the service twice for all the addresses.
+type allAddressFinder interface {
+	findAllAddressWithTag(service, tag string) ([]string, error)
+}
+
+// find returns the first finder which finds the service, and the addresses it finds.
+// The addresses are only one of them if the finder is not an allAddressFinder.
+func (c *ChainFinder) find(service, tag string) (ServiceFinder, []string, error) {
+	if len(c.finders) == 0 {
+		return nil, nil, errors.Wrap(ErrServiceNotFound, "no finder in chain")
+	}
+
+	var reasons []string
+	for _, finder := range c.finders {
+		if af, ok := finder.(allAddressFinder); ok {
+			addrs, err := af.findAllAddressWithTag(service, tag)
+			if err == nil {
+				return finder, addrs, nil
+			}
+			reasons = append(reasons, err.Error())
+			continue
+		}
+
+		addr, err := finder.FindAddressWithTag(service, tag)
+		if err == nil {
+			return finder, []string{addr}, nil
+		}
+		reasons = append(reasons, err.Error())
+	}
+	return nil, nil, errors.Wrapf(ErrServiceNotFound, "%s:%s in chain: %s", service, tag, strings.Join(reasons, "; "))
+}
+
+func (c *ChainFinder) GetAllAddressWithTag(service, tag string) []string {
+	finder, addrs, err := c.find(service, tag)
+	if err != nil {
+		lg.Errorf("Failed to find %s:%s: %v", service, tag, err)
+		return nil
+	}
+	if _, ok := finder.(allAddressFinder); ok {
+		return addrs
+	}
+	return finder.GetAllAddressWithTag(service, tag)
+}
+
+// Weights returns the weights of the first finder which finds the service.
+func (c *ChainFinder) Weights(service, tag string) map[string]int {
+	finder, _, err := c.find(service, tag)
+	if err != nil {
+		return nil
+	}
+	if wf, ok := finder.(WeightedFinder); ok {
+		return wf.Weights(service, tag)
+	}
+	return nil
+}
+
+// chainWatch merges the addresses watched from every finder.
+type chainWatch struct {
+	service string
+	tag     string
+	sources []<-chan []string
+	ch      chan []string
+	done    chan struct{}
+}
+
+// Watch watches the service in all finders, and sends the addresses of the first finder which has any.
+func (c *ChainFinder) Watch(service, tag string) <-chan []string {
+	w := &chainWatch{
+		service: service,
+		tag:     tag,
+		ch:      make(chan []string, 1),
+		done:    make(chan struct{}),
+	}
+	for _, finder := range c.finders {
+		w.sources = append(w.sources, finder.Watch(service, tag))
+	}
+
+	c.mu.Lock()
+	c.watches[w.ch] = w
+	c.mu.Unlock()
+
+	go w.run()
+	return w.ch
+}
+
+func (w *chainWatch) run() {
+	defer close(w.ch)
+
+	type update struct {
+		idx   int
+		addrs []string
+		ok    bool
+	}
+	updates := make(chan update)
+	for i, src := range w.sources {
+		go func(i int, src <-chan []string) {
+			for {
+				var (
+					addrs []string
+					ok    bool
+				)
+				// the channels of some finders are never closed, such as the DirectFinder
+				select {
+				case addrs, ok = <-src:
+				case <-w.done:
+					return
+				}
+				select {
+				case updates <- update{idx: i, addrs: addrs, ok: ok}:
+				case <-w.done:
+					return
+				}
+				if !ok {
+					return
+				}
+			}
+		}(i, src)
+	}
+
+	latest := make([][]string, len(w.sources))
+	var (
+		last []string
+		sent bool
+		open = len(w.sources)
+	)
+	for open > 0 {
+		var u update
+		select {
+		case <-w.done:
+			return
+		case u = <-updates:
+		}
+		if !u.ok {
+			open--
+			latest[u.idx] = nil
+			continue
+		}
+		latest[u.idx] = u.addrs
+
+		var addrs []string
+		for _, l := range latest {
+			if len(l) != 0 {
+				addrs = l
+				break
+			}
+		}
+		if sent && equalAddresses(last, addrs) {
+			continue
+		}
+		last, sent = addrs, true
+		select {
+		case <-w.ch:
+		default:
+		}
+		w.ch <- append([]string(nil), addrs...)
+	}
+}
+
+// Unwatch stops sending addresses to the channel returned by Watch and closes it.
+func (c *ChainFinder) Unwatch(ch <-chan []string) {
+	c.mu.Lock()
+	w, ok := c.watches[ch]
+	delete(c.watches, ch)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	close(w.done)
+	for i, finder := range c.finders {
+		if u, ok := finder.(unwatcher); ok {
+			u.Unwatch(w.sources[i])
+		}
+	}
+}
+
+func (c *ChainFinder) RegisterService(service, address string) error {
+	return c.RegisterServiceWithTags(service, address, nil)
+}
+
+func (c *ChainFinder) RegisterServiceWithTag(service, address, tag string) error {
+	return c.RegisterServiceWithTags(service, address, []string{tag})
+}
+
+func (c *ChainFinder) RegisterServiceWithTags(service, address string, tags []string) error {
+	return c.RegisterServiceWithOptions(service, address, tags)
+}
+
+// RegisterServiceWithOptions registers the service into all finders.
+func (c *ChainFinder) RegisterServiceWithOptions(service, address string, tags []string, opts ...RegisterOption) error {
+	for _, finder := range c.finders {
+		if err := finder.RegisterServiceWithOptions(service, address, tags, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ChainFinder) Close() {
+	c.mu.Lock()
+	watches := c.watches
+	c.watches = make(map[<-chan []string]*chainWatch)
+	c.mu.Unlock()
+	for _, w := range watches {
+		close(w.done)
+	}
+
+	for _, finder := range c.finders {
+		finder.Close()
+	}
+}
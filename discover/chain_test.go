@@ -0,0 +1,122 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOverrides(t *testing.T) {
+	overrides, err := ParseOverrides([]string{"mysql=127.0.0.1:3306", "user:dev=127.0.0.1:8080", "user:dev=127.0.0.1:8081"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"mysql":    {"127.0.0.1:3306"},
+		"user:dev": {"127.0.0.1:8080", "127.0.0.1:8081"},
+	}, overrides)
+
+	_, err = ParseOverrides([]string{"mysql"})
+	assert.NotNil(t, err)
+	_, err = ParseOverrides([]string{"mysql="})
+	assert.NotNil(t, err)
+}
+
+func TestChainFinder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("user: 127.0.0.1:8080\n"), 0644))
+	file, err := NewFileFinder(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	override := NewOverrideFinder(map[string][]string{
+		"mysql":    {"127.0.0.1:3306"},
+		"user:dev": {"127.0.0.1:9090"},
+	})
+	chain := Chain(override, file)
+	defer chain.Close()
+
+	addr, err := chain.FindAddressWithTag("mysql", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:3306", addr)
+	assert.Equal(t, "127.0.0.1:9090", chain.GetAddressWithTag("user", "dev"))
+	assert.Equal(t, []string{"127.0.0.1:8080"}, chain.GetAllAddress("user"))
+
+	_, err = chain.FindAddressWithTag("redis", "")
+	assert.ErrorIs(t, err, ErrServiceNotFound)
+	assert.Contains(t, err.Error(), "no override of redis")
+	assert.Contains(t, err.Error(), path)
+	assert.Equal(t, "", chain.GetAddress("redis"))
+
+	ch := chain.Watch("order", "")
+	assert.Empty(t, <-ch)
+	assert.Nil(t, os.WriteFile(path, []byte("order: 127.0.0.1:8081\n"), 0644))
+	select {
+	case addrs := <-ch:
+		assert.Equal(t, []string{"127.0.0.1:8081"}, addrs)
+	case <-time.After(2 * time.Second):
+		t.Fatal("addresses change of the second finder not watched")
+	}
+
+	chain.Unwatch(ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestNewChainFinder(t *testing.T) {
+	f, err := NewFinder("file:" + filepath.Join(t.TempDir(), "services.yaml") + ",direct")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+	assert.IsType(t, &ChainFinder{}, f)
+	assert.Equal(t, "127.0.0.1:3306", f.GetAddress("127.0.0.1:3306"))
+	assert.Equal(t, "mysql", f.GetAddress("mysql"))
+
+	_, err = NewFinder("direct,etcd")
+	assert.NotNil(t, err)
+	_, err = NewFinder("direct,file:" + filepath.Join(t.TempDir(), "services.yaml"))
+	assert.NotNil(t, err)
+}
+
+func TestChainFinderLookupOnce(t *testing.T) {
+	d := NewDNSFinder("svc.cluster.local", 80)
+	var lookups atomic.Int32
+	d.lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("no such host")
+	}
+	d.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lookups.Add(1)
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+	chain := Chain(NewOverrideFinder(nil), d)
+	defer chain.Close()
+
+	assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, chain.GetAllAddress("user"))
+	assert.EqualValues(t, 1, lookups.Load())
+}
+
+func TestChainFinderUnwatch(t *testing.T) {
+	chain := Chain(NewOverrideFinder(map[string][]string{"mysql": {"127.0.0.1:3306"}}), NewDirectFinder())
+	defer chain.Close()
+
+	before := runtime.NumGoroutine()
+	ch := chain.Watch("mysql", "")
+	assert.NotEmpty(t, <-ch)
+	chain.Unwatch(ch)
+	for range ch {
+	}
+
+	// the goroutines reading the channels never closed exit as well
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
@@ -0,0 +1,103 @@
+package discover
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/v2/internal/shared"
+)
+
+// ConsulConfig is the config of the consul client, which is shared by the
+// service discovery and the remote config. The empty fields fall back to the
+// consul env, such as CONSUL_HTTP_TOKEN.
+type ConsulConfig struct {
+	// Address of consul, default is the consulAddr flag
+	Address    string          `vflags:"address" usage:"Address of consul, default is consulAddr."`
+	Token      string          `vflags:"token" usage:"ACL token of consul." secret:"true"`
+	TokenFile  string          `vflags:"tokenFile" usage:"File containing the ACL token of consul."`
+	Datacenter string          `vflags:"datacenter" usage:"Datacenter of consul, default is the datacenter of the agent."`
+	Namespace  string          `vflags:"namespace" usage:"Namespace of consul enterprise."`
+	TLS        ConsulTLSConfig `vflags:"tls"`
+	// RegistrationCheckInterval is how often the registered services are verified, default is 30s
+	RegistrationCheckInterval time.Duration `vflags:"registrationCheckInterval" usage:"How often the registered services are verified in consul."`
+}
+
+type ConsulTLSConfig struct {
+	Enable             bool   `vflags:"enable" usage:"Connect to consul by https."`
+	CAFile             string `vflags:"caFile" usage:"CA file to verify consul."`
+	CertFile           string `vflags:"certFile" usage:"Client certificate file."`
+	KeyFile            string `vflags:"keyFile" usage:"Client key file."`
+	ServerName         string `vflags:"serverName" usage:"Server name to verify consul."`
+	InsecureSkipVerify bool   `vflags:"insecureSkipVerify" usage:"Skip verifying consul."`
+}
+
+// ApiConfig returns the config of the consul api client.
+func (c *ConsulConfig) ApiConfig() *api.Config {
+	config := api.DefaultConfig()
+	config.Transport.Proxy = nil
+	if c.Address != "" {
+		config.Address = c.Address
+	}
+	if c.Token != "" {
+		config.Token = c.Token
+	}
+	if c.TokenFile != "" {
+		config.TokenFile = c.TokenFile
+	}
+	if c.Datacenter != "" {
+		config.Datacenter = c.Datacenter
+	}
+	if c.Namespace != "" {
+		config.Namespace = c.Namespace
+	}
+
+	if c.TLS.Enable {
+		config.Scheme = "https"
+		config.TLSConfig = api.TLSConfig{
+			Address:            c.TLS.ServerName,
+			CAFile:             c.TLS.CAFile,
+			CertFile:           c.TLS.CertFile,
+			KeyFile:            c.TLS.KeyFile,
+			InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+		}
+	}
+	return config
+}
+
+// NewApiClient creates the consul api client.
+func (c *ConsulConfig) NewApiClient() (*api.Client, error) {
+	if c.TLS.Enable && (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return nil, errors.New("both certFile and keyFile of consul tls are required")
+	}
+	client, err := api.NewClient(c.ApiConfig())
+	if err != nil {
+		return nil, errors.Wrapf(err, "new consul client of %s", c.Address)
+	}
+	return client, nil
+}
+
+var (
+	consulConfigMu sync.RWMutex
+	consulConfig   = &ConsulConfig{}
+)
+
+// SetConsulConfig sets the config of the default consul client, it must be called before the client is used.
+func SetConsulConfig(conf *ConsulConfig) {
+	consulConfigMu.Lock()
+	defer consulConfigMu.Unlock()
+	consulConfig = conf
+}
+
+// GetConsulConfig returns a copy of the config of the default consul client,
+// and the address falls back to the consulAddr flag.
+func GetConsulConfig() *ConsulConfig {
+	consulConfigMu.RLock()
+	defer consulConfigMu.RUnlock()
+	conf := *consulConfig
+	if conf.Address == "" {
+		conf.Address = shared.GetConsulAddress()
+	}
+	return &conf
+}
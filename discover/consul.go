@@ -2,10 +2,12 @@ package discover
 
 import (
 	"fmt"
+	"maps"
 	"math/rand"
 	"net"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,20 +15,14 @@ import (
 	"github.com/hashicorp/consul/api"
 	"github.com/pkg/errors"
 	"github.com/superwhys/venkit/lg/v2"
-	"github.com/superwhys/venkit/v2/internal/shared"
-	"golang.org/x/sync/singleflight"
 	"gopkg.in/mgo.v2/bson"
 )
 
-type RegisteredService struct {
-	ServiceID string
-	CheckID   string
-}
-
 type Client struct {
 	*api.Client
-	sg       singleflight.Group
-	services []RegisteredService
+	address   string
+	catalog   *catalog
+	registrar *registrar
 }
 
 var HostAddress string
@@ -48,60 +44,73 @@ func init() {
 var (
 	once                sync.Once
 	defaultConsulClient *Client
+	defaultConsulErr    error
 )
 
 func GetConsulAddress() string {
 	return HostAddress + ":8500"
 }
 
+// GetConsulClient returns the default consul client created by the ConsulConfig,
+// and exits if the client can not be created.
 func GetConsulClient() *Client {
+	client, err := getConsulClient()
+	if err != nil {
+		lg.Fatalf("Create consul client error: %v", err)
+	}
+	return client
+}
+
+func getConsulClient() (*Client, error) {
 	once.Do(func() {
-		defaultConsulClient = newConsulClient(shared.GetConsulAddress())
-		lg.Debugc(lg.Ctx, "ConsulAddr: %v", shared.GetConsulAddress())
+		conf := GetConsulConfig()
+		defaultConsulClient, defaultConsulErr = NewConsulClient(conf)
+		lg.Debugc(lg.Ctx, "ConsulAddr: %v", conf.Address)
 	})
 
-	return defaultConsulClient
+	return defaultConsulClient, defaultConsulErr
 }
 
-func newConsulClient(address string) *Client {
-	config := api.DefaultConfig()
-	config.Transport.Proxy = nil
-	config.Address = address
-	client, err := api.NewClient(config)
+// NewConsulClient creates a consul client, which should be closed after use.
+func NewConsulClient(conf *ConsulConfig) (*Client, error) {
+	client, err := conf.NewApiClient()
 	if err != nil {
-		lg.Error("Failed to connect to consul.", err)
+		return nil, err
 	}
+
 	return &Client{
-		Client: client,
-	}
+		Client:    client,
+		address:   conf.Address,
+		catalog:   newCatalog(client),
+		registrar: newRegistrar(client, conf.RegistrationCheckInterval),
+	}, nil
 }
 
-func (c *Client) findInConsul(serviceName string, tag string) ([]*api.ServiceEntry, error) {
-	ret, err, _ := c.sg.Do(fmt.Sprintf("%s:%s", serviceName, tag), func() (interface{}, error) {
-		cs, _, err := c.Health().Service(serviceName, tag, true, nil)
-		return cs, err
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	v, ok := ret.([]*api.ServiceEntry)
-	if !ok {
-		return nil, nil
+func entryAddress(s *api.ServiceEntry) string {
+	if s.Service.Address != "" {
+		return fmt.Sprintf("%s:%d", s.Service.Address, s.Service.Port)
 	}
-	return v, nil
+	return fmt.Sprintf("%s:%d", s.Node.Address, s.Service.Port)
 }
 
 func extractAddresses(cs []*api.ServiceEntry) []string {
 	ret := make([]string, 0, len(cs))
 	for _, s := range cs {
-		addr := ""
-		if s.Service.Address != "" {
-			addr = fmt.Sprintf("%s:%d", s.Service.Address, s.Service.Port)
-		} else {
-			addr = fmt.Sprintf("%s:%d", s.Node.Address, s.Service.Port)
+		ret = append(ret, entryAddress(s))
+	}
+	return ret
+}
+
+// extractWeights returns the weight of each address, which is the `weight` meta of the
+// service if it is set, otherwise the passing weight of the service.
+func extractWeights(cs []*api.ServiceEntry) map[string]int {
+	ret := make(map[string]int, len(cs))
+	for _, s := range cs {
+		weight := s.Service.Weights.Passing
+		if w, err := strconv.Atoi(s.Service.Meta[WeightMetaKey]); err == nil {
+			weight = w
 		}
-		ret = append(ret, addr)
+		ret[entryAddress(s)] = weight
 	}
 	return ret
 }
@@ -135,32 +144,42 @@ func checkip(addr string) bool {
 }
 
 func (c *Client) GetAddressWithTag(service string, tag string) string {
-	if checkip(service) {
-		return service
-	}
-
-	cs := c.GetAllAddressWithTag(service, tag)
-	if len(cs) == 0 {
+	addr, err := c.FindAddressWithTag(service, tag)
+	if err != nil {
+		lg.Errorf("Failed to find %s:%s in consul: %v", service, tag, err)
 		return ""
 	}
-	addr := cs[0]
 
 	lg.Debugf("Find %s address in consul. Addr=%s", strings.Join([]string{service, tag}, ":"), addr)
 	return addr
 }
 
+func (c *Client) FindAddressWithTag(service string, tag string) (string, error) {
+	if checkip(service) {
+		return service, nil
+	}
+
+	cs, known := c.catalog.addresses(service, tag)
+	if !known {
+		return "", errors.Errorf("%s:%s has never been fetched, consul %s may be unreachable", service, tag, c.address)
+	}
+	if len(cs) == 0 {
+		return "", errors.Wrapf(ErrServiceNotFound, "no healthy %s:%s in consul", service, tag)
+	}
+	return cs[rand.Intn(len(cs))], nil
+}
+
 func (c *Client) GetAllAddress(service string) []string {
 	return c.GetAllAddressWithTag(service, "")
 }
 
 func (c *Client) GetAllAddressWithTag(service string, tag string) []string {
-	entries, err := c.findInConsul(service, tag)
-	if err != nil || len(entries) == 0 {
+	cs, _ := c.catalog.addresses(service, tag)
+	if len(cs) == 0 {
 		lg.Errorf("Failed to find %s:%s in consul.", service, tag)
 		return nil
 	}
 
-	cs := extractAddresses(entries)
 	rand.Shuffle(len(cs), func(i, j int) {
 		cs[i], cs[j] = cs[j], cs[i]
 	})
@@ -168,6 +187,23 @@ func (c *Client) GetAllAddressWithTag(service string, tag string) []string {
 	return cs
 }
 
+// Watch returns a channel which receives the healthy addresses of the service every time they change.
+// The current addresses are sent at once if they are known. The channel is closed when the client closes.
+func (c *Client) Watch(service, tag string) <-chan []string {
+	return c.catalog.subscribe(service, tag)
+}
+
+// Weights returns the weight of each healthy address of the service.
+func (c *Client) Weights(service, tag string) map[string]int {
+	c.catalog.addresses(service, tag)
+	return c.catalog.get(service, tag).weightsSnapshot()
+}
+
+// Unwatch stops sending addresses to the channel returned by Watch and closes it.
+func (c *Client) Unwatch(ch <-chan []string) {
+	c.catalog.unsubscribe(ch)
+}
+
 var validServiceName = regexp.MustCompile(`^[a-zA-Z0-9-]+$`).MatchString
 
 func (c *Client) RegisterService(serviceName string, address string) error {
@@ -179,63 +215,107 @@ func (c *Client) RegisterServiceWithTag(serviceName string, address string, tag
 }
 
 func (c *Client) RegisterServiceWithTags(serviceName string, address string, tags []string) error {
+	return c.RegisterServiceWithOptions(serviceName, address, tags)
+}
+
+// RegisterServiceWithOptions registers the service listening on the address into consul.
+// By default, the service is advertised with the address of the consul agent node, and checked by tcp.
+func (c *Client) RegisterServiceWithOptions(serviceName string, address string, tags []string, opts ...RegisterOption) error {
+	_, err := c.Register(serviceName, address, tags, opts...)
+	return err
+}
+
+// Register registers the service like RegisterServiceWithOptions and returns the service id.
+// The service is kept registered until it is deregistered or the client closes, and it is
+// re-registered if consul loses it.
+func (c *Client) Register(serviceName string, address string, tags []string, opts ...RegisterOption) (string, error) {
 	if !validServiceName(serviceName) {
-		return errors.New("Invalid service name")
+		return "", errors.New("Invalid service name")
+	}
+	o := NewRegisterOptions(opts...)
+	if o.Endpoint != "" && !validServiceName(o.Endpoint) {
+		return "", errors.Errorf("Invalid endpoint name %q", o.Endpoint)
 	}
 
 	// parse host and port from address
-	ip, err := net.ResolveTCPAddr("tcp", address)
+	listenHost, portStr, err := net.SplitHostPort(address)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	hostname, err := os.Hostname()
+	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		hostname = bson.NewObjectId().Hex()
+		return "", errors.Wrapf(err, "invalid port of %s", address)
+	}
+	host, err := o.advertiseHost(listenHost)
+	if err != nil {
+		return "", errors.Wrap(err, "advertise address")
+	}
+	checkHost := host
+	if checkHost == "" {
+		checkHost = "127.0.0.1"
+	}
+
+	serviceID := o.ServiceID
+	if serviceID == "" {
+		serviceID = defaultServiceID(serviceName, o.Endpoint, port)
 	}
-	hostname = strings.ReplaceAll(hostname, ".", "-")
-	serviceID := fmt.Sprintf("%s-%d-%s", serviceName, ip.Port, hostname)
 	checkID := fmt.Sprintf("service:%s", serviceID)
 
+	meta := o.Meta
+	if o.Endpoint != "" {
+		meta = maps.Clone(meta)
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[EndpointMetaKey] = o.Endpoint
+	}
 	regis := &api.AgentServiceRegistration{
-		ID:   serviceID,
-		Name: serviceName,
-		Port: ip.Port,
-		Tags: tags,
-		Check: &api.AgentServiceCheck{
-			CheckID:                        checkID,
-			Name:                           serviceID,
-			TCP:                            fmt.Sprintf("127.0.0.1:%d", ip.Port),
-			Interval:                       (time.Second * 10).String(),
-			Status:                         "passing",
-			DeregisterCriticalServiceAfter: "10m",
-		},
+		ID:      serviceID,
+		Name:    serviceName,
+		Address: host,
+		Port:    port,
+		Tags:    tags,
+		Meta:    meta,
+		Check:   o.check(checkID, serviceID, checkHost, port),
 	}
-	if err := c.Agent().ServiceRegister(regis); err != nil {
-		return errors.Errorf("initial register service '%s' host to consul error: %s", serviceName, err.Error())
+	if o.Weight > 0 {
+		regis.Weights = &api.AgentWeights{Passing: o.Weight, Warning: 1}
 	}
 
-	c.services = append(c.services, RegisteredService{ServiceID: serviceID, CheckID: checkID})
-	return nil
+	var ttl time.Duration
+	if o.Check == CheckTTL {
+		ttl = o.TTL
+	}
+	if err := c.registrar.register(regis, ttl); err != nil {
+		return "", errors.Errorf("initial register service '%s' host to consul error: %s", serviceName, err.Error())
+	}
+	return serviceID, nil
 }
 
-func (c *Client) deregisterServiceAndCheck(serviceID, checkID string) (reterr error) {
-	if err := c.Agent().CheckDeregister(checkID); err != nil {
-		reterr = errors.Wrap(err, "Deregister check")
+// defaultServiceID returns the id like name-port-hostname, or name-endpoint-port-hostname.
+func defaultServiceID(serviceName, endpoint string, port int) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = bson.NewObjectId().Hex()
 	}
-
-	if err := c.Agent().ServiceDeregister(serviceID); err != nil {
-		reterr = errors.Wrap(err, "Deregister service")
+	hostname = strings.ReplaceAll(hostname, ".", "-")
+	if endpoint != "" {
+		return fmt.Sprintf("%s-%s-%d-%s", serviceName, endpoint, port, hostname)
 	}
-	return
+	return fmt.Sprintf("%s-%d-%s", serviceName, port, hostname)
+}
+
+// Deregister removes the service registered by the client from consul.
+func (c *Client) Deregister(serviceID string) error {
+	return c.registrar.deregister(serviceID)
+}
+
+// Registrations returns the services registered by the client.
+func (c *Client) Registrations() []RegisteredService {
+	return c.registrar.list()
 }
 
 func (c *Client) Close() {
-	for _, r := range c.services {
-		if err := c.deregisterServiceAndCheck(r.ServiceID, r.CheckID); err != nil {
-			lg.Error("Deregister", r.ServiceID, err)
-		} else {
-			lg.Info("Deregistered", r.ServiceID)
-		}
-	}
+	c.catalog.close()
+	c.registrar.close()
 }
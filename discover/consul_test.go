@@ -2,18 +2,34 @@ package discover
 
 import (
 	"testing"
-)
+	"time"
 
-var (
-	client *Client
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/superwhys/venkit/v2/internal/consultest"
 )
 
-func TestMain(m *testing.M) {
-	client = GetConsulClient()
-	m.Run()
+// newTestClient returns a consul client connected to a fake consul.
+func newTestClient(t *testing.T, opts ...func(*ConsulConfig)) (*consultest.Server, *Client) {
+	srv := consultest.NewServer()
+	t.Cleanup(srv.Close)
+
+	conf := &ConsulConfig{Address: srv.Addr()}
+	for _, opt := range opts {
+		opt(conf)
+	}
+	c, err := NewConsulClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return srv, c
 }
 
 func TestClient_GetAddressWithTag(t *testing.T) {
+	srv, client := newTestClient(t)
+	srv.AddService("openai-1", "openai", "127.0.0.1", 29915, "v1.0.1")
+
 	type args struct {
 		service string
 		tag     string
@@ -23,8 +39,10 @@ func TestClient_GetAddressWithTag(t *testing.T) {
 		args args
 		want string
 	}{
-		{"test-with-no-tag", args{"openai", ""}, "10.211.55.5:29915"},
-		{"test-with-tag", args{"openai", "v1.0.1"}, "10.211.55.5:29915"},
+		{"test-with-no-tag", args{"openai", ""}, "127.0.0.1:29915"},
+		{"test-with-tag", args{"openai", "v1.0.1"}, "127.0.0.1:29915"},
+		{"test-with-unknown-tag", args{"openai", "v2"}, ""},
+		{"test-with-unknown-service", args{"anthropic", ""}, ""},
 		{"test-with-ip", args{"10.11.43.113", ""}, "10.11.43.113"},
 		{"test-with-ip-ports", args{"10.11.43.113:28080", ""}, "10.11.43.113:28080"},
 	}
@@ -36,3 +54,49 @@ func TestClient_GetAddressWithTag(t *testing.T) {
 		})
 	}
 }
+
+func TestClientHealthyAddresses(t *testing.T) {
+	srv, client := newTestClient(t)
+	srv.AddService("app-1", "app", "", 8080, "dev")
+	srv.AddService("app-2", "app", "10.0.0.2", 8080, "dev")
+
+	ch := client.Watch("app", "dev")
+	assert.ElementsMatch(t, []string{"127.0.0.1:8080", "10.0.0.2:8080"}, <-ch)
+
+	// the service with critical check is excluded
+	assert.True(t, srv.SetCheckStatus("service:app-2", api.HealthCritical))
+	select {
+	case addrs := <-ch:
+		assert.Equal(t, []string{"127.0.0.1:8080"}, addrs)
+	case <-time.After(time.Second):
+		t.Fatal("check change not watched")
+	}
+
+	srv.RemoveService("app-1")
+	select {
+	case addrs := <-ch:
+		assert.Empty(t, addrs)
+	case <-time.After(time.Second):
+		t.Fatal("deregister not watched")
+	}
+	_, err := client.FindAddressWithTag("app", "dev")
+	assert.ErrorIs(t, err, ErrServiceNotFound)
+}
+
+func TestClientDeregisterOnClose(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+
+	c, err := NewConsulClient(&ConsulConfig{Address: srv.Addr()})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, c.RegisterServiceWithOptions("app", "127.0.0.1:8080", []string{"dev"}, WithoutCheck()))
+	if !assert.Len(t, srv.Services(), 1) {
+		return
+	}
+	assert.Equal(t, "app", srv.Services()[0].Service)
+
+	c.Close()
+	assert.Empty(t, srv.Services())
+}
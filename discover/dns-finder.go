@@ -0,0 +1,203 @@
+package discover
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+var (
+	// DNSRefreshInterval is how often the watched services are resolved again.
+	DNSRefreshInterval = 30 * time.Second
+	// DNSLookupTimeout is the timeout of every lookup.
+	DNSLookupTimeout = 5 * time.Second
+)
+
+// DNSFinder finds the services by DNS, such as the services of kubernetes.
+// The service is resolved as <service>.<domain>, unless it is a full name already.
+// With a tag, the SRV record _<tag>._tcp.<service>.<domain> is looked up, which is
+// the named port of kubernetes. Otherwise the SRV record of the name is looked up,
+// and falls back to the A records with the default port.
+type DNSFinder struct {
+	domain string
+	port   int
+
+	lookupSRV  func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	watchOnce sync.Once
+	watchers  watchers
+}
+
+func NewDNSFinder(domain string, port int) *DNSFinder {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DNSFinder{
+		domain:     strings.Trim(domain, "."),
+		port:       port,
+		lookupSRV:  net.DefaultResolver.LookupSRV,
+		lookupHost: net.DefaultResolver.LookupHost,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+func (d *DNSFinder) name(service string) string {
+	if d.domain == "" || strings.Contains(service, ".") {
+		return service
+	}
+	return service + "." + d.domain
+}
+
+// resolve returns nil if the lookup fails.
+func (d *DNSFinder) resolve(service, tag string) []string {
+	addrs, err := d.lookup(service, tag)
+	if err != nil {
+		lg.Errorf("Failed to lookup %s:%s: %v", service, tag, err)
+		return nil
+	}
+	return addrs
+}
+
+func (d *DNSFinder) lookup(service, tag string) ([]string, error) {
+	if _, _, err := net.SplitHostPort(service); err == nil {
+		return []string{service}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, DNSLookupTimeout)
+	defer cancel()
+
+	name := d.name(service)
+	var srvs []*net.SRV
+	var err error
+	if tag != "" {
+		_, srvs, err = d.lookupSRV(ctx, tag, "tcp", name)
+	} else {
+		_, srvs, err = d.lookupSRV(ctx, "", "", name)
+	}
+	if err == nil && len(srvs) != 0 {
+		ret := make([]string, 0, len(srvs))
+		for _, srv := range srvs {
+			ret = append(ret, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		return ret, nil
+	}
+	if tag != "" {
+		lg.Debugf("Lookup SRV of %s:%s error: %v, fall back to A records", service, tag, err)
+	}
+
+	hosts, err := d.lookupHost(ctx, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "lookup %s", name)
+	}
+	ret := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		ret = append(ret, net.JoinHostPort(host, strconv.Itoa(d.port)))
+	}
+	return ret, nil
+}
+
+func (d *DNSFinder) GetAddress(service string) string {
+	return d.GetAddressWithTag(service, "")
+}
+
+func (d *DNSFinder) GetAllAddress(service string) []string {
+	return d.GetAllAddressWithTag(service, "")
+}
+
+func (d *DNSFinder) GetAddressWithTag(service, tag string) string {
+	addr, err := d.FindAddressWithTag(service, tag)
+	if err != nil {
+		lg.Errorf("Failed to find %s:%s: %v", service, tag, err)
+		return ""
+	}
+	return addr
+}
+
+func (d *DNSFinder) FindAddressWithTag(service, tag string) (string, error) {
+	addrs, err := d.findAllAddressWithTag(service, tag)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+func (d *DNSFinder) findAllAddressWithTag(service, tag string) ([]string, error) {
+	addrs, err := d.lookup(service, tag)
+	if err != nil {
+		return nil, errors.Wrap(ErrServiceNotFound, err.Error())
+	}
+	if len(addrs) == 0 {
+		return nil, errors.Wrapf(ErrServiceNotFound, "no record of %s", d.name(service))
+	}
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	return addrs, nil
+}
+
+func (d *DNSFinder) GetAllAddressWithTag(service, tag string) []string {
+	addrs := d.resolve(service, tag)
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	return addrs
+}
+
+// Watch resolves the service every DNSRefreshInterval, and sends the addresses when they change.
+func (d *DNSFinder) Watch(service, tag string) <-chan []string {
+	d.watchOnce.Do(func() {
+		go d.refresh()
+	})
+	return d.watchers.add(service, tag, d.resolve(service, tag))
+}
+
+// Unwatch stops sending addresses to the channel returned by Watch and closes it.
+func (d *DNSFinder) Unwatch(ch <-chan []string) {
+	d.watchers.remove(ch)
+}
+
+func (d *DNSFinder) refresh() {
+	ticker := time.NewTicker(DNSRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			// resolve returns nil on lookup error, which keeps the last known addresses
+			d.watchers.notify(d.resolve)
+		}
+	}
+}
+
+// RegisterService does nothing, since the DNS records are managed by others such as kubernetes.
+func (d *DNSFinder) RegisterService(service, address string) error {
+	return nil
+}
+
+func (d *DNSFinder) RegisterServiceWithTag(service, address, tag string) error {
+	return d.RegisterService(service, address)
+}
+
+func (d *DNSFinder) RegisterServiceWithTags(service, address string, tags []string) error {
+	return d.RegisterService(service, address)
+}
+
+func (d *DNSFinder) RegisterServiceWithOptions(service, address string, tags []string, opts ...RegisterOption) error {
+	return d.RegisterService(service, address)
+}
+
+func (d *DNSFinder) Close() {
+	d.cancel()
+	d.watchers.close()
+}
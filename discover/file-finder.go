@@ -0,0 +1,354 @@
+package discover
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEndpoint is an address of the service in the file of FileFinder.
+type FileEndpoint struct {
+	Address string   `yaml:"address" json:"address"`
+	Tags    []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Weight  int      `yaml:"weight,omitempty" json:"weight,omitempty"`
+}
+
+// UnmarshalYAML accepts both the address itself and the full endpoint.
+func (e *FileEndpoint) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&e.Address)
+	}
+	type endpoint FileEndpoint
+	return node.Decode((*endpoint)(e))
+}
+
+type fileEndpoints []FileEndpoint
+
+// UnmarshalYAML accepts both a single endpoint and a list of endpoints.
+func (es *fileEndpoints) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode((*[]FileEndpoint)(es))
+	}
+	var e FileEndpoint
+	if err := node.Decode(&e); err != nil {
+		return err
+	}
+	*es = fileEndpoints{e}
+	return nil
+}
+
+// FileFinder finds the services from a yaml or json file, which is reloaded when it changes.
+// It is useful for local development, e.g.
+//
+//	mysql: 127.0.0.1:3306
+//	user:
+//	  - 127.0.0.1:8080
+//	  - address: 127.0.0.1:8081
+//	    tags: [dev]
+//	    weight: 2
+//
+// The registered services are written into the file and removed on Close,
+// so that the processes sharing the file can find each other.
+type FileFinder struct {
+	path   string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.RWMutex
+	services   map[string]fileEndpoints
+	registered []Service
+
+	// fileMu serializes the writes to the file in this process
+	fileMu   sync.Mutex
+	watchers watchers
+}
+
+func NewFileFinder(path string) (*FileFinder, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &FileFinder{
+		path:   path,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	services, err := f.load()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	f.services = services
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "new watcher")
+	}
+	// watch the directory instead of the file, since editors and config maps replace the file
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		cancel()
+		watcher.Close()
+		return nil, errors.Wrap(err, "watch services directory")
+	}
+	go f.watch(watcher)
+	return f, nil
+}
+
+// load reads the services from the file, and an absent file means no services.
+func (f *FileFinder) load() (map[string]fileEndpoints, error) {
+	services := make(map[string]fileEndpoints)
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return services, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read services file")
+	}
+	// json is a subset of yaml
+	if err := yaml.Unmarshal(data, &services); err != nil {
+		return nil, errors.Wrapf(err, "parse services file %v", f.path)
+	}
+	return services, nil
+}
+
+func (f *FileFinder) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case err := <-watcher.Errors:
+			lg.Warnc(f.ctx, "Watch services file error: %v", err)
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) != filepath.Clean(f.path) {
+				continue
+			}
+			f.reload()
+		}
+	}
+}
+
+func (f *FileFinder) reload() {
+	// an empty file is probably being written, wait for the next event
+	if info, err := os.Stat(f.path); err == nil && info.Size() == 0 {
+		return
+	}
+
+	services, err := f.load()
+	if err != nil {
+		lg.Warnc(f.ctx, "Reload services file error: %v, keep the last services", err)
+		return
+	}
+
+	f.mu.Lock()
+	f.services = services
+	f.mu.Unlock()
+
+	f.watchers.notify(f.addresses)
+}
+
+func (f *FileFinder) endpoints(service, tag string) []FileEndpoint {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var ret []FileEndpoint
+	for _, e := range f.services[service] {
+		if tag == "" || slices.Contains(e.Tags, tag) {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func (f *FileFinder) addresses(service, tag string) []string {
+	endpoints := f.endpoints(service, tag)
+	ret := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		ret = append(ret, e.Address)
+	}
+	return ret
+}
+
+func (f *FileFinder) GetAddress(service string) string {
+	return f.GetAddressWithTag(service, "")
+}
+
+func (f *FileFinder) GetAllAddress(service string) []string {
+	return f.GetAllAddressWithTag(service, "")
+}
+
+func (f *FileFinder) GetAddressWithTag(service, tag string) string {
+	addr, err := f.FindAddressWithTag(service, tag)
+	if err != nil {
+		lg.Errorf("Failed to find %s:%s: %v", service, tag, err)
+		return ""
+	}
+	return addr
+}
+
+func (f *FileFinder) FindAddressWithTag(service, tag string) (string, error) {
+	if checkip(service) {
+		return service, nil
+	}
+
+	addrs := f.addresses(service, tag)
+	if len(addrs) == 0 {
+		return "", errors.Wrapf(ErrServiceNotFound, "no %s:%s in %s", service, tag, f.path)
+	}
+	return addrs[rand.Intn(len(addrs))], nil
+}
+
+func (f *FileFinder) GetAllAddressWithTag(service, tag string) []string {
+	addrs := f.addresses(service, tag)
+	if len(addrs) == 0 {
+		lg.Errorf("Failed to find %s:%s in %s.", service, tag, f.path)
+		return nil
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	return addrs
+}
+
+// Weights returns the weight of each address of the service, which is 1 if it is not set.
+func (f *FileFinder) Weights(service, tag string) map[string]int {
+	ret := make(map[string]int)
+	for _, e := range f.endpoints(service, tag) {
+		ret[e.Address] = max(e.Weight, 1)
+	}
+	return ret
+}
+
+func (f *FileFinder) Watch(service, tag string) <-chan []string {
+	return f.watchers.add(service, tag, f.addresses(service, tag))
+}
+
+// Unwatch stops sending addresses to the channel returned by Watch and closes it.
+func (f *FileFinder) Unwatch(ch <-chan []string) {
+	f.watchers.remove(ch)
+}
+
+func (f *FileFinder) RegisterService(service, address string) error {
+	return f.RegisterServiceWithTags(service, address, nil)
+}
+
+func (f *FileFinder) RegisterServiceWithTag(service, address, tag string) error {
+	return f.RegisterServiceWithTags(service, address, []string{tag})
+}
+
+func (f *FileFinder) RegisterServiceWithTags(service, address string, tags []string) error {
+	return f.RegisterServiceWithOptions(service, address, tags)
+}
+
+// RegisterServiceWithOptions writes the service into the file with the advertised address and weight,
+// and the address listening on all interfaces is written as 127.0.0.1.
+func (f *FileFinder) RegisterServiceWithOptions(service, address string, tags []string, opts ...RegisterOption) error {
+	if !validServiceName(service) {
+		return errors.New("Invalid service name")
+	}
+	o := NewRegisterOptions(opts...)
+	listenHost, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "parse address")
+	}
+	host, err := o.advertiseHost(listenHost)
+	if err != nil {
+		return errors.Wrap(err, "advertise address")
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	address = net.JoinHostPort(host, port)
+	tags = slices.DeleteFunc(slices.Clone(tags), func(tag string) bool { return tag == "" })
+
+	err = f.update(func(services map[string]fileEndpoints) {
+		endpoints := slices.DeleteFunc(services[service], func(e FileEndpoint) bool { return e.Address == address })
+		services[service] = append(endpoints, FileEndpoint{Address: address, Tags: tags, Weight: o.Weight})
+	})
+	if err != nil {
+		return errors.Wrapf(err, "register service '%s' into %s", service, f.path)
+	}
+
+	f.mu.Lock()
+	f.registered = append(f.registered, Service{ServiceName: service, Address: address, Tags: tags})
+	f.mu.Unlock()
+	return nil
+}
+
+// update reads the file, modifies the services and writes them back.
+func (f *FileFinder) update(modify func(services map[string]fileEndpoints)) error {
+	f.fileMu.Lock()
+	defer f.fileMu.Unlock()
+
+	services, err := f.load()
+	if err != nil {
+		return err
+	}
+	modify(services)
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(f.path), ".json") {
+		data, err = json.MarshalIndent(services, "", "  ")
+	} else {
+		buf := &bytes.Buffer{}
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+		err = enc.Encode(services)
+		data = buf.Bytes()
+	}
+	if err != nil {
+		return errors.Wrap(err, "encode services")
+	}
+
+	// write atomically, so that the other processes never read a partial file
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "write services file")
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return errors.Wrap(err, "rename services file")
+	}
+
+	f.mu.Lock()
+	f.services = services
+	f.mu.Unlock()
+	f.watchers.notify(f.addresses)
+	return nil
+}
+
+// Close stops watching the file, and removes the registered services from it.
+func (f *FileFinder) Close() {
+	f.cancel()
+
+	f.mu.Lock()
+	registered := f.registered
+	f.registered = nil
+	f.mu.Unlock()
+
+	if len(registered) != 0 {
+		err := f.update(func(services map[string]fileEndpoints) {
+			for _, r := range registered {
+				services[r.ServiceName] = slices.DeleteFunc(services[r.ServiceName], func(e FileEndpoint) bool { return e.Address == r.Address })
+				if len(services[r.ServiceName]) == 0 {
+					delete(services, r.ServiceName)
+				}
+			}
+		})
+		if err != nil {
+			lg.Error("Deregister services from", f.path, err)
+		}
+	}
+	f.watchers.close()
+}
@@ -1,7 +1,11 @@
 package discover
 
 import (
+	"strconv"
+	"strings"
 	"sync"
+
+	"github.com/pkg/errors"
 )
 
 type Service struct {
@@ -10,15 +14,23 @@ type Service struct {
 	Tags        []string
 }
 
+// ErrServiceNotFound means there is no address of the service.
+var ErrServiceNotFound = errors.New("service not found")
+
 type ServiceFinder interface {
 	GetAddress(service string) string
 	GetAllAddress(service string) []string
 	GetAddressWithTag(service, tag string) string
+	// FindAddressWithTag is the same as GetAddressWithTag, but returns the reason when no address is found.
+	FindAddressWithTag(service, tag string) (string, error)
 	GetAllAddressWithTag(service, tag string) []string
+	// Watch returns a channel which receives the addresses of the service every time they change.
+	Watch(service, tag string) <-chan []string
 
 	RegisterService(service, address string) error
 	RegisterServiceWithTag(service, address, tag string) error
 	RegisterServiceWithTags(service, address string, tags []string) error
+	RegisterServiceWithOptions(service, address string, tags []string, opts ...RegisterOption) error
 	Close()
 }
 
@@ -37,6 +49,13 @@ func GetServiceFinder() ServiceFinder {
 	return defaultServiceFinder
 }
 
+// SetServiceFinder replaces the default ServiceFinder.
+func SetServiceFinder(finder ServiceFinder) {
+	finderMutex.Lock()
+	defer finderMutex.Unlock()
+	defaultServiceFinder = finder
+}
+
 func SetConsulFinderToDefault() {
 	finderMutex.Lock()
 	defer finderMutex.Unlock()
@@ -46,3 +65,60 @@ func SetConsulFinderToDefault() {
 func GetConsulServiceFinder() ServiceFinder {
 	return GetConsulClient()
 }
+
+// NewFinder creates the ServiceFinder by the spec, which is one of
+//
+//	consul                 the consul client
+//	direct                 the DirectFinder
+//	file:services.yaml     the FileFinder of the file
+//	dns[:domain[:port]]    the DNSFinder, the default port of A records is 80
+//
+// Multiple specs separated by comma are chained, e.g. file:services.yaml,consul
+// The direct finder finds every service, so it can only be the last one of the chain.
+func NewFinder(spec string) (ServiceFinder, error) {
+	if strings.Contains(spec, ",") {
+		var finders []ServiceFinder
+		specs := strings.Split(spec, ",")
+		for i, s := range specs {
+			if strings.TrimSpace(s) == "direct" && i != len(specs)-1 {
+				for _, f := range finders {
+					f.Close()
+				}
+				return nil, errors.Errorf("direct shadows the finders after it, it should be the last one of %q", spec)
+			}
+			finder, err := NewFinder(strings.TrimSpace(s))
+			if err != nil {
+				for _, f := range finders {
+					f.Close()
+				}
+				return nil, err
+			}
+			finders = append(finders, finder)
+		}
+		return Chain(finders...), nil
+	}
+
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "consul":
+		return getConsulClient()
+	case "direct":
+		return NewDirectFinder(), nil
+	case "file":
+		if arg == "" {
+			return nil, errors.New("empty services file")
+		}
+		return NewFileFinder(arg)
+	case "dns":
+		domain, port := arg, 80
+		if d, p, ok := strings.Cut(arg, ":"); ok {
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid dns port %q", p)
+			}
+			domain, port = d, n
+		}
+		return NewDNSFinder(domain, port), nil
+	}
+	return nil, errors.Errorf("unknown discovery %q", spec)
+}
@@ -0,0 +1,132 @@
+package discover
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileFinder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	err := os.WriteFile(path, []byte(`
+mysql: 127.0.0.1:3306
+user:
+  - 127.0.0.1:8080
+  - address: 127.0.0.1:8081
+    tags: [dev]
+    weight: 2
+`), 0644)
+	assert.Nil(t, err)
+
+	f, err := NewFileFinder(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+
+	assert.Equal(t, "127.0.0.1:3306", f.GetAddress("mysql"))
+	assert.ElementsMatch(t, []string{"127.0.0.1:8080", "127.0.0.1:8081"}, f.GetAllAddress("user"))
+	assert.Equal(t, []string{"127.0.0.1:8081"}, f.GetAllAddressWithTag("user", "dev"))
+	assert.Equal(t, map[string]int{"127.0.0.1:8080": 1, "127.0.0.1:8081": 2}, f.Weights("user", ""))
+	assert.Empty(t, f.GetAllAddress("redis"))
+
+	ch := f.Watch("user", "dev")
+	assert.Equal(t, []string{"127.0.0.1:8081"}, <-ch)
+
+	err = os.WriteFile(path, []byte(`{"user": [{"address": "127.0.0.1:8082", "tags": ["dev"]}]}`), 0644)
+	assert.Nil(t, err)
+	select {
+	case addrs := <-ch:
+		assert.Equal(t, []string{"127.0.0.1:8082"}, addrs)
+	case <-time.After(2 * time.Second):
+		t.Fatal("services file change not watched")
+	}
+
+	// the invalid file is ignored
+	assert.Nil(t, os.WriteFile(path, []byte(`user: [`), 0644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"127.0.0.1:8082"}, f.GetAllAddressWithTag("user", "dev"))
+}
+
+func TestFileFinderRegister(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+
+	f, err := NewFileFinder(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	other, err := NewFileFinder(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer other.Close()
+
+	ch := other.Watch("user", "dev")
+	assert.Empty(t, <-ch)
+
+	assert.Nil(t, f.RegisterServiceWithTags("user", "[::]:8080", []string{"dev"}))
+	assert.Equal(t, []string{"127.0.0.1:8080"}, f.GetAllAddressWithTag("user", "dev"))
+	select {
+	case addrs := <-ch:
+		assert.Equal(t, []string{"127.0.0.1:8080"}, addrs)
+	case <-time.After(2 * time.Second):
+		t.Fatal("registered service not found by the other finder")
+	}
+
+	f.Close()
+	select {
+	case addrs := <-ch:
+		assert.Empty(t, addrs)
+	case <-time.After(2 * time.Second):
+		t.Fatal("deregistered service still found by the other finder")
+	}
+}
+
+func TestDNSFinder(t *testing.T) {
+	d := NewDNSFinder("default.svc.cluster.local", 80)
+	defer d.Close()
+	d.lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if service == "grpc" && proto == "tcp" && name == "user.default.svc.cluster.local" {
+			return "", []*net.SRV{{Target: "user-0.user.default.svc.cluster.local.", Port: 9090}}, nil
+		}
+		return "", nil, errors.New("no such host")
+	}
+	d.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "user.default.svc.cluster.local" {
+			return []string{"10.0.0.1", "10.0.0.2"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	assert.Equal(t, []string{"user-0.user.default.svc.cluster.local:9090"}, d.GetAllAddressWithTag("user", "grpc"))
+	assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, d.GetAllAddress("user"))
+	assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, d.GetAllAddressWithTag("user", "http"))
+	assert.Equal(t, "127.0.0.1:3306", d.GetAddress("127.0.0.1:3306"))
+	assert.Empty(t, d.GetAllAddress("mysql"))
+}
+
+func TestNewFinder(t *testing.T) {
+	f, err := NewFinder("direct")
+	assert.Nil(t, err)
+	assert.IsType(t, &DirectFinder{}, f)
+
+	f, err = NewFinder("dns:svc.cluster.local:8080")
+	assert.Nil(t, err)
+	assert.Equal(t, "svc.cluster.local", f.(*DNSFinder).domain)
+	assert.Equal(t, 8080, f.(*DNSFinder).port)
+
+	f, err = NewFinder("file:" + filepath.Join(t.TempDir(), "services.yaml"))
+	assert.Nil(t, err)
+	f.Close()
+
+	_, err = NewFinder("file:")
+	assert.NotNil(t, err)
+	_, err = NewFinder("etcd")
+	assert.NotNil(t, err)
+}
@@ -1,5 +1,9 @@
 package discover
 
+import (
+	"github.com/pkg/errors"
+)
+
 type DirectFinder struct{}
 
 func NewDirectFinder() *DirectFinder {
@@ -18,10 +22,24 @@ func (df *DirectFinder) GetAddressWithTag(service string, tag string) string {
 	return df.GetAddress(service)
 }
 
+func (df *DirectFinder) FindAddressWithTag(service string, tag string) (string, error) {
+	if service == "" {
+		return "", errors.Wrap(ErrServiceNotFound, "empty address")
+	}
+	return service, nil
+}
+
 func (df *DirectFinder) GetAllAddressWithTag(service string, tag string) []string {
 	return df.GetAllAddress(service)
 }
 
+// Watch returns a channel with the service itself, since the address never changes.
+func (df *DirectFinder) Watch(service string, tag string) <-chan []string {
+	ch := make(chan []string, 1)
+	ch <- df.GetAllAddress(service)
+	return ch
+}
+
 func (df *DirectFinder) RegisterService(service string, address string) error {
 	return nil
 }
@@ -34,6 +52,10 @@ func (df *DirectFinder) RegisterServiceWithTags(service string, address string,
 	return df.RegisterService(service, address)
 }
 
+func (df *DirectFinder) RegisterServiceWithOptions(service string, address string, tags []string, opts ...RegisterOption) error {
+	return df.RegisterService(service, address)
+}
+
 func (df *DirectFinder) Close() {
 	// do nothing
 }
@@ -0,0 +1,99 @@
+package discover
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+// OverrideFinder finds the services from a fixed map, whose key is service or service:tag.
+// The key with tag takes precedence over the service only.
+type OverrideFinder struct {
+	overrides map[string][]string
+}
+
+func NewOverrideFinder(overrides map[string][]string) *OverrideFinder {
+	return &OverrideFinder{overrides: overrides}
+}
+
+// ParseOverrides parses the overrides like mysql=127.0.0.1:3306 or user:dev=127.0.0.1:8080,
+// and the addresses of the same key are merged.
+func ParseOverrides(items []string) (map[string][]string, error) {
+	overrides := make(map[string][]string)
+	for _, item := range items {
+		key, addr, ok := strings.Cut(item, "=")
+		key, addr = strings.TrimSpace(key), strings.TrimSpace(addr)
+		if !ok || key == "" || addr == "" {
+			return nil, errors.Errorf("invalid override %q, should be service[:tag]=host:port", item)
+		}
+		overrides[key] = append(overrides[key], addr)
+	}
+	return overrides, nil
+}
+
+func (o *OverrideFinder) addresses(service, tag string) []string {
+	if tag != "" {
+		if addrs, ok := o.overrides[service+":"+tag]; ok {
+			return append([]string(nil), addrs...)
+		}
+	}
+	return append([]string(nil), o.overrides[service]...)
+}
+
+func (o *OverrideFinder) GetAddress(service string) string {
+	return o.GetAddressWithTag(service, "")
+}
+
+func (o *OverrideFinder) GetAllAddress(service string) []string {
+	return o.GetAllAddressWithTag(service, "")
+}
+
+func (o *OverrideFinder) GetAddressWithTag(service, tag string) string {
+	addr, err := o.FindAddressWithTag(service, tag)
+	if err != nil {
+		lg.Errorf("Failed to find %s:%s: %v", service, tag, err)
+		return ""
+	}
+	return addr
+}
+
+func (o *OverrideFinder) FindAddressWithTag(service, tag string) (string, error) {
+	addrs := o.addresses(service, tag)
+	if len(addrs) == 0 {
+		return "", errors.Wrapf(ErrServiceNotFound, "no override of %s:%s", service, tag)
+	}
+	return addrs[rand.Intn(len(addrs))], nil
+}
+
+func (o *OverrideFinder) GetAllAddressWithTag(service, tag string) []string {
+	return o.addresses(service, tag)
+}
+
+// Watch returns a channel with the overridden addresses, since they never change.
+func (o *OverrideFinder) Watch(service, tag string) <-chan []string {
+	ch := make(chan []string, 1)
+	ch <- o.addresses(service, tag)
+	return ch
+}
+
+func (o *OverrideFinder) RegisterService(service, address string) error {
+	return nil
+}
+
+func (o *OverrideFinder) RegisterServiceWithTag(service, address, tag string) error {
+	return o.RegisterService(service, address)
+}
+
+func (o *OverrideFinder) RegisterServiceWithTags(service, address string, tags []string) error {
+	return o.RegisterService(service, address)
+}
+
+func (o *OverrideFinder) RegisterServiceWithOptions(service, address string, tags []string, opts ...RegisterOption) error {
+	return o.RegisterService(service, address)
+}
+
+func (o *OverrideFinder) Close() {
+	// do nothing
+}
@@ -0,0 +1,220 @@
+package discover
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/network/v2"
+)
+
+// AdvertiseAddressEnv is the env of the address advertised to the other services,
+// such as the host ip of the container.
+const AdvertiseAddressEnv = "VENKIT_ADVERTISE_ADDR"
+
+// EndpointMetaKey is the meta key of the endpoint name set by WithEndpoint.
+const EndpointMetaKey = "endpoint"
+
+type CheckType int
+
+const (
+	// CheckTCP checks whether the port can be connected, it is the default.
+	CheckTCP CheckType = iota
+	// CheckHTTP checks whether the http path responds 2xx.
+	CheckHTTP
+	// CheckGRPC checks the grpc health service.
+	CheckGRPC
+	// CheckTTL expects the service to report that it is alive within the ttl.
+	CheckTTL
+	// CheckNone registers the service without check.
+	CheckNone
+)
+
+// RegisterOptions is the options of registering a service.
+type RegisterOptions struct {
+	// Address is the host advertised to the other services.
+	Address string
+	// Interface is the network interface whose ip is advertised if Address is not set.
+	Interface string
+
+	Check     CheckType
+	CheckPath string
+	Interval  time.Duration
+	Timeout   time.Duration
+	TTL       time.Duration
+	// DeregisterAfter removes the service after the check keeps critical for so long.
+	DeregisterAfter time.Duration
+
+	Meta   map[string]string
+	Weight int
+
+	// Endpoint names the endpoint when a process registers several ones, such as public and admin.
+	Endpoint string
+	// ServiceID overrides the generated service id.
+	ServiceID string
+}
+
+type RegisterOption func(o *RegisterOptions)
+
+func NewRegisterOptions(opts ...RegisterOption) *RegisterOptions {
+	o := &RegisterOptions{
+		Check:           CheckTCP,
+		CheckPath:       "/healthz",
+		Interval:        10 * time.Second,
+		Timeout:         5 * time.Second,
+		TTL:             15 * time.Second,
+		DeregisterAfter: 10 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithAdvertiseAddress sets the host advertised to the other services.
+func WithAdvertiseAddress(host string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Address = host
+	}
+}
+
+// WithAdvertiseInterface advertises the ip of the network interface, e.g. eth0.
+func WithAdvertiseInterface(name string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Interface = name
+	}
+}
+
+// WithHTTPCheck checks the http path of the service, e.g. /healthz
+func WithHTTPCheck(path string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Check = CheckHTTP
+		o.CheckPath = path
+	}
+}
+
+// WithGRPCCheck checks the grpc health service of the service.
+func WithGRPCCheck() RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Check = CheckGRPC
+	}
+}
+
+// WithTTLCheck expects the service to report alive within the ttl, which is done by a background goroutine.
+func WithTTLCheck(ttl time.Duration) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Check = CheckTTL
+		o.TTL = ttl
+	}
+}
+
+// WithoutCheck registers the service without check.
+func WithoutCheck() RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Check = CheckNone
+	}
+}
+
+func WithCheckInterval(interval time.Duration) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Interval = interval
+	}
+}
+
+func WithCheckTimeout(timeout time.Duration) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Timeout = timeout
+	}
+}
+
+func WithDeregisterAfter(d time.Duration) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.DeregisterAfter = d
+	}
+}
+
+func WithMeta(key, value string) RegisterOption {
+	return func(o *RegisterOptions) {
+		if o.Meta == nil {
+			o.Meta = make(map[string]string)
+		}
+		o.Meta[key] = value
+	}
+}
+
+// WithWeight sets the weight of the service used by the Weighted balancer.
+func WithWeight(weight int) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Weight = weight
+	}
+}
+
+// WithEndpoint names the endpoint, so that a process can register several endpoints of
+// the same service on different ports and tags. The name is kept in the meta of the service.
+func WithEndpoint(name string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.Endpoint = name
+	}
+}
+
+// WithServiceID sets the id of the service instead of the generated one, it must be unique in the agent.
+func WithServiceID(id string) RegisterOption {
+	return func(o *RegisterOptions) {
+		o.ServiceID = id
+	}
+}
+
+// advertiseHost returns the host advertised to the other services, which is the first one of
+// the explicit address, the ip of the interface, the env and the listening host.
+// It returns empty if the service listens on all interfaces.
+func (o *RegisterOptions) advertiseHost(listenHost string) (string, error) {
+	switch {
+	case o.Address != "":
+		return o.Address, nil
+	case o.Interface != "":
+		ip, err := network.GetLocalIP(o.Interface)
+		if err != nil {
+			return "", errors.Wrapf(err, "get ip of interface %s", o.Interface)
+		}
+		return ip, nil
+	case os.Getenv(AdvertiseAddressEnv) != "":
+		return os.Getenv(AdvertiseAddressEnv), nil
+	}
+
+	if ip := net.ParseIP(listenHost); listenHost == "" || (ip != nil && ip.IsUnspecified()) {
+		return "", nil
+	}
+	return listenHost, nil
+}
+
+// check returns the consul check of the service, the host is the address to check.
+func (o *RegisterOptions) check(checkID, name, host string, port int) *api.AgentServiceCheck {
+	check := &api.AgentServiceCheck{
+		CheckID:                        checkID,
+		Name:                           name,
+		Status:                         api.HealthPassing,
+		DeregisterCriticalServiceAfter: o.DeregisterAfter.String(),
+	}
+
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	switch o.Check {
+	case CheckNone:
+		return nil
+	case CheckTTL:
+		check.TTL = o.TTL.String()
+		return check
+	case CheckHTTP:
+		check.HTTP = fmt.Sprintf("http://%s%s", target, o.CheckPath)
+	case CheckGRPC:
+		check.GRPC = target
+	default:
+		check.TCP = target
+	}
+	check.Interval = o.Interval.String()
+	check.Timeout = o.Timeout.String()
+	return check
+}
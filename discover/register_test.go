@@ -0,0 +1,162 @@
+package discover
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterOptionsCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []RegisterOption
+		want *api.AgentServiceCheck
+	}{
+		{
+			name: "tcp",
+			want: &api.AgentServiceCheck{TCP: "10.0.0.1:8080", Interval: "10s", Timeout: "5s"},
+		},
+		{
+			name: "http",
+			opts: []RegisterOption{WithHTTPCheck("/health"), WithCheckInterval(time.Second)},
+			want: &api.AgentServiceCheck{HTTP: "http://10.0.0.1:8080/health", Interval: "1s", Timeout: "5s"},
+		},
+		{
+			name: "grpc",
+			opts: []RegisterOption{WithGRPCCheck(), WithCheckTimeout(time.Second)},
+			want: &api.AgentServiceCheck{GRPC: "10.0.0.1:8080", Interval: "10s", Timeout: "1s"},
+		},
+		{
+			name: "ttl",
+			opts: []RegisterOption{WithTTLCheck(30 * time.Second)},
+			want: &api.AgentServiceCheck{TTL: "30s"},
+		},
+		{
+			name: "none",
+			opts: []RegisterOption{WithoutCheck()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewRegisterOptions(tt.opts...).check("service:app", "app", "10.0.0.1", 8080)
+			if tt.want == nil {
+				assert.Nil(t, got)
+				return
+			}
+			tt.want.CheckID = "service:app"
+			tt.want.Name = "app"
+			tt.want.Status = api.HealthPassing
+			tt.want.DeregisterCriticalServiceAfter = "10m0s"
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAdvertiseHost(t *testing.T) {
+	host, err := NewRegisterOptions().advertiseHost("0.0.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, "", host)
+
+	host, err = NewRegisterOptions().advertiseHost("10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", host)
+
+	t.Setenv(AdvertiseAddressEnv, "10.0.0.3")
+	host, err = NewRegisterOptions().advertiseHost("::")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3", host)
+
+	host, err = NewRegisterOptions(WithAdvertiseAddress("10.0.0.4")).advertiseHost("::")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.4", host)
+
+	_, err = NewRegisterOptions(WithAdvertiseInterface("not-exists0")).advertiseHost("::")
+	assert.NotNil(t, err)
+}
+
+func TestClientRegisterWithOptions(t *testing.T) {
+	srv, c := newTestClient(t)
+
+	err := c.RegisterServiceWithOptions("app", "[::]:8080", []string{"dev"},
+		WithAdvertiseAddress("10.0.0.1"),
+		WithTTLCheck(time.Second),
+		WithMeta("version", "v1"),
+		WithWeight(3),
+	)
+	assert.Nil(t, err)
+	time.Sleep(1200 * time.Millisecond)
+
+	services := srv.Services()
+	if !assert.Len(t, services, 1) {
+		return
+	}
+	svc := services[0]
+	assert.Equal(t, "10.0.0.1", svc.Address)
+	assert.Equal(t, 8080, svc.Port)
+	assert.Equal(t, map[string]string{"version": "v1"}, svc.Meta)
+	assert.Equal(t, api.AgentWeights{Passing: 3, Warning: 1}, svc.Weights)
+
+	checks := srv.Checks(svc.ID)
+	if !assert.Len(t, checks, 1) {
+		return
+	}
+	assert.Equal(t, "ttl", checks[0].Type)
+	assert.Equal(t, api.HealthPassing, checks[0].Status)
+	assert.GreaterOrEqual(t, srv.CheckUpdates(checks[0].CheckID), 1)
+
+	assert.NotNil(t, c.RegisterServiceWithOptions("app_1", "[::]:8080", nil))
+}
+
+func TestClientReregister(t *testing.T) {
+	srv, c := newTestClient(t, func(conf *ConsulConfig) {
+		conf.RegistrationCheckInterval = 50 * time.Millisecond
+	})
+	publicID, err := c.Register("app", "127.0.0.1:8080", []string{"public"}, WithoutCheck())
+	assert.Nil(t, err)
+	adminID, err := c.Register("app", "127.0.0.1:9090", []string{"admin"}, WithoutCheck(), WithEndpoint("admin"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, publicID, adminID)
+	assert.Len(t, c.Registrations(), 2)
+
+	services := srv.Services()
+	if !assert.Len(t, services, 2) {
+		return
+	}
+	for _, svc := range services {
+		if svc.ID == adminID {
+			assert.Equal(t, 9090, svc.Port)
+			assert.Equal(t, "admin", svc.Meta[EndpointMetaKey])
+		}
+	}
+
+	// the registration lost in consul is restored
+	srv.RemoveService(publicID)
+	assert.Eventually(t, func() bool { return len(srv.Services()) == 2 }, time.Second, 10*time.Millisecond)
+
+	// the deregistered one is not
+	assert.Nil(t, c.Deregister(adminID))
+	time.Sleep(150 * time.Millisecond)
+	services = srv.Services()
+	if assert.Len(t, services, 1) {
+		assert.Equal(t, publicID, services[0].ID)
+	}
+	assert.Equal(t, []RegisteredService{{ServiceID: publicID, Name: "app"}}, c.Registrations())
+	assert.NotNil(t, c.Deregister(adminID))
+
+	_, err = c.Register("app", "127.0.0.1:9090", nil, WithEndpoint("admin.v1"))
+	assert.NotNil(t, err)
+}
+
+func TestClientCloseNotReregister(t *testing.T) {
+	srv, c := newTestClient(t, func(conf *ConsulConfig) {
+		conf.RegistrationCheckInterval = 10 * time.Millisecond
+	})
+
+	_, err := c.Register("app", "127.0.0.1:8080", nil, WithoutCheck())
+	assert.Nil(t, err)
+	c.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, srv.Services(), 0)
+}
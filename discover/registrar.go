@@ -0,0 +1,262 @@
+package discover
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+// DefaultRegistrationCheckInterval is how often the registrations are verified in consul by default.
+const DefaultRegistrationCheckInterval = 30 * time.Second
+
+type RegisteredService struct {
+	ServiceID string
+	CheckID   string
+	Name      string
+	// Endpoint is the name of the endpoint set by WithEndpoint.
+	Endpoint string
+}
+
+type registration struct {
+	regis *api.AgentServiceRegistration
+	ttl   time.Duration
+	// stop stops the heartbeat of the ttl check
+	stop context.CancelFunc
+}
+
+func (r *registration) info() RegisteredService {
+	rs := RegisteredService{
+		ServiceID: r.regis.ID,
+		Name:      r.regis.Name,
+		Endpoint:  r.regis.Meta[EndpointMetaKey],
+	}
+	if r.regis.Check != nil {
+		rs.CheckID = r.regis.Check.CheckID
+	}
+	return rs
+}
+
+// registrar keeps the services registered until they are deregistered. The registrations
+// are verified periodically and restored if the consul agent loses them, such as it restarts.
+type registrar struct {
+	agent  *api.Agent
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	checkInterval time.Duration
+	mu            sync.Mutex
+	registrations map[string]*registration
+	started       bool
+	verifyCh      chan struct{}
+	// done is closed when run returns
+	done chan struct{}
+}
+
+func newRegistrar(client *api.Client, checkInterval time.Duration) *registrar {
+	if checkInterval <= 0 {
+		checkInterval = DefaultRegistrationCheckInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &registrar{
+		agent:         client.Agent(),
+		ctx:           ctx,
+		cancel:        cancel,
+		checkInterval: checkInterval,
+		registrations: make(map[string]*registration),
+		verifyCh:      make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// register registers the service, and replaces the registration with the same id.
+func (r *registrar) register(regis *api.AgentServiceRegistration, ttl time.Duration) error {
+	if err := r.agent.ServiceRegister(regis); err != nil {
+		return err
+	}
+
+	reg := &registration{regis: regis, ttl: ttl}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.registrations[regis.ID]; ok && old.stop != nil {
+		old.stop()
+	}
+	r.registrations[regis.ID] = reg
+	if ttl > 0 && regis.Check != nil {
+		ctx, stop := context.WithCancel(r.ctx)
+		reg.stop = stop
+		go r.heartbeat(ctx, regis.Check.CheckID, ttl)
+	}
+	if !r.started {
+		r.started = true
+		go r.run()
+	}
+	return nil
+}
+
+// deregister removes the service from consul, and it is no longer restored.
+func (r *registrar) deregister(id string) error {
+	r.mu.Lock()
+	reg, ok := r.registrations[id]
+	delete(r.registrations, id)
+	r.mu.Unlock()
+	if !ok {
+		return errors.Errorf("service %s is not registered by this client", id)
+	}
+
+	if reg.stop != nil {
+		reg.stop()
+	}
+	return r.deregisterServiceAndCheck(reg.info())
+}
+
+func (r *registrar) deregisterServiceAndCheck(rs RegisteredService) (reterr error) {
+	if rs.CheckID != "" {
+		if err := r.agent.CheckDeregister(rs.CheckID); err != nil {
+			reterr = errors.Wrap(err, "Deregister check")
+		}
+	}
+
+	if err := r.agent.ServiceDeregister(rs.ServiceID); err != nil {
+		reterr = errors.Wrap(err, "Deregister service")
+	}
+	return
+}
+
+// list returns the registered services ordered by id.
+func (r *registrar) list() []RegisteredService {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make([]RegisteredService, 0, len(r.registrations))
+	for _, reg := range r.registrations {
+		ret = append(ret, reg.info())
+	}
+	slices.SortFunc(ret, func(a, b RegisteredService) int { return strings.Compare(a.ServiceID, b.ServiceID) })
+	return ret
+}
+
+func (r *registrar) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.verifyCh:
+		}
+		r.verify()
+	}
+}
+
+// triggerVerify verifies the registrations at once, such as the ttl check is not found.
+func (r *registrar) triggerVerify() {
+	select {
+	case r.verifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// verify re-registers the services which are lost in consul.
+func (r *registrar) verify() {
+	services, err := r.agent.Services()
+	if err != nil {
+		lg.Warnc(r.ctx, "Verify registrations in consul error: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	var lost []*registration
+	for id, reg := range r.registrations {
+		if _, ok := services[id]; !ok {
+			lost = append(lost, reg)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, reg := range lost {
+		r.reregister(reg)
+	}
+}
+
+// reregister registers the lost service again without holding the lock during the call to consul.
+// It is undone if the service is deregistered concurrently, so that it is not restored after that.
+func (r *registrar) reregister(reg *registration) {
+	if !r.current(reg) {
+		return
+	}
+	if err := r.agent.ServiceRegister(reg.regis); err != nil {
+		lg.Errorc(r.ctx, "Re-register %s into consul error: %v", reg.regis.ID, err)
+		return
+	}
+
+	r.mu.Lock()
+	latest, ok := r.registrations[reg.regis.ID]
+	r.mu.Unlock()
+	switch {
+	case !ok:
+		if err := r.deregisterServiceAndCheck(reg.info()); err != nil {
+			lg.Errorc(r.ctx, "Deregister %s restored concurrently error: %v", reg.regis.ID, err)
+		}
+	case latest != reg:
+		// replaced concurrently, which may be overwritten by the stale registration
+		r.reregister(latest)
+	default:
+		lg.Warnc(r.ctx, "Service %s was lost in consul, re-registered.", reg.regis.ID)
+	}
+}
+
+// current reports whether reg is still the registration of its id and the registrar is not closed.
+func (r *registrar) current(reg *registration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ctx.Err() == nil && r.registrations[reg.regis.ID] == reg
+}
+
+// heartbeat reports the ttl check is passing until ctx is done.
+func (r *registrar) heartbeat(ctx context.Context, checkID string, ttl time.Duration) {
+	ticker := time.NewTicker(max(ttl/3, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.agent.UpdateTTL(checkID, "", api.HealthPassing); err != nil {
+				lg.Warnc(ctx, "Update ttl of %s error: %v", checkID, err)
+				r.triggerVerify()
+			}
+		}
+	}
+}
+
+// close stops verifying and deregisters all services.
+func (r *registrar) close() {
+	r.cancel()
+	// wait for the running verification, otherwise it may restore the services deregistered below
+	r.mu.Lock()
+	started := r.started
+	r.mu.Unlock()
+	if started {
+		<-r.done
+	}
+	for _, rs := range r.list() {
+		if err := r.deregisterServiceAndCheck(rs); err != nil {
+			lg.Error("Deregister", rs.ServiceID, err)
+		} else {
+			lg.Info("Deregistered", rs.ServiceID)
+		}
+	}
+
+	r.mu.Lock()
+	r.registrations = make(map[string]*registration)
+	r.mu.Unlock()
+}
@@ -0,0 +1,118 @@
+package discover
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+	"google.golang.org/grpc/resolver"
+)
+
+// GrpcScheme is the scheme of the grpc target resolved by the default ServiceFinder.
+const GrpcScheme = "venkit"
+
+func init() {
+	resolver.Register(&grpcResolverBuilder{})
+}
+
+// GrpcTarget returns the grpc target of the service, e.g. venkit:///service:tag
+func GrpcTarget(service, tag string) string {
+	if tag == "" {
+		return fmt.Sprintf("%s:///%s", GrpcScheme, service)
+	}
+	return fmt.Sprintf("%s:///%s:%s", GrpcScheme, service, tag)
+}
+
+// parseGrpcTarget parses the service and tag of venkit:///service:tag, and also accepts venkit://service
+func parseGrpcTarget(target resolver.Target) (service, tag string) {
+	endpoint := target.Endpoint()
+	if endpoint == "" {
+		endpoint = target.URL.Host
+	}
+	service, tag, _ = strings.Cut(endpoint, ":")
+	return service, tag
+}
+
+type unwatcher interface {
+	Unwatch(ch <-chan []string)
+}
+
+type grpcResolverBuilder struct{}
+
+func (b *grpcResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	service, tag := parseGrpcTarget(target)
+	if service == "" {
+		return nil, errors.Errorf("invalid target: %v", target.URL.String())
+	}
+
+	finder := GetServiceFinder()
+	r := &grpcResolver{
+		service: service,
+		tag:     tag,
+		finder:  finder,
+		cc:      cc,
+		watch:   finder.Watch(service, tag),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (b *grpcResolverBuilder) Scheme() string {
+	return GrpcScheme
+}
+
+// grpcResolver pushes the addresses of the service to grpc every time they change.
+type grpcResolver struct {
+	service string
+	tag     string
+	finder  ServiceFinder
+	cc      resolver.ClientConn
+	watch   <-chan []string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (r *grpcResolver) run() {
+	for {
+		select {
+		case <-r.done:
+			return
+		case addrs, ok := <-r.watch:
+			if !ok {
+				return
+			}
+			r.update(addrs)
+		}
+	}
+}
+
+func (r *grpcResolver) update(addrs []string) {
+	if len(addrs) == 0 {
+		r.cc.ReportError(errors.Errorf("no address of %s:%s found", r.service, r.tag))
+		return
+	}
+
+	state := resolver.State{Addresses: make([]resolver.Address, 0, len(addrs))}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	if err := r.cc.UpdateState(state); err != nil {
+		lg.Warnf("Update grpc resolver state of %s:%s error: %v", r.service, r.tag, err)
+	}
+}
+
+// ResolveNow does nothing, since the addresses are pushed by the finder.
+func (r *grpcResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *grpcResolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		if u, ok := r.finder.(unwatcher); ok {
+			u.Unwatch(r.watch)
+		}
+	})
+}
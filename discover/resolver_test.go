@@ -0,0 +1,105 @@
+package discover
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+)
+
+func startHealthServer(t *testing.T, calls *int32) int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		atomic.AddInt32(calls, 1)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestGrpcResolver(t *testing.T) {
+	var calls1, calls2 int32
+	port1 := startHealthServer(t, &calls1)
+	port2 := startHealthServer(t, &calls2)
+
+	consul, c := newTestClient(t)
+	consul.AddService("app-1", "app", "", port1, "dev")
+	consul.AddService("app-2", "app", "", port2, "dev")
+
+	old := GetServiceFinder()
+	SetServiceFinder(c)
+	defer SetServiceFinder(old)
+
+	conn, err := grpc.NewClient(
+		GrpcTarget("app", "dev"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig":[{"round_robin":{}}]}`),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 10; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, atomic.LoadInt32(&calls1), int32(0))
+	assert.Greater(t, atomic.LoadInt32(&calls2), int32(0))
+
+	// the removed address no longer receives calls
+	consul.RemoveService("app-1")
+	time.Sleep(200 * time.Millisecond)
+	before := atomic.LoadInt32(&calls1)
+	for i := 0; i < 10; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, before, atomic.LoadInt32(&calls1))
+}
+
+func TestParseGrpcTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		service string
+		tag     string
+	}{
+		{GrpcTarget("app", "dev"), "app", "dev"},
+		{GrpcTarget("app", ""), "app", ""},
+		{"venkit://app", "app", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			target, err := parseTarget(tt.target)
+			assert.Nil(t, err)
+			service, tag := parseGrpcTarget(target)
+			assert.Equal(t, tt.service, service)
+			assert.Equal(t, tt.tag, tag)
+		})
+	}
+}
+
+func parseTarget(raw string) (resolver.Target, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return resolver.Target{}, err
+	}
+	return resolver.Target{URL: *u}, nil
+}
@@ -0,0 +1,93 @@
+package discover
+
+import (
+	"sync"
+)
+
+// watchers keeps the Watch channels of the finders which resolve the addresses by themselves.
+type watchers struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]*watcher
+	closed bool
+}
+
+type watcher struct {
+	service string
+	tag     string
+	ch      chan []string
+	last    []string
+}
+
+func (w *watchers) add(service, tag string, addrs []string) <-chan []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan []string, 1)
+	if w.closed {
+		close(ch)
+		return ch
+	}
+	if w.subs == nil {
+		w.subs = make(map[int]*watcher)
+	}
+	w.subs[w.nextID] = &watcher{service: service, tag: tag, ch: ch, last: addrs}
+	w.nextID++
+	ch <- append([]string(nil), addrs...)
+	return ch
+}
+
+// notify resolves the addresses of every watcher, and sends them if they change.
+// The addresses are resolved without holding the lock, since it may be slow such as DNS.
+func (w *watchers) notify(resolve func(service, tag string) []string) {
+	type key struct{ service, tag string }
+
+	w.mu.Lock()
+	resolved := make(map[key][]string)
+	for _, sub := range w.subs {
+		resolved[key{sub.service, sub.tag}] = nil
+	}
+	w.mu.Unlock()
+
+	for k := range resolved {
+		resolved[k] = resolve(k.service, k.tag)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, sub := range w.subs {
+		addrs, ok := resolved[key{sub.service, sub.tag}]
+		// nil means the addresses are unknown for now
+		if !ok || addrs == nil || equalAddresses(sub.last, addrs) {
+			continue
+		}
+		sub.last = addrs
+		// only keep the latest addresses for slow subscribers
+		select {
+		case <-sub.ch:
+		default:
+		}
+		sub.ch <- append([]string(nil), addrs...)
+	}
+}
+
+func (w *watchers) remove(ch <-chan []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, sub := range w.subs {
+		if sub.ch == ch {
+			close(sub.ch)
+			delete(w.subs, id)
+		}
+	}
+}
+
+func (w *watchers) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	for id, sub := range w.subs {
+		close(sub.ch)
+		delete(w.subs, id)
+	}
+}
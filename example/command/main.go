@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/vflags"
+)
+
+type ServeConf struct {
+	Addr string `desc:"listen addr"`
+}
+
+var (
+	serveCmd  = vflags.NewCommand("serve", "Start the admin server")
+	serveConf = serveCmd.Struct("serveConf", &ServeConf{Addr: ":8080"}, "serve config")
+
+	userCmd = vflags.NewCommand("user", "Show the user info")
+	name    = userCmd.StringRequired("name", "user name")
+)
+
+func serve(ctx context.Context) error {
+	conf := &ServeConf{}
+	lg.PanicError(serveConf(conf))
+	fmt.Println("serve on", conf.Addr)
+
+	<-ctx.Done()
+	return nil
+}
+
+func user(ctx context.Context) error {
+	fmt.Println("user", name(), userCmd.Args())
+	return nil
+}
+
+// go run main.go help serve
+// go run main.go --debug user --name=venkit
+func main() {
+	serveCmd.Run = serve
+	userCmd.Run = user
+	vflags.Execute()
+}
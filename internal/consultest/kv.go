@@ -0,0 +1,113 @@
+package consultest
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Put sets the value of the key, as it is put by the KV api.
+func (s *Server) Put(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value)
+}
+
+// Delete removes the key.
+func (s *Server) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(key)
+}
+
+// put sets the value of the key, it must be called with the lock held.
+func (s *Server) put(key string, value []byte) {
+	idx := s.bump()
+	pair, ok := s.kv[key]
+	if !ok {
+		pair = &api.KVPair{Key: key, CreateIndex: idx}
+		s.kv[key] = pair
+	}
+	pair.Value = append([]byte(nil), value...)
+	pair.ModifyIndex = idx
+	s.kvIndex[key] = idx
+}
+
+// delete removes the key, it must be called with the lock held.
+func (s *Server) delete(key string) {
+	if _, ok := s.kv[key]; !ok {
+		return
+	}
+	delete(s.kv, key)
+	s.kvIndex[key] = s.bump()
+}
+
+// prefixIndex returns the max index of the keys with the prefix, it must be called with the lock held.
+func (s *Server) prefixIndex(prefix string) uint64 {
+	var idx uint64
+	for key, i := range s.kvIndex {
+		if strings.HasPrefix(key, prefix) {
+			idx = max(idx, i)
+		}
+	}
+	return idx
+}
+
+func (s *Server) handleKVGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	_, recurse := r.URL.Query()["recurse"]
+	idx, ok := s.block(r, func() uint64 {
+		if recurse {
+			return s.prefixIndex(key)
+		}
+		return s.kvIndex[key]
+	})
+	defer s.mu.Unlock()
+	if !ok {
+		http.Error(w, "consultest: failing", http.StatusInternalServerError)
+		return
+	}
+
+	var pairs api.KVPairs
+	if recurse {
+		for k, pair := range s.kv {
+			if strings.HasPrefix(k, key) {
+				p := *pair
+				pairs = append(pairs, &p)
+			}
+		}
+		slices.SortFunc(pairs, func(a, b *api.KVPair) int { return strings.Compare(a.Key, b.Key) })
+	} else if pair, ok := s.kv[key]; ok {
+		p := *pair
+		pairs = append(pairs, &p)
+	}
+
+	if len(pairs) == 0 {
+		w.Header().Set("X-Consul-Index", formatIndex(idx))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, idx, pairs)
+}
+
+func (s *Server) handleKVPut(w http.ResponseWriter, r *http.Request) {
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(r.PathValue("key"), value)
+	writeJSON(w, 0, true)
+}
+
+func (s *Server) handleKVDelete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(r.PathValue("key"))
+	writeJSON(w, 0, true)
+}
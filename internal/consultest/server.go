@@ -0,0 +1,523 @@
+// Package consultest runs a fake consul http api in process, which is enough for
+// the service discovery and the remote config to be tested without a real consul.
+//
+// It serves the agent service and check api, the catalog, the health service and
+// the KV, and the health service and KV support blocking queries.
+package consultest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	// NodeName is the name of the node which all services are registered on.
+	NodeName = "consultest"
+	// NodeAddress is the address of the node, which is used if the service has no address.
+	NodeAddress = "127.0.0.1"
+
+	maxWaitTime = 5 * time.Minute
+)
+
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// index is the raft index, which increases on every write
+	index   uint64
+	changed chan struct{}
+	failing bool
+
+	services     map[string]*api.AgentService
+	checks       map[string]*api.HealthCheck
+	checkUpdates map[string]int
+	serviceIndex map[string]uint64
+
+	kv      map[string]*api.KVPair
+	kvIndex map[string]uint64
+}
+
+// NewServer starts the fake consul, the caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		index:        1,
+		changed:      make(chan struct{}),
+		services:     make(map[string]*api.AgentService),
+		checks:       make(map[string]*api.HealthCheck),
+		checkUpdates: make(map[string]int),
+		serviceIndex: make(map[string]uint64),
+		kv:           make(map[string]*api.KVPair),
+		kvIndex:      make(map[string]uint64),
+	}
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// Addr returns the host:port of the fake consul, which is used as the consul address.
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+func (s *Server) Close() {
+	// wake up the blocking queries, or Close waits for them
+	s.SetFailing(true)
+	s.Server.Close()
+}
+
+// SetFailing makes all requests respond 500 until it is set back, which simulates that consul is unavailable.
+func (s *Server) SetFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+	s.notify()
+}
+
+// notify wakes up the blocking queries, it must be called with the lock held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// bump increases the raft index, it must be called with the lock held.
+func (s *Server) bump() uint64 {
+	s.index++
+	s.notify()
+	return s.index
+}
+
+// AddService registers a service with a passing check, as it is registered by the agent api.
+func (s *Server) AddService(id, name, address string, port int, tags ...string) {
+	s.register(&api.AgentServiceRegistration{
+		ID:      id,
+		Name:    name,
+		Address: address,
+		Port:    port,
+		Tags:    tags,
+		Check:   &api.AgentServiceCheck{TTL: "1m", Status: api.HealthPassing},
+	})
+}
+
+// RemoveService deregisters the service and its checks.
+func (s *Server) RemoveService(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deregister(id)
+}
+
+// SetCheckStatus sets the status of the check, e.g. api.HealthCritical.
+func (s *Server) SetCheckStatus(checkID, status string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updateCheck(checkID, status, "")
+}
+
+// Services returns a copy of the registered services.
+func (s *Server) Services() []*api.AgentService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]*api.AgentService, 0, len(s.services))
+	for _, svc := range s.services {
+		c := *svc
+		ret = append(ret, &c)
+	}
+	slices.SortFunc(ret, func(a, b *api.AgentService) int { return strings.Compare(a.ID, b.ID) })
+	return ret
+}
+
+// Checks returns a copy of the checks of the service.
+func (s *Server) Checks(serviceID string) []*api.HealthCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []*api.HealthCheck
+	for _, check := range s.checks {
+		if check.ServiceID == serviceID {
+			c := *check
+			ret = append(ret, &c)
+		}
+	}
+	slices.SortFunc(ret, func(a, b *api.HealthCheck) int { return strings.Compare(a.CheckID, b.CheckID) })
+	return ret
+}
+
+// CheckUpdates returns how many times the check is updated by the agent api, such as the ttl heartbeats.
+func (s *Server) CheckUpdates(checkID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkUpdates[checkID]
+}
+
+func (s *Server) register(regis *api.AgentServiceRegistration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := regis.ID
+	if id == "" {
+		id = regis.Name
+	}
+	if old, ok := s.services[id]; ok && old.Service != regis.Name {
+		s.deregister(id)
+	}
+
+	weights := api.AgentWeights{Passing: 1, Warning: 1}
+	if regis.Weights != nil {
+		weights = *regis.Weights
+	}
+	s.services[id] = &api.AgentService{
+		ID:      id,
+		Service: regis.Name,
+		Address: regis.Address,
+		Port:    regis.Port,
+		Tags:    append([]string(nil), regis.Tags...),
+		Meta:    regis.Meta,
+		Weights: weights,
+	}
+
+	checks := regis.Checks
+	if regis.Check != nil {
+		checks = append(api.AgentServiceChecks{regis.Check}, checks...)
+	}
+	for i, check := range checks {
+		checkID := check.CheckID
+		if checkID == "" {
+			checkID = "service:" + id
+			if len(checks) > 1 {
+				checkID += ":" + strconv.Itoa(i+1)
+			}
+		}
+		status := check.Status
+		if status == "" {
+			status = api.HealthCritical
+		}
+		s.checks[checkID] = &api.HealthCheck{
+			Node:        NodeName,
+			CheckID:     checkID,
+			Name:        check.Name,
+			Status:      status,
+			ServiceID:   id,
+			ServiceName: regis.Name,
+			Type:        checkType(check),
+		}
+	}
+	s.serviceIndex[regis.Name] = s.bump()
+}
+
+func checkType(check *api.AgentServiceCheck) string {
+	switch {
+	case check.TTL != "":
+		return "ttl"
+	case check.HTTP != "":
+		return "http"
+	case check.GRPC != "":
+		return "grpc"
+	case check.TCP != "":
+		return "tcp"
+	}
+	return ""
+}
+
+// deregister removes the service and its checks, it must be called with the lock held.
+func (s *Server) deregister(id string) bool {
+	svc, ok := s.services[id]
+	if !ok {
+		return false
+	}
+	delete(s.services, id)
+	for checkID, check := range s.checks {
+		if check.ServiceID == id {
+			delete(s.checks, checkID)
+		}
+	}
+	s.serviceIndex[svc.Service] = s.bump()
+	return true
+}
+
+// updateCheck sets the status of the check, it must be called with the lock held.
+func (s *Server) updateCheck(checkID, status, output string) bool {
+	check, ok := s.checks[checkID]
+	if !ok {
+		return false
+	}
+	s.checkUpdates[checkID]++
+	if check.Status == status && check.Output == output {
+		return true
+	}
+	check.Status = status
+	check.Output = output
+	if check.ServiceName != "" {
+		s.serviceIndex[check.ServiceName] = s.bump()
+	}
+	return true
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /v1/agent/service/register", s.handleRegister)
+	mux.HandleFunc("PUT /v1/agent/service/deregister/{id}", s.handleDeregister)
+	mux.HandleFunc("GET /v1/agent/services", s.handleAgentServices)
+	mux.HandleFunc("GET /v1/agent/service/{id}", s.handleAgentService)
+	mux.HandleFunc("GET /v1/agent/checks", s.handleAgentChecks)
+	mux.HandleFunc("PUT /v1/agent/check/deregister/{id}", s.handleCheckDeregister)
+	mux.HandleFunc("PUT /v1/agent/check/update/{id}", s.handleCheckUpdate)
+	mux.HandleFunc("PUT /v1/agent/check/pass/{id}", s.handleCheckStatus(api.HealthPassing))
+	mux.HandleFunc("PUT /v1/agent/check/warn/{id}", s.handleCheckStatus(api.HealthWarning))
+	mux.HandleFunc("PUT /v1/agent/check/fail/{id}", s.handleCheckStatus(api.HealthCritical))
+	mux.HandleFunc("GET /v1/catalog/services", s.handleCatalogServices)
+	mux.HandleFunc("GET /v1/catalog/service/{name}", s.handleCatalogService)
+	mux.HandleFunc("GET /v1/health/service/{name}", s.handleHealthService)
+	mux.HandleFunc("GET /v1/kv/{key...}", s.handleKVGet)
+	mux.HandleFunc("PUT /v1/kv/{key...}", s.handleKVPut)
+	mux.HandleFunc("DELETE /v1/kv/{key...}", s.handleKVDelete)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		failing := s.failing
+		s.mu.Unlock()
+		if failing {
+			http.Error(w, "consultest: failing", http.StatusInternalServerError)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// block waits until the index of the resource differs from the index of the query,
+// or the wait time passes. It returns with the lock held, and false if the server
+// begins failing or the request is canceled.
+func (s *Server) block(r *http.Request, index func() uint64) (uint64, bool) {
+	query := r.URL.Query()
+	waitIndex, _ := strconv.ParseUint(query.Get("index"), 10, 64)
+	waitTime := maxWaitTime
+	if d, err := time.ParseDuration(query.Get("wait")); err == nil && d > 0 && d < maxWaitTime {
+		waitTime = d
+	}
+	timer := time.NewTimer(waitTime)
+	defer timer.Stop()
+
+	s.mu.Lock()
+	for {
+		if s.failing {
+			return 0, false
+		}
+		idx := max(index(), 1)
+		if waitIndex == 0 || idx != waitIndex {
+			return idx, true
+		}
+
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			s.mu.Lock()
+			return max(index(), 1), !s.failing
+		case <-r.Context().Done():
+			s.mu.Lock()
+			return 0, false
+		}
+		s.mu.Lock()
+	}
+}
+
+func formatIndex(index uint64) string {
+	return strconv.FormatUint(index, 10)
+}
+
+func writeJSON(w http.ResponseWriter, index uint64, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if index > 0 {
+		w.Header().Set("X-Consul-Index", formatIndex(index))
+		w.Header().Set("X-Consul-KnownLeader", "true")
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	regis := &api.AgentServiceRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(regis); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if regis.Name == "" {
+		http.Error(w, "Missing service name", http.StatusBadRequest)
+		return
+	}
+	s.register(regis)
+}
+
+func (s *Server) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.deregister(r.PathValue("id")) {
+		http.Error(w, "Unknown service ID", http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleAgentServices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, 0, s.services)
+}
+
+func (s *Server) handleAgentService(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc, ok := s.services[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "unknown service ID", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, 0, svc)
+}
+
+func (s *Server) handleAgentChecks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, 0, s.checks)
+}
+
+func (s *Server) handleCheckDeregister(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	check, ok := s.checks[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "Unknown check ID", http.StatusNotFound)
+		return
+	}
+	delete(s.checks, check.CheckID)
+	if check.ServiceName != "" {
+		s.serviceIndex[check.ServiceName] = s.bump()
+	}
+}
+
+func (s *Server) handleCheckUpdate(w http.ResponseWriter, r *http.Request) {
+	update := &struct {
+		Status string
+		Output string
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.updateCheck(r.PathValue("id"), update.Status, update.Output) {
+		http.Error(w, "Unknown check ID", http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleCheckStatus(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.updateCheck(r.PathValue("id"), status, r.URL.Query().Get("note")) {
+			http.Error(w, "Unknown check ID", http.StatusNotFound)
+		}
+	}
+}
+
+func (s *Server) handleCatalogServices(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make(map[string][]string)
+	for _, svc := range s.services {
+		tags := ret[svc.Service]
+		if tags == nil {
+			tags = []string{}
+		}
+		for _, tag := range svc.Tags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		ret[svc.Service] = tags
+	}
+	writeJSON(w, s.index, ret)
+}
+
+// matchServices returns the services with the name and all the tags, it must be called with the lock held.
+func (s *Server) matchServices(name string, tags []string) []*api.AgentService {
+	var ret []*api.AgentService
+	for _, svc := range s.services {
+		if svc.Service != name {
+			continue
+		}
+		matched := true
+		for _, tag := range tags {
+			if !slices.Contains(svc.Tags, tag) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ret = append(ret, svc)
+		}
+	}
+	slices.SortFunc(ret, func(a, b *api.AgentService) int { return strings.Compare(a.ID, b.ID) })
+	return ret
+}
+
+func (s *Server) handleCatalogService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	idx, ok := s.block(r, func() uint64 { return s.serviceIndex[name] })
+	defer s.mu.Unlock()
+	if !ok {
+		http.Error(w, "consultest: failing", http.StatusInternalServerError)
+		return
+	}
+
+	ret := []*api.CatalogService{}
+	for _, svc := range s.matchServices(name, r.URL.Query()["tag"]) {
+		ret = append(ret, &api.CatalogService{
+			Node:           NodeName,
+			Address:        NodeAddress,
+			ServiceID:      svc.ID,
+			ServiceName:    svc.Service,
+			ServiceAddress: svc.Address,
+			ServicePort:    svc.Port,
+			ServiceTags:    svc.Tags,
+			ServiceMeta:    svc.Meta,
+			ServiceWeights: api.Weights{Passing: svc.Weights.Passing, Warning: svc.Weights.Warning},
+		})
+	}
+	writeJSON(w, idx, ret)
+}
+
+func (s *Server) handleHealthService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	idx, ok := s.block(r, func() uint64 { return s.serviceIndex[name] })
+	defer s.mu.Unlock()
+	if !ok {
+		http.Error(w, "consultest: failing", http.StatusInternalServerError)
+		return
+	}
+
+	_, passingOnly := r.URL.Query()["passing"]
+	ret := []*api.ServiceEntry{}
+	for _, svc := range s.matchServices(name, r.URL.Query()["tag"]) {
+		var checks api.HealthChecks
+		for _, check := range s.checks {
+			if check.ServiceID == svc.ID {
+				c := *check
+				checks = append(checks, &c)
+			}
+		}
+		if passingOnly && checks.AggregatedStatus() != api.HealthPassing {
+			continue
+		}
+		c := *svc
+		ret = append(ret, &api.ServiceEntry{
+			Node:    &api.Node{Node: NodeName, Address: NodeAddress},
+			Service: &c,
+			Checks:  checks,
+		})
+	}
+	writeJSON(w, idx, ret)
+}
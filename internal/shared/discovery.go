@@ -0,0 +1,10 @@
+package shared
+
+var Discovery func() string
+
+func GetDiscovery() string {
+	if Discovery == nil {
+		return ""
+	}
+	return Discovery()
+}
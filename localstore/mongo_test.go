@@ -40,7 +40,7 @@ func (um *UserModel) CollectionName() string {
 func init() {
 	client := qmgoutil.NewClientWithModel(&UserModel{})
 	qmgoStore = NewQmgoDataStore(client, &UserModel{})
-	qmgoutil.CollectionByModel(&UserModel{}).InsertMany(context.Background(), []*UserModel{
+	qmgoutil.CollectionByClient(client, &UserModel{}).InsertMany(context.Background(), []*UserModel{
 		{Username: "yang", Age: 18},
 		{Username: "hao", Age: 19},
 		{Username: "wen", Age: 20},
@@ -0,0 +1,74 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/vflags"
+)
+
+// NewCommand registers the vflags command running the migrator, e.g.
+//
+//	app migrate up [--to=version] [--dry-run]
+//	app migrate down [--steps=1] [--dry-run]
+//	app migrate status
+//
+// The migrator is created after the flags are parsed, so it can dial the database by the configs.
+func NewCommand(name string, newMigrator func(ctx context.Context, opts ...OptionFunc) (*Migrator, error)) *vflags.Command {
+	c := vflags.NewCommand(name, "Run the database migrations: up, down or status")
+	dryRun := c.Bool("dry-run", false, "Log the migrations without running them")
+	steps := c.Int("steps", 1, "The number of migrations reverted by down")
+	to := c.Int("to", 0, "The version migrated up to, 0 means the latest")
+
+	c.Run = func(ctx context.Context) error {
+		action := "up"
+		if args := c.Args(); len(args) > 0 {
+			action = args[0]
+		}
+		m, err := newMigrator(ctx, WithDryRun(dryRun()))
+		if err != nil {
+			return errors.Wrap(err, "new migrator")
+		}
+
+		switch action {
+		case "up":
+			done, err := m.UpTo(ctx, int64(to()))
+			lg.Infoc(ctx, "Applied %d migrations", len(done))
+			return err
+		case "down":
+			done, err := m.Down(ctx, steps())
+			lg.Infoc(ctx, "Reverted %d migrations", len(done))
+			return err
+		case "status":
+			status, err := m.Status(ctx)
+			if err != nil {
+				return err
+			}
+			printStatus(status)
+			return nil
+		}
+		return errors.Errorf("unknown migrate action %q, should be up, down or status", action)
+	}
+	return c
+}
+
+func printStatus(status []Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range status {
+		appliedAt := "pending"
+		if s.Applied {
+			appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		if s.Unknown {
+			appliedAt += " (unknown)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strconv.FormatInt(s.Version, 10), s.Name, appliedAt)
+	}
+	w.Flush()
+}
@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Dialect is the database the migrations run on.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// ParseDialect parses the dialect by the name, such as the driver names mysql, pgx, postgres, sqlite3.
+func ParseDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "mysql":
+		return MySQL, nil
+	case "postgres", "postgresql", "pgx":
+		return Postgres, nil
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	}
+	return "", errors.Errorf("unsupported dialect %q", name)
+}
+
+func (d Dialect) validate() error {
+	switch d {
+	case MySQL, Postgres, SQLite:
+		return nil
+	}
+	return errors.Errorf("unsupported dialect %q", d)
+}
+
+// placeholder returns the placeholder of the nth argument, starting from 1.
+func (d Dialect) placeholder(n int) string {
+	if d == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func (d Dialect) createTableSQL(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	version BIGINT NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`, table)
+}
+
+func (d Dialect) createLockTableSQL(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id INTEGER NOT NULL PRIMARY KEY,
+	locked_at TIMESTAMP NOT NULL
+)`, table)
+}
+
+// lock acquires the lock of the migrations, and returns the func releasing it.
+// MySQL and Postgres use the advisory locks held by a dedicated connection, which are released
+// by the server if the process dies. SQLite uses a row of the lock table instead.
+func (d Dialect) lock(ctx context.Context, db *sql.DB, table string, timeout time.Duration) (func() error, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch d {
+	case MySQL:
+		return mysqlLock(ctx, db, table, timeout)
+	case Postgres:
+		return postgresLock(ctx, db, table)
+	}
+	return tableLock(ctx, d, db, table+"_lock")
+}
+
+func mysqlLock(ctx context.Context, db *sql.DB, table string, timeout time.Duration) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get connection")
+	}
+	name := "venkit_migrate:" + table
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(timeout.Seconds())).Scan(&got); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "get lock")
+	}
+	if got.Int64 != 1 {
+		conn.Close()
+		return nil, errors.Errorf("lock %v timeout after %v", name, timeout)
+	}
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+		return err
+	}, nil
+}
+
+func postgresLock(ctx context.Context, db *sql.DB, table string) (func() error, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get connection")
+	}
+	key := int64(crc32.ChecksumIEEE([]byte("venkit_migrate:" + table)))
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "advisory lock")
+	}
+	return func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		return err
+	}, nil
+}
+
+// tableLock inserts the only row of the lock table, and waits while the row exists.
+// The row is left if the process dies while migrating, which must be deleted by hand.
+func tableLock(ctx context.Context, d Dialect, db *sql.DB, table string) (func() error, error) {
+	if _, err := db.ExecContext(ctx, d.createLockTableSQL(table)); err != nil {
+		return nil, errors.Wrap(err, "create lock table")
+	}
+	insert := fmt.Sprintf("INSERT INTO %s (id, locked_at) VALUES (1, %s)", table, d.placeholder(1))
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := db.ExecContext(ctx, insert, time.Now().UTC()); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Errorf("lock table %v is held, delete its row if no migration is running", table)
+		case <-ticker.C:
+		}
+	}
+	return func() error {
+		_, err := db.ExecContext(context.Background(), fmt.Sprintf("DELETE FROM %s WHERE id = 1", table))
+		return err
+	}, nil
+}
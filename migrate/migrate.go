@@ -0,0 +1,317 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+const (
+	DefaultTable       = "schema_migrations"
+	DefaultLockTimeout = time.Minute
+)
+
+// MigrateFunc runs a migration in the transaction.
+type MigrateFunc func(ctx context.Context, tx *sql.Tx) error
+
+// Migration is a version of the schema, which is applied by the sql or the func.
+// The sql may contain multiple statements separated by semicolons.
+//
+// The migration and its record run in one transaction, but MySQL commits the DDL statements
+// implicitly, so keep one DDL statement per migration on MySQL.
+type Migration struct {
+	Version int64
+	Name    string
+
+	UpSQL   string
+	DownSQL string
+	Up      MigrateFunc
+	Down    MigrateFunc
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+func (m Migration) hasDown() bool {
+	return m.Down != nil || m.DownSQL != ""
+}
+
+// Status is the status of a migration. Unknown is true if the version is applied but not
+// added to the migrator, e.g. applied by a newer release.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Unknown   bool
+}
+
+type Option struct {
+	Table       string
+	LockTimeout time.Duration
+	// DryRun logs the migrations to apply or revert without running them
+	DryRun bool
+}
+
+type OptionFunc func(*Option)
+
+// WithTable sets the table recording the applied versions, default is schema_migrations.
+func WithTable(table string) OptionFunc {
+	return func(o *Option) {
+		o.Table = table
+	}
+}
+
+// WithLockTimeout sets the max time waiting for the other replicas migrating.
+func WithLockTimeout(d time.Duration) OptionFunc {
+	return func(o *Option) {
+		o.LockTimeout = d
+	}
+}
+
+func WithDryRun(dryRun bool) OptionFunc {
+	return func(o *Option) {
+		o.DryRun = dryRun
+	}
+}
+
+// Migrator applies and reverts the migrations of a database. The replicas running the migrator
+// at the same time are serialized by the lock of the database, so only one of them migrates.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	opt        *Option
+	migrations []Migration
+}
+
+func New(db *sql.DB, dialect Dialect, opts ...OptionFunc) (*Migrator, error) {
+	if err := dialect.validate(); err != nil {
+		return nil, err
+	}
+	opt := &Option{
+		Table:       DefaultTable,
+		LockTimeout: DefaultLockTimeout,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &Migrator{db: db, dialect: dialect, opt: opt}, nil
+}
+
+// Add adds the migrations, the versions must be unique.
+func (m *Migrator) Add(migrations ...Migration) error {
+	for _, mig := range migrations {
+		if mig.Up == nil && mig.UpSQL == "" {
+			return errors.Errorf("migration %v has no up", mig)
+		}
+		for _, exists := range m.migrations {
+			if exists.Version == mig.Version {
+				return errors.Errorf("migration %v conflicts with %v", mig, exists)
+			}
+		}
+		m.migrations = append(m.migrations, mig)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	return nil
+}
+
+// AddFunc adds the migration run by the funcs, the down may be nil.
+func (m *Migrator) AddFunc(version int64, name string, up, down MigrateFunc) error {
+	return m.Add(Migration{Version: version, Name: name, Up: up, Down: down})
+}
+
+// Migrations returns the added migrations ordered by the version.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, m.dialect.createTableSQL(m.opt.Table))
+	return errors.Wrap(err, "create migration table")
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]Status, error) {
+	rows, err := m.db.QueryContext(ctx, fmt.Sprintf("SELECT version, name, applied_at FROM %s", m.opt.Table))
+	if err != nil {
+		return nil, errors.Wrap(err, "query applied migrations")
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]Status)
+	for rows.Next() {
+		var s Status
+		if err := rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+			return nil, errors.Wrap(err, "scan applied migration")
+		}
+		s.Applied = true
+		applied[s.Version] = s
+	}
+	return applied, errors.Wrap(rows.Err(), "query applied migrations")
+}
+
+// Status returns the status of the added and applied migrations ordered by the version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if a, exists := applied[mig.Version]; exists {
+			s.Applied, s.AppliedAt = true, a.AppliedAt
+			delete(applied, mig.Version)
+		}
+		result = append(result, s)
+	}
+	for _, s := range applied {
+		s.Unknown = true
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
+
+// Up applies all the pending migrations, and returns the applied ones.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo applies the pending migrations up to the version, 0 means the latest one.
+// The migrations older than the latest applied one are applied as well.
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(applied map[int64]Status) error {
+		for _, mig := range m.migrations {
+			if version > 0 && mig.Version > version {
+				break
+			}
+			if _, exists := applied[mig.Version]; exists {
+				continue
+			}
+			if err := m.run(ctx, mig, true); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest steps applied migrations, and returns the reverted ones.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(applied map[int64]Status) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := m.migrations[i]
+			if _, exists := applied[mig.Version]; !exists {
+				continue
+			}
+			if !mig.hasDown() {
+				return errors.Errorf("migration %v can not be reverted", mig)
+			}
+			if err := m.run(ctx, mig, false); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(applied map[int64]Status) error) error {
+	if err := m.ensureTable(ctx); err != nil {
+		return err
+	}
+	unlock, err := m.dialect.lock(ctx, m.db, m.opt.Table, m.opt.LockTimeout)
+	if err != nil {
+		return errors.Wrap(err, "lock migrations")
+	}
+	defer func() {
+		if err := unlock(); err != nil {
+			lg.Errorc(ctx, "Unlock migrations error: %v", err)
+		}
+	}()
+
+	// the applied versions are read after locking, since another replica may have migrated
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(applied)
+}
+
+func (m *Migrator) run(ctx context.Context, mig Migration, up bool) error {
+	action, fn, query := "Apply", mig.Up, mig.UpSQL
+	if !up {
+		action, fn, query = "Revert", mig.Down, mig.DownSQL
+	}
+	if m.opt.DryRun {
+		lg.Infoc(ctx, "[dry-run] %v migration %v", action, mig)
+		if fn == nil {
+			for _, stmt := range splitStatements(query) {
+				lg.Infoc(ctx, "[dry-run] %v", stmt)
+			}
+		}
+		return nil
+	}
+
+	start := time.Now()
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrapf(err, "begin migration %v", mig)
+	}
+	defer tx.Rollback()
+
+	if fn != nil {
+		err = fn(ctx, tx)
+	} else {
+		err = execStatements(ctx, tx, query)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "%v migration %v", action, mig)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (%s, %s, %s)",
+				m.opt.Table, m.dialect.placeholder(1), m.dialect.placeholder(2), m.dialect.placeholder(3)),
+			mig.Version, mig.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE version = %s", m.opt.Table, m.dialect.placeholder(1)),
+			mig.Version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "record migration %v", mig)
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrapf(err, "commit migration %v", mig)
+	}
+	lg.Infoc(ctx, "%v migration %v in %v", action, mig, time.Since(start))
+	return nil
+}
+
+func execStatements(ctx context.Context, tx *sql.Tx, query string) error {
+	for _, stmt := range splitStatements(query) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return errors.Wrapf(err, "exec %q", stmt)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFS = fstest.MapFS{
+	"migrations/1_create_users.up.sql": {Data: []byte(`
+-- users of the app
+CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+INSERT INTO users (id, name) VALUES (1, 'a;b');
+`)},
+	"migrations/1_create_users.down.sql": {Data: []byte(`DROP TABLE users;`)},
+	"migrations/2_add_age.up.sql":        {Data: []byte(`ALTER TABLE users ADD COLUMN age INTEGER NOT NULL DEFAULT 0`)},
+	"migrations/2_add_age.down.sql":      {Data: []byte(`ALTER TABLE users DROP COLUMN age`)},
+	"migrations/README.md":               {Data: []byte(`not a migration`)},
+}
+
+func newTestMigrator(t *testing.T, opts ...OptionFunc) (*Migrator, *sql.DB) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, SQLite, opts...)
+	require.Nil(t, err)
+	migrations, err := LoadFS(testFS, "migrations")
+	require.Nil(t, err)
+	require.Nil(t, m.Add(migrations...))
+	require.Nil(t, m.AddFunc(3, "rename_user", func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET name = 'c' WHERE id = 1")
+		return err
+	}, nil))
+	return m, db
+}
+
+func TestUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	m, db := newTestMigrator(t)
+
+	done, err := m.UpTo(ctx, 2)
+	assert.Nil(t, err)
+	assert.Len(t, done, 2)
+
+	var name string
+	assert.Nil(t, db.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name))
+	assert.Equal(t, "a;b", name)
+
+	done, err = m.Up(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, done, 1)
+	assert.Nil(t, db.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name))
+	assert.Equal(t, "c", name)
+
+	done, err = m.Up(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, done, 0)
+
+	status, err := m.Status(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, status, 3)
+	for _, s := range status {
+		assert.True(t, s.Applied)
+	}
+
+	// the func migration has no down
+	_, err = m.Down(ctx, 1)
+	assert.NotNil(t, err)
+
+	_, err = db.Exec("DELETE FROM schema_migrations WHERE version = 3")
+	assert.Nil(t, err)
+	done, err = m.Down(ctx, 2)
+	assert.Nil(t, err)
+	assert.Len(t, done, 2)
+	assert.NotNil(t, db.QueryRow("SELECT name FROM users").Scan(&name))
+
+	status, err = m.Status(ctx)
+	assert.Nil(t, err)
+	for _, s := range status {
+		assert.False(t, s.Applied)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	m, db := newTestMigrator(t, WithDryRun(true))
+
+	done, err := m.Up(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, done, 3)
+
+	var count int
+	assert.Nil(t, db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestUnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	m, db := newTestMigrator(t)
+
+	_, err := m.Up(ctx)
+	assert.Nil(t, err)
+	_, err = db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (4, 'newer', CURRENT_TIMESTAMP)")
+	assert.Nil(t, err)
+
+	status, err := m.Status(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, status, 4)
+	assert.True(t, status[3].Unknown)
+}
+
+func TestConcurrentUp(t *testing.T) {
+	ctx := context.Background()
+	m, db := newTestMigrator(t)
+	other, err := New(db, SQLite)
+	require.Nil(t, err)
+	require.Nil(t, other.Add(m.Migrations()...))
+
+	var (
+		wg    sync.WaitGroup
+		total int
+		mu    sync.Mutex
+	)
+	for _, mig := range []*Migrator{m, other} {
+		wg.Add(1)
+		go func(mig *Migrator) {
+			defer wg.Done()
+			done, err := mig.Up(ctx)
+			assert.Nil(t, err)
+			mu.Lock()
+			total += len(done)
+			mu.Unlock()
+		}(mig)
+	}
+	wg.Wait()
+	assert.Equal(t, 3, total)
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements(`
+CREATE FUNCTION f() RETURNS trigger AS $body$
+BEGIN
+	NEW.updated_at = now();
+	RETURN NEW;
+END;
+$body$ LANGUAGE plpgsql;
+/* a; comment */
+INSERT INTO t (s) VALUES ('it''s;', "x;y"); -- trailing; comment
+SELECT $1;
+-- only comments
+`)
+	assert.Len(t, stmts, 3)
+	assert.Contains(t, stmts[0], "RETURN NEW;")
+	assert.Contains(t, stmts[1], `'it''s;', "x;y"`)
+	assert.Contains(t, stmts[2], "SELECT $1")
+}
+
+func TestLoadFSMissingUp(t *testing.T) {
+	_, err := LoadFS(fstest.MapFS{
+		"m/1_a.down.sql": {Data: []byte("DROP TABLE a")},
+	}, "m")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,143 @@
+package migrate
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// migrationFile matches the files like 20240102150405_create_users.up.sql and 20240102150405_create_users.down.sql.
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadFS loads the sql migrations in the dir of fsys, which is usually an embed.FS.
+// The files are named <version>_<name>.up.sql and <version>_<name>.down.sql, the down file is optional.
+func LoadFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read dir %s", dir)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	var versions []int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse version of %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", entry.Name())
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+			versions = append(versions, version)
+		} else if m.Name != match[2] {
+			return nil, errors.Errorf("version %d has two names %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.UpSQL = string(content)
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		m := byVersion[version]
+		if m.UpSQL == "" {
+			return nil, errors.Errorf("migration %d_%s has no up sql", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	return migrations, nil
+}
+
+// splitStatements splits the sql by the semicolons outside the quotes, comments and the
+// dollar quoted bodies of postgres, so that the drivers without multi statements can run it.
+func splitStatements(sql string) []string {
+	var (
+		stmts []string
+		start int
+	)
+	add := func(end int) {
+		if stmt := strings.TrimSpace(sql[start:end]); stmt != "" && !onlyComments(stmt) {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			for i++; i < len(sql) && sql[i] != c; i++ {
+				if sql[i] == '\\' && c != '`' {
+					i++
+				}
+			}
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(sql)
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(sql)
+			}
+		case c == '$':
+			tag := dollarTag(sql[i:])
+			if tag == "" {
+				continue
+			}
+			if j := strings.Index(sql[i+len(tag):], tag); j >= 0 {
+				i += len(tag) + j + len(tag) - 1
+			} else {
+				i = len(sql)
+			}
+		case c == ';':
+			add(i)
+			start = i + 1
+		}
+	}
+	add(len(sql))
+	return stmts
+}
+
+// dollarTag returns the tag like $$ or $body$ at the beginning of s.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
+func onlyComments(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
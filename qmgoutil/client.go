@@ -11,16 +11,13 @@ import (
 	"github.com/qiniu/qmgo"
 	qoptions "github.com/qiniu/qmgo/options"
 	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/dialer"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
-var (
-	clientCache = make(map[string]*Client)
-)
-
 type Config struct {
 	Address     string
 	Username    string
@@ -163,12 +160,34 @@ func (c *Client) Client() *qmgo.Client {
 	return c.cli
 }
 
-func GetDBInstance(m QmgoModel) *Client {
+// Ping checks the connection within the deadline of ctx, 5 seconds by default.
+func (c *Client) Ping(ctx context.Context) error {
+	timeout := int64(5)
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(int64(time.Until(deadline).Seconds()), 1)
+	}
+	return c.cli.Ping(timeout)
+}
+
+func (c *Client) Close(ctx context.Context) error {
+	return c.cli.Close(ctx)
+}
+
+// GetDBInstance returns the client of the model from the default registry of dialer, which is
+// dialed on the first call, shared by the same address, and closed at shutdown.
+// It returns dialer.ErrRegistryClosed after shutdown.
+func GetDBInstance(m QmgoModel) (*Client, error) {
 	conf := m.DBConfig()
+	key := "qmgo:" + conf.Address
 	
-	if _, ok := clientCache[conf.Address]; !ok {
-		clientCache[conf.Address] = NewClientWithConfig(&conf)
+	conn, err := dialer.DefaultRegistry().GetOrRegister(key, dialer.Resource{
+		Key: key,
+		Dial: func() (any, error) {
+			return NewClientWithConfig(&conf), nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get qmgo client: %w", err)
 	}
-	
-	return clientCache[conf.Address]
+	return conn.(*Client), nil
 }
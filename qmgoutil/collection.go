@@ -2,9 +2,12 @@ package qmgoutil
 
 import "github.com/qiniu/qmgo"
 
-func CollectionByModel(m QmgoModel) *qmgo.Collection {
-	client := GetDBInstance(m)
-	return client.Client().Database(m.DatabaseName()).Collection(m.CollectionName())
+func CollectionByModel(m QmgoModel) (*qmgo.Collection, error) {
+	client, err := GetDBInstance(m)
+	if err != nil {
+		return nil, err
+	}
+	return CollectionByClient(client, m), nil
 }
 
 func CollectionByClient(client *Client, m QmgoModel) *qmgo.Collection {
@@ -27,7 +27,10 @@ func (u *User) CollectionName() string {
 }
 
 func main() {
-	col := qmgoutil.CollectionByModel(&User{})
+	col, err := qmgoutil.CollectionByModel(&User{})
+	if err != nil {
+		panic(err)
+	}
 	col.InsertOne(context.Background(), &User{Username: "yanghw"})
 	
 	var res []*User
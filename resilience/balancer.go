@@ -0,0 +1,73 @@
+package resilience
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// BalancerName is the grpc load balancing policy, which picks the addresses in turn and skips
+// the ones whose breakers are open. The breakers are keyed by the target of the connection,
+// e.g. user:dev of venkit:///user:dev, and the picked address, and kept by the default manager.
+const BalancerName = "venkit_resilience"
+
+func init() {
+	balancer.Register(balancerBuilder{})
+}
+
+type balancerBuilder struct{}
+
+func (balancerBuilder) Name() string {
+	return BalancerName
+}
+
+func (balancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{service: opts.Target.Endpoint()}
+	return base.NewBalancerBuilder(BalancerName, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+type pickerBuilder struct {
+	service string
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	m := Default()
+	p := &picker{service: pb.service}
+	for sc, scInfo := range info.ReadySCs {
+		p.subConns = append(p.subConns, sc)
+		p.breakers = append(p.breakers, m.Breaker(pb.service, scInfo.Address.Addr))
+	}
+	return p
+}
+
+type picker struct {
+	service  string
+	subConns []balancer.SubConn
+	breakers []*Breaker
+	next     atomic.Uint32
+}
+
+// Pick picks the next address whose breaker allows the call, and reports the result of the call to the breaker.
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	n := uint32(len(p.subConns))
+	start := p.next.Add(1)
+	for i := uint32(0); i < n; i++ {
+		idx := int((start + i) % n)
+		done, err := p.breakers[idx].Allow()
+		if err != nil {
+			continue
+		}
+		return balancer.PickResult{
+			SubConn: p.subConns[idx],
+			Done: func(di balancer.DoneInfo) {
+				failure, _ := classifyCall(info.Ctx, di.Err, ClassifyGrpc)
+				done(!failure)
+			},
+		}, nil
+	}
+	return balancer.PickResult{}, breakerOpenError(p.service)
+}
@@ -0,0 +1,227 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// StateClosed lets all calls through and counts the failures.
+	StateClosed State = iota
+	// StateOpen rejects all calls until the open timeout passes.
+	StateOpen
+	// StateHalfOpen lets a few probe calls through, and closes if they all succeed.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type BreakerConfig struct {
+	// ConsecutiveFailures opens the breaker after so many failures in a row, 0 disables it.
+	ConsecutiveFailures int
+	// FailureRatio opens the breaker if the ratio of failures within Window reaches it, 0 disables it.
+	FailureRatio float64
+	// MinRequests is the min calls within Window before FailureRatio takes effect.
+	MinRequests int
+	Window      time.Duration
+	// OpenTimeout is how long the breaker keeps open before letting the probes through.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes, and the breaker closes after all of them succeed.
+	HalfOpenRequests int
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		ConsecutiveFailures: 5,
+		FailureRatio:        0.5,
+		MinRequests:         20,
+		Window:              10 * time.Second,
+		OpenTimeout:         30 * time.Second,
+		HalfOpenRequests:    1,
+	}
+}
+
+// Breaker is the circuit breaker of a service address.
+type Breaker struct {
+	service string
+	address string
+	conf    BreakerConfig
+	now     func() time.Time
+
+	mu    sync.Mutex
+	state State
+	since time.Time
+	// generation increases on every state change, the results of the calls allowed
+	// in the previous generations do not change the state.
+	generation uint64
+	openUntil  time.Time
+
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int
+	successes   int
+
+	totalRequests uint64
+	totalFailures uint64
+	totalRejected uint64
+}
+
+func newBreaker(service, address string, conf BreakerConfig) *Breaker {
+	now := time.Now()
+	return &Breaker{
+		service:     service,
+		address:     address,
+		conf:        conf,
+		now:         time.Now,
+		since:       now,
+		windowStart: now,
+	}
+}
+
+// Allow returns ErrOpen if the call is rejected, otherwise the caller must call done with the result of the call.
+func (b *Breaker) Allow() (done func(success bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if b.state == StateOpen && !now.Before(b.openUntil) {
+		b.setState(StateHalfOpen, now)
+	}
+	switch b.state {
+	case StateOpen:
+		b.totalRejected++
+		return nil, ErrOpen
+	case StateHalfOpen:
+		if b.probes >= max(b.conf.HalfOpenRequests, 1) {
+			b.totalRejected++
+			return nil, ErrOpen
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	return func(success bool) {
+		b.done(generation, success)
+	}, nil
+}
+
+func (b *Breaker) done(generation uint64, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.totalRequests++
+	if !success {
+		b.totalFailures++
+	}
+	if generation != b.generation {
+		return
+	}
+
+	now := b.now()
+	switch b.state {
+	case StateClosed:
+		if b.conf.Window > 0 && now.Sub(b.windowStart) >= b.conf.Window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+		b.requests++
+		if success {
+			b.consecutive = 0
+			return
+		}
+		b.failures++
+		b.consecutive++
+		if b.shouldTrip() {
+			b.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if !success {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= max(b.conf.HalfOpenRequests, 1) {
+			b.setState(StateClosed, now)
+		}
+	}
+}
+
+func (b *Breaker) shouldTrip() bool {
+	if b.conf.ConsecutiveFailures > 0 && b.consecutive >= b.conf.ConsecutiveFailures {
+		return true
+	}
+	return b.conf.FailureRatio > 0 && b.requests >= b.conf.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.conf.FailureRatio
+}
+
+// setState changes the state and resets the counters, it must be called with the lock held.
+func (b *Breaker) setState(state State, now time.Time) {
+	if state == StateOpen {
+		b.openUntil = now.Add(b.conf.OpenTimeout)
+		lg.Warnf("Circuit breaker of %s %s is open for %v.", b.service, b.address, b.conf.OpenTimeout)
+	} else if b.state != StateClosed && state == StateClosed {
+		lg.Infof("Circuit breaker of %s %s is closed.", b.service, b.address)
+	}
+
+	b.state = state
+	b.since = now
+	b.generation++
+	b.windowStart = now
+	b.requests, b.failures, b.consecutive = 0, 0, 0
+	b.probes, b.successes = 0, 0
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && !b.now().Before(b.openUntil) {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+type BreakerStats struct {
+	Service  string    `json:"service"`
+	Address  string    `json:"address"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Requests uint64    `json:"requests"`
+	Failures uint64    `json:"failures"`
+	Rejected uint64    `json:"rejected"`
+}
+
+func (b *Breaker) Stats() BreakerStats {
+	state := b.State()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{
+		Service:  b.service,
+		Address:  b.address,
+		State:    state.String(),
+		Since:    b.since,
+		Requests: b.totalRequests,
+		Failures: b.totalFailures,
+		Rejected: b.totalRejected,
+	}
+}
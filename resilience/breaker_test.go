@@ -0,0 +1,176 @@
+package resilience
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestBreaker(conf BreakerConfig) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	b := newBreaker("app", "127.0.0.1:8080", conf)
+	b.now = clock.now
+	b.windowStart = clock.t
+	return b, clock
+}
+
+func call(b *Breaker, success bool) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	done(success)
+	return nil
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	b, clock := newTestBreaker(BreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Second, HalfOpenRequests: 2})
+
+	assert.Nil(t, call(b, false))
+	assert.Nil(t, call(b, false))
+	assert.Nil(t, call(b, true))
+	assert.Nil(t, call(b, false))
+	assert.Nil(t, call(b, false))
+	assert.Equal(t, StateClosed, b.State())
+	assert.Nil(t, call(b, false))
+	assert.Equal(t, StateOpen, b.State())
+	assert.ErrorIs(t, call(b, true), ErrOpen)
+
+	// half-open lets the probes through, and opens again if any fails
+	clock.t = clock.t.Add(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.Nil(t, call(b, true))
+	assert.Nil(t, call(b, false))
+	assert.Equal(t, StateOpen, b.State())
+
+	clock.t = clock.t.Add(time.Second)
+	done1, err := b.Allow()
+	assert.Nil(t, err)
+	done2, err := b.Allow()
+	assert.Nil(t, err)
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrOpen)
+	done1(true)
+	done2(true)
+	assert.Equal(t, StateClosed, b.State())
+
+	stats := b.Stats()
+	assert.Equal(t, "closed", stats.State)
+	assert.Equal(t, uint64(10), stats.Requests)
+	assert.Equal(t, uint64(6), stats.Failures)
+	assert.Equal(t, uint64(2), stats.Rejected)
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	b, clock := newTestBreaker(BreakerConfig{FailureRatio: 0.5, MinRequests: 4, Window: time.Minute, OpenTimeout: time.Second})
+
+	// the stale results of the previous window are dropped
+	assert.Nil(t, call(b, false))
+	assert.Nil(t, call(b, false))
+	assert.Nil(t, call(b, false))
+	clock.t = clock.t.Add(time.Minute)
+
+	assert.Nil(t, call(b, true))
+	assert.Nil(t, call(b, false))
+	assert.Nil(t, call(b, true))
+	assert.Equal(t, StateClosed, b.State())
+	assert.Nil(t, call(b, false))
+	assert.Equal(t, StateOpen, b.State())
+
+	// the results of the calls allowed before opening do not change the state
+	clock.t = clock.t.Add(time.Second)
+	done, err := b.Allow()
+	assert.Nil(t, err)
+	b.mu.Lock()
+	b.setState(StateOpen, clock.t)
+	b.mu.Unlock()
+	done(true)
+	assert.Equal(t, StateOpen, b.State())
+}
+
+func TestRetryBudget(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	b := newRetryBudget("app", BudgetConfig{Ratio: 0.5, MinPerSecond: 1, MaxTokens: 2})
+	b.now = clock.now
+	b.last = clock.t
+
+	assert.True(t, b.TryRetry())
+	assert.True(t, b.TryRetry())
+	assert.False(t, b.TryRetry())
+
+	b.Call()
+	assert.False(t, b.TryRetry())
+	b.Call()
+	assert.True(t, b.TryRetry())
+
+	clock.t = clock.t.Add(time.Second)
+	assert.True(t, b.TryRetry())
+	assert.False(t, b.TryRetry())
+
+	stats := b.Stats()
+	assert.Equal(t, uint64(2), stats.Calls)
+	assert.Equal(t, uint64(4), stats.Retries)
+	assert.Equal(t, uint64(3), stats.Exhausted)
+}
+
+func TestManagerDo(t *testing.T) {
+	m := NewManager(
+		WithBreakerConfig(BreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Minute}),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3}),
+	)
+	ctx := context.Background()
+
+	calls := 0
+	err := m.Do(ctx, "app", "", nil, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	notRetryable := func(err error) (bool, bool) { return err != nil, false }
+	err = m.Do(ctx, "app", "", notRetryable, func(ctx context.Context) error {
+		calls++
+		return errors.New("bad request")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+
+	// the breaker opens after 3 failures in a row, and the retry is rejected
+	err = m.Do(ctx, "app", "", nil, func(ctx context.Context) error {
+		calls++
+		return errors.New("unavailable")
+	})
+	assert.ErrorIs(t, err, ErrOpen)
+	assert.Equal(t, 3, calls)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/resilience", nil))
+	snapshot := Snapshot{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&snapshot))
+	if assert.Len(t, snapshot.Breakers, 1) {
+		assert.Equal(t, "open", snapshot.Breakers[0].State)
+		assert.Equal(t, uint64(1), snapshot.Breakers[0].Rejected)
+	}
+	if assert.Len(t, snapshot.Budgets, 1) {
+		assert.Equal(t, uint64(3), snapshot.Budgets[0].Calls)
+		assert.Equal(t, uint64(4), snapshot.Budgets[0].Retries)
+	}
+}
@@ -0,0 +1,126 @@
+package resilience
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BudgetConfig limits the retries of a service, so that the retries do not overload a misbehaving service.
+type BudgetConfig struct {
+	// Ratio is the max ratio of retries to calls, e.g. 0.2 allows a retry every 5 calls.
+	Ratio float64
+	// MinPerSecond allows some retries per second even if there are few calls.
+	MinPerSecond float64
+	// MaxTokens is the max retries which can be saved up.
+	MaxTokens float64
+}
+
+func DefaultBudgetConfig() BudgetConfig {
+	return BudgetConfig{
+		Ratio:        0.2,
+		MinPerSecond: 1,
+		MaxTokens:    10,
+	}
+}
+
+// RetryBudget is a token bucket, every call deposits Ratio tokens and every retry withdraws one.
+type RetryBudget struct {
+	service string
+	conf    BudgetConfig
+	now     func() time.Time
+
+	mu        sync.Mutex
+	tokens    float64
+	last      time.Time
+	calls     uint64
+	retries   uint64
+	exhausted uint64
+}
+
+func newRetryBudget(service string, conf BudgetConfig) *RetryBudget {
+	return &RetryBudget{
+		service: service,
+		conf:    conf,
+		now:     time.Now,
+		tokens:  conf.MaxTokens,
+		last:    time.Now(),
+	}
+}
+
+// Call deposits the tokens of a call.
+func (b *RetryBudget) Call() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	b.tokens = min(b.tokens+b.conf.Ratio, b.conf.MaxTokens)
+}
+
+// TryRetry withdraws a token, and returns false if the budget is exhausted.
+func (b *RetryBudget) TryRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.conf.MinPerSecond, b.conf.MaxTokens)
+	b.last = now
+	if b.tokens < 1 {
+		b.exhausted++
+		return false
+	}
+	b.tokens--
+	b.retries++
+	return true
+}
+
+type BudgetStats struct {
+	Service   string  `json:"service"`
+	Tokens    float64 `json:"tokens"`
+	Calls     uint64  `json:"calls"`
+	Retries   uint64  `json:"retries"`
+	Exhausted uint64  `json:"exhausted"`
+}
+
+func (b *RetryBudget) Stats() BudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BudgetStats{
+		Service:   b.service,
+		Tokens:    b.tokens,
+		Calls:     b.calls,
+		Retries:   b.retries,
+		Exhausted: b.exhausted,
+	}
+}
+
+// RetryPolicy is how a failed call is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the max attempts of a call including the first one, 1 disables retries.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, which doubles on every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     50 * time.Millisecond,
+		MaxBackoff:  time.Second,
+	}
+}
+
+// Wait returns the wait before the retry after the attempt, with jitter.
+func (p RetryPolicy) Wait(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 {
+		wait = min(wait, p.MaxBackoff)
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
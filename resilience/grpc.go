@@ -0,0 +1,81 @@
+package resilience
+
+import (
+	"context"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ClassifyGrpc treats the codes meaning the backend is unhealthy as failures,
+// and only retries Unavailable, which is safe for the non-idempotent methods.
+func ClassifyGrpc(err error) (failure, retryable bool) {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return true, true
+	case codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true, false
+	}
+	return false, false
+}
+
+// UnaryClientInterceptor retries the failed calls to the service within the retry policy and the
+// retry budget of the service. The breakers of the addresses are checked by the BalancerName policy,
+// and the calls rejected by them are not retried, since all the addresses are open.
+func UnaryClientInterceptor(m *Manager, service string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return m.Retry(ctx, service, classifyGrpcCall, func(ctx context.Context) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
+// StreamClientInterceptor retries the failed creations of the streams to the service like
+// UnaryClientInterceptor, the messages of the created streams are not retried.
+func StreamClientInterceptor(m *Manager, service string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var stream grpc.ClientStream
+		err := m.Retry(ctx, service, classifyGrpcCall, func(ctx context.Context) (err error) {
+			stream, err = streamer(ctx, desc, cc, method, opts...)
+			return err
+		})
+		return stream, err
+	}
+}
+
+const (
+	breakerOpenReason = "BREAKER_OPEN"
+	breakerOpenDomain = "resilience.venkit"
+)
+
+// breakerOpenError is returned by the picker when the breakers of all the addresses are open,
+// which is marked by the detail so that it is told apart from the errors of the servers.
+func breakerOpenError(service string) error {
+	st := status.Newf(codes.Unavailable, "%s: %v", service, ErrOpen)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: breakerOpenReason, Domain: breakerOpenDomain}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func isBreakerOpen(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == breakerOpenReason && info.Domain == breakerOpenDomain {
+			return true
+		}
+	}
+	return false
+}
+
+func classifyGrpcCall(err error) (failure, retryable bool) {
+	if isBreakerOpen(err) {
+		return false, false
+	}
+	return ClassifyGrpc(err)
+}
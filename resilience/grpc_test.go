@@ -0,0 +1,179 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+type testBackend struct {
+	addr    string
+	calls   atomic.Int32
+	failing atomic.Int32
+	delay   atomic.Int64
+}
+
+func startBackend(t *testing.T) *testBackend {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBackend{addr: lis.Addr().String()}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		b.calls.Add(1)
+		time.Sleep(time.Duration(b.delay.Load()))
+		if b.failing.Add(-1) >= 0 {
+			return nil, status.Error(codes.Unavailable, "overloaded")
+		}
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return b
+}
+
+func dialBackends(t *testing.T, m *Manager, service string, backends ...*testBackend) healthpb.HealthClient {
+	old := Default()
+	SetDefault(m)
+	t.Cleanup(func() { SetDefault(old) })
+
+	r := manual.NewBuilderWithScheme("test")
+	var addrs []resolver.Address
+	for _, b := range backends {
+		addrs = append(addrs, resolver.Address{Addr: b.addr})
+	}
+	r.InitialState(resolver.State{Addresses: addrs})
+
+	conn, err := grpc.NewClient("test:///"+service,
+		grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {}}]}`, BalancerName)),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(m, service)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	m := NewManager(
+		WithBreakerConfig(BreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Minute}),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2}),
+	)
+	backend := startBackend(t)
+	client := dialBackends(t, m, "app", backend)
+	ctx := context.Background()
+
+	// retried once
+	backend.failing.Store(1)
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), backend.calls.Load())
+
+	// the breaker opens after the failures, and rejects the calls without calling the server
+	backend.failing.Store(3)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, int32(5), backend.calls.Load())
+	assert.True(t, isBreakerOpen(err))
+	assert.Equal(t, StateOpen, m.Breaker("app", backend.addr).State())
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	m := NewManager(WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+	interceptor := StreamClientInterceptor(m, "app")
+
+	var calls int
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		calls++
+		if calls == 1 {
+			return nil, status.Error(codes.Unavailable, "overloaded")
+		}
+		return nil, nil
+	}
+	_, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/app/Watch", streamer)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+
+	// the rejections of the open breakers are not retried
+	calls = 0
+	streamer = func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		calls++
+		return nil, breakerOpenError("app")
+	}
+	_, err = interceptor(context.Background(), &grpc.StreamDesc{}, nil, "/app/Watch", streamer)
+	assert.True(t, isBreakerOpen(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestClassifyGrpcCall(t *testing.T) {
+	failure, retryable := classifyGrpcCall(breakerOpenError("app"))
+	assert.False(t, failure)
+	assert.False(t, retryable)
+
+	// the servers returning the same message are still failures
+	failure, retryable = classifyGrpcCall(status.Errorf(codes.Unavailable, "upstream: %v", ErrOpen))
+	assert.True(t, failure)
+	assert.True(t, retryable)
+}
+
+func TestBalancerBreakerByAddress(t *testing.T) {
+	m := NewManager(
+		WithBreakerConfig(BreakerConfig{ConsecutiveFailures: 2, OpenTimeout: time.Minute}),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2}),
+	)
+	bad, good := startBackend(t), startBackend(t)
+	bad.failing.Store(1 << 20)
+	client := dialBackends(t, m, "app", bad, good)
+	ctx := context.Background()
+
+	// wait for both addresses to be ready
+	assert.Eventually(t, func() bool {
+		client.Check(ctx, &healthpb.HealthCheckRequest{})
+		return bad.calls.Load() > 0 && good.calls.Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// only the breaker of the bad address opens, and the calls go to the good one
+	for i := 0; i < 10; i++ {
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, StateOpen, m.Breaker("app", bad.addr).State())
+	assert.Equal(t, StateClosed, m.Breaker("app", good.addr).State())
+	assert.LessOrEqual(t, bad.calls.Load(), int32(2))
+}
+
+func TestBalancerCallerDeadline(t *testing.T) {
+	m := NewManager(WithBreakerConfig(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute}))
+	backend := startBackend(t)
+	client := dialBackends(t, m, "app", backend)
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+
+	// the deadline of the caller is not a failure of the backend
+	backend.delay.Store(int64(100 * time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, StateClosed, m.Breaker("app", backend.addr).State())
+}
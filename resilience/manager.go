@@ -0,0 +1,239 @@
+// Package resilience provides the circuit breakers keyed by service and address, and the
+// retry budgets keyed by service, which keep the callers from hammering a misbehaving backend.
+package resilience
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Classify decides whether the error of a call is a failure of the backend, and whether the call can be retried.
+type Classify func(err error) (failure, retryable bool)
+
+// DefaultClassify treats all errors as retryable failures, except that the caller gives up.
+func DefaultClassify(err error) (failure, retryable bool) {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false, false
+	}
+	return true, true
+}
+
+// classifyCall classifies the error of the call, and the deadline or the cancellation of the caller
+// is not a failure of the backend, so that the callers with tight deadlines do not open the breakers.
+func classifyCall(ctx context.Context, err error, classify Classify) (failure, retryable bool) {
+	if err != nil && ctx.Err() != nil {
+		return false, false
+	}
+	return classify(err)
+}
+
+type key struct {
+	service string
+	address string
+}
+
+// Manager keeps the breakers and the retry budgets.
+type Manager struct {
+	breakerConf BreakerConfig
+	budgetConf  BudgetConfig
+	policy      RetryPolicy
+
+	mu       sync.Mutex
+	breakers map[key]*Breaker
+	budgets  map[string]*RetryBudget
+}
+
+type Option func(m *Manager)
+
+func WithBreakerConfig(conf BreakerConfig) Option {
+	return func(m *Manager) {
+		m.breakerConf = conf
+	}
+}
+
+func WithBudgetConfig(conf BudgetConfig) Option {
+	return func(m *Manager) {
+		m.budgetConf = conf
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(m *Manager) {
+		m.policy = policy
+	}
+}
+
+func NewManager(opts ...Option) *Manager {
+	m := &Manager{
+		breakerConf: DefaultBreakerConfig(),
+		budgetConf:  DefaultBudgetConfig(),
+		policy:      DefaultRetryPolicy(),
+		breakers:    make(map[key]*Breaker),
+		budgets:     make(map[string]*RetryBudget),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Breaker returns the breaker of the address of the service, the address is empty for the whole service.
+func (m *Manager) Breaker(service, address string) *Breaker {
+	k := key{service: service, address: address}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.breakers[k]
+	if !ok {
+		b = newBreaker(service, address, m.breakerConf)
+		m.breakers[k] = b
+	}
+	return b
+}
+
+// Budget returns the retry budget of the service.
+func (m *Manager) Budget(service string) *RetryBudget {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.budgets[service]
+	if !ok {
+		b = newRetryBudget(service, m.budgetConf)
+		m.budgets[service] = b
+	}
+	return b
+}
+
+func (m *Manager) RetryPolicy() RetryPolicy {
+	return m.policy
+}
+
+// Do calls the address of the service through its breaker, and retries the retryable
+// failures within the retry policy and the retry budget of the service.
+// It returns ErrOpen if the breaker rejects the call.
+func (m *Manager) Do(ctx context.Context, service, address string, classify Classify, call func(ctx context.Context) error) error {
+	if classify == nil {
+		classify = DefaultClassify
+	}
+	breaker := m.Breaker(service, address)
+	var rejected error
+	err := m.Retry(ctx, service, func(err error) (failure, retryable bool) {
+		if err == rejected {
+			return false, false
+		}
+		return classify(err)
+	}, func(ctx context.Context) error {
+		done, err := breaker.Allow()
+		if err != nil {
+			rejected = errors.Wrapf(err, "%s %s", service, address)
+			return rejected
+		}
+		err = call(ctx)
+		failure, _ := classifyCall(ctx, err, classify)
+		done(!failure)
+		return err
+	})
+	return err
+}
+
+// Retry calls the service, and retries the retryable failures within the retry policy and
+// the retry budget of the service.
+func (m *Manager) Retry(ctx context.Context, service string, classify Classify, call func(ctx context.Context) error) error {
+	if classify == nil {
+		classify = DefaultClassify
+	}
+	budget := m.Budget(service)
+	budget.Call()
+
+	for attempt := 1; ; attempt++ {
+		err := call(ctx)
+		_, retryable := classifyCall(ctx, err, classify)
+		if err == nil || !retryable || attempt >= m.policy.MaxAttempts || !budget.TryRetry() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(m.policy.Wait(attempt)):
+		}
+	}
+}
+
+type Snapshot struct {
+	Breakers []BreakerStats `json:"breakers"`
+	Budgets  []BudgetStats  `json:"budgets"`
+}
+
+// Snapshot returns the states of all breakers and budgets.
+func (m *Manager) Snapshot() Snapshot {
+	m.mu.Lock()
+	breakers := make([]*Breaker, 0, len(m.breakers))
+	for _, b := range m.breakers {
+		breakers = append(breakers, b)
+	}
+	budgets := make([]*RetryBudget, 0, len(m.budgets))
+	for _, b := range m.budgets {
+		budgets = append(budgets, b)
+	}
+	m.mu.Unlock()
+
+	s := Snapshot{
+		Breakers: make([]BreakerStats, 0, len(breakers)),
+		Budgets:  make([]BudgetStats, 0, len(budgets)),
+	}
+	for _, b := range breakers {
+		s.Breakers = append(s.Breakers, b.Stats())
+	}
+	for _, b := range budgets {
+		s.Budgets = append(s.Budgets, b.Stats())
+	}
+	slices.SortFunc(s.Breakers, func(a, b BreakerStats) int {
+		return cmp.Or(cmp.Compare(a.Service, b.Service), cmp.Compare(a.Address, b.Address))
+	})
+	slices.SortFunc(s.Budgets, func(a, b BudgetStats) int { return cmp.Compare(a.Service, b.Service) })
+	return s
+}
+
+// ServeHTTP responds the snapshot in json for the admin inspection.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Snapshot())
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultManager = NewManager()
+)
+
+func init() {
+	expvar.Publish("resilience", expvar.Func(func() any {
+		return Default().Snapshot()
+	}))
+}
+
+// Default returns the manager used by the dialers.
+func Default() *Manager {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultManager
+}
+
+// SetDefault replaces the manager used by the dialers, it must be called before dialing.
+func SetDefault(m *Manager) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultManager = m
+}
+
+// Handler responds the snapshot of the default manager.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Default().ServeHTTP(w, r)
+	})
+}
@@ -13,7 +13,7 @@ import (
 )
 
 func (vs *VkService) registerIntoConsul(listener net.Listener) {
-	if vs.serviceName == "" || !shared.GetIsUseConsul() {
+	if vs.serviceName == "" || (!shared.GetIsUseConsul() && shared.GetDiscovery() == "") {
 		return
 	}
 
@@ -27,7 +27,7 @@ func (vs *VkService) registerIntoConsul(listener net.Listener) {
 			vs.tags = append(vs.tags, GrpcTag)
 		}
 
-		if err := discover.GetServiceFinder().RegisterServiceWithTags(vs.serviceName, addr, vs.tags); err != nil {
+		if err := discover.GetServiceFinder().RegisterServiceWithOptions(vs.serviceName, addr, vs.tags, vs.registerOptions...); err != nil {
 			lg.Errorf("register consul error: %v", err)
 			return errors.Wrap(err, "Register-Consul")
 		}
@@ -42,6 +42,18 @@ func (vs *VkService) registerIntoConsul(listener net.Listener) {
 
 		lg.Infoc(vs.ctx, logText, logArgs...)
 
+		for _, ep := range vs.endpoints {
+			// the endpoint inherits the options of the service except its id, which would collide
+			opts := append([]discover.RegisterOption{}, vs.registerOptions...)
+			opts = append(opts, discover.WithServiceID(""), discover.WithEndpoint(ep.name))
+			opts = append(opts, ep.opts...)
+			if err := discover.GetServiceFinder().RegisterServiceWithOptions(vs.serviceName, ep.address, ep.tags, opts...); err != nil {
+				lg.Errorf("register endpoint %s error: %v", ep.name, err)
+				return errors.Wrapf(err, "Register-Endpoint-%s", ep.name)
+			}
+			lg.Infoc(vs.ctx, "Registered endpoint into consul success. Service=%v Endpoint=%v Tag=%v", vs.serviceName, ep.name, strings.Join(ep.tags, ","))
+		}
+
 		<-ctx.Done()
 
 		// programe down deregister
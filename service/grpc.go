@@ -12,6 +12,8 @@ import (
 	"github.com/superwhys/venkit/lg/v2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -29,6 +31,10 @@ func (vs *VkService) beginGrpc() {
 		fn(vs.grpcServer)
 	}
 	reflection.Register(vs.grpcServer)
+	// the health service is used by the grpc check of consul
+	if _, exists := vs.grpcServer.GetServiceInfo()[healthpb.Health_ServiceDesc.ServiceName]; !exists {
+		healthpb.RegisterHealthServer(vs.grpcServer, health.NewServer())
+	}
 }
 
 func (vs *VkService) listenGrpcServer(lis net.Listener) mountFn {
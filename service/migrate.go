@@ -0,0 +1,26 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/migrate"
+)
+
+// WithMigrator applies the pending migrations before the service starts serving,
+// the service fails to start if any of them fails.
+func WithMigrator(m *migrate.Migrator) ServiceOption {
+	return func(vs *VkService) {
+		vs.migrators = append(vs.migrators, m)
+	}
+}
+
+func (vs *VkService) runMigrations() error {
+	for _, m := range vs.migrators {
+		done, err := m.Up(vs.ctx)
+		if err != nil {
+			return errors.Wrap(err, "migrate")
+		}
+		lg.Infoc(vs.ctx, "Applied %d migrations", len(done))
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 
 	gwRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/discover"
 	"google.golang.org/grpc"
 )
 
@@ -28,6 +29,29 @@ func WithTag(tag string) ServiceOption {
 	}
 }
 
+// WithRegisterOptions set the options of registering the service, such as the advertised address and the check.
+func WithRegisterOptions(opts ...discover.RegisterOption) ServiceOption {
+	return func(vs *VkService) {
+		vs.registerOptions = append(vs.registerOptions, opts...)
+	}
+}
+
+type endpoint struct {
+	name    string
+	address string
+	tags    []string
+	opts    []discover.RegisterOption
+}
+
+// WithEndpoint registers an extra endpoint of the service along with it, such as an admin port
+// served by yourself. The endpoint is registered with its own address and tags, and with the
+// register options of the service except the service id, which are overridden by opts.
+func WithEndpoint(name, address string, tags []string, opts ...discover.RegisterOption) ServiceOption {
+	return func(vs *VkService) {
+		vs.endpoints = append(vs.endpoints, endpoint{name: name, address: address, tags: tags, opts: opts})
+	}
+}
+
 func WithHTTPCORS() ServiceOption {
 	return func(vs *VkService) {
 		vs.httpCORS = true
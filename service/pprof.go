@@ -1,6 +1,12 @@
 package service
 
-import "net/http/pprof"
+import (
+	"expvar"
+	"net/http/pprof"
+
+	"github.com/superwhys/venkit/v2/dialer"
+	"github.com/superwhys/venkit/v2/resilience"
+)
 
 func WithPprof() ServiceOption {
 	return func(vk *VkService) {
@@ -13,5 +19,8 @@ func WithPprof() ServiceOption {
 		vk.httpMux.Handle("/debug/pprof/heap", pprof.Handler("heap"))
 		vk.httpMux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 		vk.httpMux.Handle("/debug/pprof/block", pprof.Handler("block"))
+		vk.httpMux.Handle("/debug/vars", expvar.Handler())
+		vk.httpMux.Handle("/debug/resilience", resilience.Handler())
+		vk.httpMux.Handle("/debug/connections", dialer.DefaultRegistry())
 	}
 }
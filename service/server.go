@@ -17,6 +17,9 @@ import (
 	"github.com/rs/cors"
 	"github.com/soheilhy/cmux"
 	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/dialer"
+	"github.com/superwhys/venkit/v2/discover"
+	"github.com/superwhys/venkit/v2/migrate"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 )
@@ -39,9 +42,11 @@ type cronMountFn struct {
 }
 
 type VkService struct {
-	ctx         context.Context
-	serviceName string
-	tags        []string
+	ctx             context.Context
+	serviceName     string
+	tags            []string
+	registerOptions []discover.RegisterOption
+	endpoints       []endpoint
 
 	listener net.Listener
 	cmux     cmux.CMux
@@ -68,6 +73,8 @@ type VkService struct {
 	gatewayHandlers            []gatewayFunc
 	gatewayMiddlewaresHandlers [][]gatewatMiddlewareHandler
 
+	migrators []*migrate.Migrator
+
 	workers    []worker
 	mounts     []mountFn
 	cronMounts []cronMountFn
@@ -190,7 +197,19 @@ func (vs *VkService) runFinalMount() error {
 		})
 	}
 
-	return grp.Wait()
+	err := grp.Wait()
+	// the connections are closed after all the workers stop using them
+	vs.closeConnections()
+	return err
+}
+
+func (vs *VkService) closeConnections() {
+	if err := dialer.DefaultRegistry().Close(); err != nil {
+		lg.Errorc(vs.ctx, "Close connections error: %v", err)
+	}
+	if err := dialer.DefaultGrpcPool().Close(); err != nil {
+		lg.Errorc(vs.ctx, "Close grpc connections error: %v", err)
+	}
 }
 
 // waitContext used to detects whether ctx is disabled by other workers
@@ -317,6 +336,11 @@ func (vs *VkService) loadServiceName() {
 }
 
 func (vs *VkService) serve(listener net.Listener) error {
+	if err := vs.runMigrations(); err != nil {
+		listener.Close()
+		return err
+	}
+
 	vs.mounts = []mountFn{
 		vs.notiKill(),
 	}
@@ -0,0 +1,277 @@
+package vflags
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+var (
+	commands      = make(map[string]*Command)
+	activeCommand *Command
+)
+
+// Command is a subcommand of a multi-command binary.
+// Every command has its own flags and struct configs, which are only
+// parsed when the command is executed, and shares the global flags
+// such as `--debug`, `--config` and consul options.
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(ctx context.Context) error
+
+	flags     *pflag.FlagSet
+	nestedKey map[string]interface{}
+	defaults  map[string]any
+	required  []string
+	structs   map[string]structDefault
+}
+
+// NewCommand creates a command and registers it, which is run by Execute.
+// Set Run of the command in init or main, so that Run can refer to the flags of the command.
+func NewCommand(name, usage string) *Command {
+	if _, exists := commands[name]; exists {
+		lg.Fatalf("Command %v already exists", name)
+	}
+
+	c := &Command{
+		Name:      name,
+		Usage:     usage,
+		flags:     pflag.NewFlagSet(name, pflag.ContinueOnError),
+		nestedKey: make(map[string]interface{}),
+		defaults:  make(map[string]any),
+		structs:   make(map[string]structDefault),
+	}
+	commands[name] = c
+	return c
+}
+
+// FlagSet returns the flags of the command.
+func (c *Command) FlagSet() *pflag.FlagSet {
+	return c.flags
+}
+
+// Args returns the positional arguments after the command name.
+func (c *Command) Args() []string {
+	args := pflag.Args()
+	for i, arg := range args {
+		if arg == c.Name {
+			return append(args[:i:i], args[i+1:]...)
+		}
+	}
+	return args
+}
+
+func (c *Command) String(key, defaultVal, usage string) StringGetter {
+	c.flags.String(key, defaultVal, usage)
+	c.defaults[key] = defaultVal
+	return func() string {
+		return v.GetString(key)
+	}
+}
+
+func (c *Command) StringRequired(key, usage string) StringGetter {
+	c.required = append(c.required, key)
+	return c.String(key, "", usage)
+}
+
+func (c *Command) Bool(key string, defaultVal bool, usage string) BoolGetter {
+	c.flags.Bool(key, defaultVal, usage)
+	c.defaults[key] = defaultVal
+	return func() bool {
+		return v.GetBool(key)
+	}
+}
+
+func (c *Command) Int(key string, defaultVal int, usage string) IntGetter {
+	c.flags.Int(key, defaultVal, usage)
+	c.defaults[key] = defaultVal
+	return func() int {
+		return v.GetInt(key)
+	}
+}
+
+func (c *Command) IntRequired(key, usage string) IntGetter {
+	c.required = append(c.required, key)
+	return c.Int(key, 0, usage)
+}
+
+func (c *Command) Float64(key string, defaultVal float64, usage string) Float64Getter {
+	c.flags.Float64(key, defaultVal, usage)
+	c.defaults[key] = defaultVal
+	return func() float64 {
+		return v.GetFloat64(key)
+	}
+}
+
+func (c *Command) Duration(key string, defaultVal time.Duration, usage string) func() time.Duration {
+	c.flags.Duration(key, defaultVal, usage)
+	c.defaults[key] = defaultVal
+	return func() time.Duration {
+		return v.GetDuration(key)
+	}
+}
+
+func (c *Command) StringSlice(key string, defaultVal []string, usage string) StringSliceGetter {
+	c.flags.StringSlice(key, defaultVal, usage)
+	c.defaults[key] = defaultVal
+	return func() []string {
+		return v.GetStringSlice(key)
+	}
+}
+
+// Struct is the same as the global Struct, but the config only takes effect when the command is executed.
+func (c *Command) Struct(key string, defaultVal any, usage string) func(out any) error {
+	err := setPFlagRecursively(c.flags, c.nestedKey, key, defaultVal)
+	if err != nil {
+		if !errors.Is(err, ErrNotStruct) {
+			lg.Errorc(lg.Ctx, "Bind struct flags error: %v", err)
+			lg.PanicError(err)
+		}
+		lg.Debugc(lg.Ctx, "it won't display `%v` desciption with not struct default val", key)
+	}
+	c.defaults[key] = defaultVal
+	c.structs[key] = structDefault{val: defaultVal, usage: usage}
+	return structGetter(key)
+}
+
+// activate merges the flags and configs of the command into the global ones.
+func (c *Command) activate() {
+	activeCommand = c
+	pflag.CommandLine.AddFlagSet(c.flags)
+	for key, val := range c.defaults {
+		v.SetDefault(key, val)
+	}
+	for key, ptr := range c.nestedKey {
+		nestedKey[key] = ptr
+	}
+	for key, d := range c.structs {
+		structDefaults[key] = d
+	}
+	requiredFlags = append(requiredFlags, c.required...)
+}
+
+// ActiveCommand returns the command executed by Execute.
+func ActiveCommand() *Command {
+	return activeCommand
+}
+
+// Execute selects the command by the first positional argument, parses the
+// global and command flags the same as Parse, and runs the command.
+// The context passed to Run is canceled when the process receives SIGINT or SIGTERM.
+func Execute(opts ...VflagOptionFunc) {
+	o := packOption(opts...)
+	initVFlags(o)
+
+	globalUsage := pflag.CommandLine.FlagUsages()
+	name := commandName(os.Args[1:])
+	c, ok := commands[name]
+	switch {
+	case name == "help":
+		if c, ok := commands[firstArg(os.Args[1:], name)]; ok {
+			printCommandUsage(c, globalUsage)
+		} else {
+			printCommands(globalUsage)
+		}
+		os.Exit(0)
+	case !ok || c.Run == nil:
+		if name != "" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		}
+		printCommands(globalUsage)
+		os.Exit(2)
+	}
+
+	c.activate()
+	pflag.CommandLine.Usage = func() {
+		printCommandUsage(c, globalUsage)
+	}
+	bindVFlags()
+	parse(o)
+
+	ctx, cancel := signal.NotifyContext(lg.Ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := c.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		lg.Errorc(ctx, "Run command %v error: %v", c.Name, err)
+		cancel()
+		os.Exit(1)
+	}
+}
+
+// commandName returns the first positional argument, skipping the values of global flags.
+func commandName(args []string) string {
+	return firstArg(args, "")
+}
+
+func firstArg(args []string, after string) string {
+	skip := after != ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if skip && arg == after {
+				skip = false
+				continue
+			}
+			if !skip {
+				return arg
+			}
+			continue
+		}
+		if strings.Contains(arg, "=") {
+			continue
+		}
+
+		var flag *pflag.Flag
+		if strings.HasPrefix(arg, "--") {
+			flag = pflag.CommandLine.Lookup(arg[2:])
+		} else if len(arg) == 2 {
+			flag = pflag.CommandLine.ShorthandLookup(arg[1:])
+		}
+		// the next argument is the value of the flag
+		if flag != nil && flag.NoOptDefVal == "" {
+			i++
+		}
+	}
+	return ""
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+func printCommands(globalUsage string) {
+	names := make([]string, 0, len(commands))
+	width := 0
+	for name := range commands {
+		names = append(names, name)
+		width = max(width, len(name))
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: %v <command> [flags]\n\nCommands:\n", programName())
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-*v  %v\n", width, name, commands[name].Usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nGlobal Flags:\n%v\nUse \"%v help <command>\" for more information about a command.\n", globalUsage, programName())
+}
+
+func printCommandUsage(c *Command, globalUsage string) {
+	fmt.Fprintf(os.Stderr, "Usage: %v %v [flags]\n\n%v\n", programName(), c.Name, c.Usage)
+	if usage := c.flags.FlagUsages(); usage != "" {
+		fmt.Fprintf(os.Stderr, "\nFlags:\n%v", usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nGlobal Flags:\n%v", globalUsage)
+}
@@ -0,0 +1,76 @@
+package vflags
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+type commandConfig struct {
+	Addr string
+	Port int
+}
+
+func TestCommandName(t *testing.T) {
+	pflag.StringP("cmdtest-config", "c", "", "config file")
+	pflag.Bool("cmdtest-debug", false, "debug mode")
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no-args", nil, ""},
+		{"only-flags", []string{"--cmdtest-debug"}, ""},
+		{"command-first", []string{"serve", "--port=80"}, "serve"},
+		{"bool-flag-first", []string{"--cmdtest-debug", "serve"}, "serve"},
+		{"value-flag-first", []string{"--cmdtest-config", "a.yaml", "serve"}, "serve"},
+		{"shorthand-flag-first", []string{"-c", "a.yaml", "serve"}, "serve"},
+		{"value-with-equal", []string{"--cmdtest-config=a.yaml", "serve"}, "serve"},
+		{"after-terminator", []string{"--", "serve"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, commandName(tt.args))
+		})
+	}
+
+	assert.Equal(t, "serve", firstArg([]string{"--cmdtest-debug", "help", "serve"}, "help"))
+}
+
+func TestCommandActivate(t *testing.T) {
+	resetConfigState()
+
+	var ran bool
+	serve := NewCommand("cmdtest-serve", "Start the server")
+	serve.Run = func(ctx context.Context) error {
+		ran = true
+		return nil
+	}
+	name := serve.String("cmdtest-name", "app", "name of server")
+	serveConf := serve.Struct("cmdtest-serve", &commandConfig{Addr: "localhost", Port: 80}, "server config")
+
+	other := NewCommand("cmdtest-other", "Other command")
+	otherName := other.String("cmdtest-other-name", "other", "name of other")
+
+	serve.activate()
+	bindVFlags()
+	assert.Nil(t, pflag.CommandLine.Parse([]string{"cmdtest-serve", "--cmdtest-name=venkit", "extra"}))
+	injectNestedKey()
+
+	assert.Equal(t, serve, ActiveCommand())
+	assert.Equal(t, "venkit", name())
+	assert.Equal(t, []string{"extra"}, serve.Args())
+	assert.Nil(t, pflag.Lookup("cmdtest-other-name"))
+	assert.Equal(t, "", otherName())
+
+	conf := &commandConfig{}
+	assert.Nil(t, serveConf(conf))
+	assert.Equal(t, &commandConfig{Addr: "localhost", Port: 80}, conf)
+	assert.NotNil(t, pflag.Lookup("cmdtest-serve.Port"))
+
+	assert.Nil(t, serve.Run(context.Background()))
+	assert.True(t, ran)
+}
@@ -1,73 +1,110 @@
 package vflags
 
 import (
-	"bytes"
-	"fmt"
+	"context"
+	"net/url"
+	"time"
 
 	"github.com/hashicorp/consul/api"
-	"github.com/hashicorp/consul/api/watch"
 	"github.com/superwhys/venkit/lg/v2"
 	"github.com/superwhys/venkit/v2/internal/shared"
 )
 
-func watchCnosulConfigChange(path string) {
-	plan, err := watch.Parse(map[string]interface{}{"type": "key", "key": path})
-	lg.PanicError(err)
+// consulProvider reads the remote config from consul KV, and watches it by blocking queries.
+type consulProvider struct {
+	client *api.Client
+}
 
-	first := true
-	var currentVal []byte
+// newConsulProvider creates the consul provider with the consul config, which can be
+// overridden by the url, e.g. consul://127.0.0.1:8500?token=xxx&dc=dc1&ns=default
+// The url without host, e.g. consul://?dc=dc1, keeps the address of the consul config,
+// which falls back to the consulAddr flag.
+func newConsulProvider(u *url.URL) (RemoteConfigProvider, error) {
+	conf, err := getConsulConfig()
+	if err != nil {
+		return nil, err
+	}
+	if u.Host != "" {
+		conf.Address = u.Host
+	} else if conf.Address == "" {
+		conf.Address = shared.GetConsulAddress()
+	}
+	query := u.Query()
+	if token := query.Get("token"); token != "" {
+		conf.Token = token
+	}
+	if dc := query.Get("dc"); dc != "" {
+		conf.Datacenter = dc
+	}
+	if ns := query.Get("ns"); ns != "" {
+		conf.Namespace = ns
+	}
+	if query.Get("tls") == "true" {
+		conf.TLS.Enable = true
+	}
 
-	plan.Handler = func(u uint64, data interface{}) {
-		kvPair, ok := data.(*api.KVPair)
-		if !ok {
-			lg.Warnc(lg.Ctx, "Failed to watch remote config.")
-			return
-		}
+	client, err := conf.NewApiClient()
+	if err != nil {
+		return nil, err
+	}
+	return &consulProvider{client: client}, nil
+}
 
-		if first {
-			first = false
-			currentVal = kvPair.Value
-			return
-		}
+func (p *consulProvider) Get(ctx context.Context, path string) ([]byte, error) {
+	pair, _, err := p.client.KV().Get(path, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, ErrConfigNotFound
+	}
+	return pair.Value, nil
+}
 
-		if string(kvPair.Value) == string(currentVal) {
-			lg.Debugc(lg.Ctx, "Remote config not change.")
-			return
+func (p *consulProvider) Watch(ctx context.Context, path string, onChange func([]byte)) error {
+	var (
+		index   uint64
+		current []byte
+		first   = true
+		b       = newBackoff()
+	)
+	for {
+		opts := (&api.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Minute}).WithContext(ctx)
+		pair, meta, err := p.client.KV().Get(path, opts)
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		lg.Debugc(lg.Ctx, "Remote config change")
-
-		if err := v.ReadConfig(bytes.NewBuffer(kvPair.Value)); err != nil {
-			lg.Errorc(lg.Ctx, "viper read config error: %v", err)
+		if err != nil {
+			remoteConfigErrors.Add(1)
+			wait := b.next()
+			lg.Warnc(ctx, "Watch consul config -> %v, error: %v, retry after %v", path, err, wait)
+			if err := sleepContext(ctx, wait); err != nil {
+				return err
+			}
+			continue
 		}
+		b.reset()
 
-		if killWhileChange() {
-			killToRestartServer()
-			return
+		// reset the index if it goes backwards, such as consul restarts
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
 		}
-		structConfReload()
-	}
 
-	plan.Run(shared.GetConsulAddress())
-}
-
-func readConsulConfig() string {
-	// use remote config
-	serviceName := getServiceNameWithoutTag()
-	serviceTag := getServiceTag()
-	path := fmt.Sprintf("/etc/configs/%v/%v.yaml", serviceName, serviceTag)
-	v.AddRemoteProvider("consul", shared.GetConsulAddress(), path)
-	v.SetConfigType("yaml")
-	if err := v.ReadRemoteConfig(); err != nil {
-		lg.Errorc(lg.Ctx, "Fetch remote config -> %v:%v, error: %v", serviceName, serviceTag, err)
-		lg.Fatal("Failed to read remote config.")
+		if pair == nil {
+			continue
+		}
+		if first {
+			first = false
+			current = pair.Value
+			continue
+		}
+		if string(pair.Value) == string(current) {
+			lg.Debugc(ctx, "Remote config not change.")
+			continue
+		}
+		current = pair.Value
+		onChange(pair.Value)
 	}
-	return path
-}
-
-// killToRestartServer will kill the server first.
-// If the service runs with docker and is set to start automatically,
-// it can implement configuration updates and refresh the service
-func killToRestartServer() {
-	lg.Infoc(lg.Ctx, "Remote config changed. Shutting down...")
-	kill()
 }
@@ -0,0 +1,124 @@
+package vflags
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/superwhys/venkit/v2/discover"
+	"github.com/superwhys/venkit/v2/internal/consultest"
+)
+
+func TestConsulConfig(t *testing.T) {
+	resetConfigState()
+	defer func() {
+		consulConfig = nil
+		delete(structDefaults, "consul")
+	}()
+
+	declareConsulConfig()
+	assert.Nil(t, pflag.CommandLine.Set("consul.token", "secret"))
+	assert.Nil(t, pflag.CommandLine.Set("consul.tls.enable", "true"))
+	injectNestedKey()
+
+	conf, err := getConsulConfig()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "secret", conf.Token)
+	assert.True(t, conf.TLS.Enable)
+	// the decoded configs are not bound to be overwritten on reload
+	_, bound := keyStructMap["consul"]
+	assert.False(t, bound)
+
+	apiConf := conf.ApiConfig()
+	assert.Equal(t, "https", apiConf.Scheme)
+	assert.Equal(t, "secret", apiConf.Token)
+
+	u, _ := url.Parse("consul://127.0.0.1:8500?token=other&dc=dc2")
+	provider, err := newConsulProvider(u)
+	assert.Nil(t, err)
+	assert.NotNil(t, provider)
+
+	// the errors of creating client are returned
+	assert.Nil(t, pflag.CommandLine.Set("consul.tls.caFile", "/not-exists/ca.pem"))
+	injectNestedKey()
+	_, err = newConsulProvider(u)
+	assert.ErrorContains(t, err, "CA File")
+}
+
+func TestConsulProviderKeepsAddress(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.Put("configs/app.yaml", []byte("name: a\n"))
+
+	consulConfig = func(out any) error {
+		out.(*discover.ConsulConfig).Address = "http://" + srv.Addr()
+		return nil
+	}
+	defer func() { consulConfig = nil }()
+
+	// the url without host keeps the address of the consul config
+	provider, err := NewRemoteProvider("consul://?dc=dc1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	data, err := provider.Get(context.Background(), "configs/app.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, "name: a\n", string(data))
+}
+
+func TestConsulProviderReload(t *testing.T) {
+	resetConfigState()
+	remoteConfigPath = func() string { return "configs/{{.Service}}.yaml" }
+	remoteConfigCache = func() string { return "" }
+
+	srv := consultest.NewServer()
+	defer srv.Close()
+	provider, err := NewRemoteProvider("consul://" + srv.Addr())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = provider.Get(context.Background(), "configs/.yaml")
+	assert.ErrorIs(t, err, ErrConfigNotFound)
+
+	srv.Put("configs/.yaml", []byte("remote:\n  name: a\n"))
+	path, fromSnapshot := readRemoteConfig(provider, "consul://")
+	assert.Equal(t, "configs/.yaml", path)
+	assert.False(t, fromSnapshot)
+	assert.Equal(t, "a", v.GetString("remote.name"))
+
+	changed := make(chan []string, 1)
+	OnChange(func(keys []string) {
+		changed <- keys
+	})
+	loadWatchers()
+	waitChange := func(want string) {
+		select {
+		case keys := <-changed:
+			assert.Equal(t, []string{"remote.name"}, keys)
+			assert.Equal(t, want, v.GetString("remote.name"))
+		case <-time.After(3 * time.Second):
+			t.Fatal("config change not watched")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchRemoteConfig(ctx, provider, path)
+	time.Sleep(100 * time.Millisecond)
+
+	srv.Put(path, []byte("remote:\n  name: b\n"))
+	waitChange("b")
+
+	// the watch recovers after consul comes back
+	srv.SetFailing(true)
+	time.Sleep(100 * time.Millisecond)
+	srv.Put(path, []byte("remote:\n  name: c\n"))
+	srv.SetFailing(false)
+	waitChange("c")
+}
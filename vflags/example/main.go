@@ -20,6 +20,9 @@ func (c *Config) Reload() {
 
 var (
 	confFlags = vflags.Struct("testConfig", &Config{}, "test config")
+	watchConf = vflags.Watch[Config]("testConfig", func(old, new Config) {
+		lg.Infof("config name change from %v to %v", old.Name, new.Name)
+	})
 )
 
 func main() {
@@ -35,6 +38,7 @@ func main() {
 		service.WithWorker(func(ctx context.Context) error {
 			for {
 				lg.Info(lg.Jsonify(config))
+				lg.Info(lg.Jsonify(watchConf()))
 
 				time.Sleep(5 * time.Second)
 			}
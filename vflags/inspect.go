@@ -0,0 +1,330 @@
+package vflags
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const redactedValue = "******"
+
+var (
+	printConfig   StringGetter
+	explainConfig StringGetter
+	configSchema  BoolGetter
+
+	// configSource describes where the config file content comes from, e.g. `file config.yaml`.
+	configSource string
+
+	structDefaults = make(map[string]structDefault)
+	secretKeys     = make(map[string]bool)
+	secretWords    = []string{"password", "passwd", "pwd", "secret", "token", "apikey", "accesskey", "privatekey", "credential"}
+)
+
+type structDefault struct {
+	val   any
+	usage string
+}
+
+func declareInspectFlags() {
+	printConfig = String("print-config", "", "Print the effective config in yaml or json with secrets redacted, then exit.")
+	pflag.Lookup("print-config").NoOptDefVal = "yaml"
+	explainConfig = String("explain-config", "", "Explain which source (default, file, consul, flag, env) set the config key, then exit.")
+	configSchema = Bool("config-schema", false, "Print the JSON Schema of all struct configs, then exit.")
+}
+
+// handleInspectFlags handles the inspect flags and exit if any of them is set.
+func handleInspectFlags() {
+	var err error
+	switch {
+	case printConfig() != "":
+		err = writeConfig(os.Stdout, printConfig())
+	case explainConfig() != "":
+		err = writeExplain(os.Stdout, explainConfig())
+	case configSchema():
+		err = writeSchema(os.Stdout)
+	default:
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// registerSecretKey marks key as secret, its value will be redacted in print-config.
+func registerSecretKey(key string) {
+	secretKeys[strings.ToLower(key)] = true
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if secretKeys[key] {
+		return true
+	}
+
+	name := key[strings.LastIndex(key, ".")+1:]
+	name = strings.NewReplacer("_", "", "-", "").Replace(name)
+	for _, word := range secretWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactSettings(prefix string, settings map[string]any) map[string]any {
+	out := make(map[string]any, len(settings))
+	for k, val := range settings {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch {
+		case isSecretKey(key) && !isZero(val):
+			out[k] = redactedValue
+		case reflect.TypeOf(val) == reflect.TypeOf(map[string]any{}):
+			out[k] = redactSettings(key, val.(map[string]any))
+		default:
+			out[k] = val
+		}
+	}
+	return out
+}
+
+// effectiveSettings returns all settings of viper, with every struct config
+// replaced by the value decoded from it, which is exactly what the service reads.
+func effectiveSettings() map[string]any {
+	settings := v.AllSettings()
+	for key, d := range structDefaults {
+		t := reflect.TypeOf(d.val)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+
+		out := reflect.New(t)
+		if err := v.UnmarshalKey(key, out.Interface()); err != nil {
+			continue
+		}
+
+		path := strings.Split(strings.ToLower(key), ".")
+		m := settings
+		for _, p := range path[:len(path)-1] {
+			sub, ok := m[p].(map[string]any)
+			if !ok {
+				sub = make(map[string]any)
+				m[p] = sub
+			}
+			m = sub
+		}
+		m[path[len(path)-1]] = structToMap(out.Elem())
+	}
+	return settings
+}
+
+func structToMap(val reflect.Value) map[string]any {
+	m := make(map[string]any)
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		name := fieldName(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		fv := val.Field(i)
+		for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		switch {
+		case fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Time{}):
+			m[strings.ToLower(name)] = structToMap(fv)
+		case fv.Type() == reflect.TypeOf(time.Duration(0)):
+			m[strings.ToLower(name)] = time.Duration(fv.Int()).String()
+		default:
+			m[strings.ToLower(name)] = fv.Interface()
+		}
+	}
+	return m
+}
+
+func writeConfig(w io.Writer, format string) error {
+	settings := redactSettings("", effectiveSettings())
+
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		return enc.Encode(settings)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(settings)
+	default:
+		return errors.Errorf("unsupport config format: %v", format)
+	}
+}
+
+// lookupFlag finds the flag of key, flags of struct config keep the case of field names.
+func lookupFlag(key string) *pflag.Flag {
+	var found *pflag.Flag
+	pflag.CommandLine.VisitAll(func(f *pflag.Flag) {
+		if strings.EqualFold(f.Name, key) {
+			found = f
+		}
+	})
+	return found
+}
+
+// explainKey returns which source set the value of key.
+// The sources are checked in the same priority as viper.
+func explainKey(key string, val any) string {
+	if flag := lookupFlag(key); flag != nil && flag.Changed {
+		return fmt.Sprintf("flag --%v", flag.Name)
+	}
+
+	envKey := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if env, ok := os.LookupEnv(envKey); ok && env == fmt.Sprint(val) {
+		return fmt.Sprintf("env $%v", envKey)
+	}
+
+	if v.InConfig(key) {
+		if configSource == "" {
+			return "config"
+		}
+		return configSource
+	}
+
+	if val == nil {
+		return "unset"
+	}
+	return "default"
+}
+
+func writeExplain(w io.Writer, key string) error {
+	key = strings.ToLower(key)
+	settings := flattenSettings(effectiveSettings())
+
+	var keys []string
+	for k := range settings {
+		if k == key || strings.HasPrefix(k, key+".") {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return errors.Errorf("config key %v not found", key)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		val := settings[k]
+		source := explainKey(k, val)
+		if isSecretKey(k) && !isZero(val) {
+			val = redactedValue
+		}
+		if _, err := fmt.Fprintf(w, "%v = %v (from %v)\n", k, val, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSchema(w io.Writer) error {
+	properties := make(map[string]any, len(structDefaults))
+	for key, d := range structDefaults {
+		s := typeSchema(reflect.TypeOf(d.val), reflect.ValueOf(d.val))
+		if d.usage != "" {
+			s["description"] = d.usage
+		}
+		properties[strings.ToLower(key)] = s
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"type":       "object",
+		"properties": properties,
+	})
+}
+
+// typeSchema generates the JSON Schema of t, val is used as the default value if valid.
+func typeSchema(t reflect.Type, val reflect.Value) map[string]any {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if val.IsValid() && !val.IsNil() {
+			val = val.Elem()
+		} else {
+			val = reflect.Value{}
+		}
+	}
+
+	s := make(map[string]any)
+	switch t.Kind() {
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t == reflect.TypeOf(time.Duration(0)) {
+			s["type"] = "string"
+			s["format"] = "duration"
+			if val.IsValid() {
+				s["default"] = time.Duration(val.Int()).String()
+			}
+			return s
+		}
+		s["type"] = "integer"
+	case reflect.Float32, reflect.Float64:
+		s["type"] = "number"
+	case reflect.Slice, reflect.Array:
+		s["type"] = "array"
+		s["items"] = typeSchema(t.Elem(), reflect.Value{})
+	case reflect.Map:
+		s["type"] = "object"
+		s["additionalProperties"] = typeSchema(t.Elem(), reflect.Value{})
+	case reflect.Struct:
+		s["type"] = "object"
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := fieldName(field)
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+
+			var fv reflect.Value
+			if val.IsValid() {
+				fv = val.Field(i)
+			}
+			fs := typeSchema(field.Type, fv)
+			if usage := fieldUsage(field); usage != "" {
+				fs["description"] = usage
+			}
+			// the keys of viper are case insensitive and lowercased
+			properties[strings.ToLower(name)] = fs
+		}
+		s["properties"] = properties
+		return s
+	}
+
+	if val.IsValid() && val.CanInterface() && !val.IsZero() {
+		s["default"] = val.Interface()
+	}
+	return s
+}
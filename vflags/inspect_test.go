@@ -0,0 +1,69 @@
+package vflags
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type inspectConfig struct {
+	Host     string        `desc:"server host"`
+	Password string        `json:"password"`
+	Auth     string        `secret:"true"`
+	Timeout  time.Duration `usage:"dial timeout"`
+	Tags     []string
+}
+
+func TestInspectConfig(t *testing.T) {
+	resetConfigState()
+	Struct("inspect", &inspectConfig{Host: "localhost", Timeout: time.Second}, "inspect config")
+	Struct("inspectDefault", &inspectConfig{Host: "localhost"}, "inspect default config")
+	v.SetConfigType("yaml")
+	assert.Nil(t, v.MergeConfig(bytes.NewBufferString("inspect:\n  password: 123\n  auth: abc\n")))
+	configSource = "file test.yaml"
+
+	t.Run("print-config", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, writeConfig(buf, "json"))
+
+		var settings map[string]any
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &settings))
+		inspect := settings["inspect"].(map[string]any)
+		assert.Equal(t, "localhost", settings["inspectdefault"].(map[string]any)["host"])
+		assert.Equal(t, redactedValue, inspect["password"])
+		assert.Equal(t, redactedValue, inspect["auth"])
+	})
+
+	t.Run("explain-config", func(t *testing.T) {
+		assert.Equal(t, "default", explainKey("inspect.host", "localhost"))
+		assert.Equal(t, "file test.yaml", explainKey("inspect.password", "123"))
+
+		t.Setenv("INSPECT_HOST", "localhost")
+		assert.Equal(t, "env $INSPECT_HOST", explainKey("inspect.host", "localhost"))
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, writeExplain(buf, "inspect.password"))
+		assert.Equal(t, "inspect.password = ****** (from file test.yaml)\n", buf.String())
+	})
+
+	t.Run("config-schema", func(t *testing.T) {
+		d := structDefaults["inspect"]
+		s := typeSchema(reflect.TypeOf(d.val), reflect.ValueOf(d.val))
+		properties := s["properties"].(map[string]any)
+		assert.Equal(t, "object", s["type"])
+		assert.Equal(t, map[string]any{"type": "string", "description": "server host", "default": "localhost"}, properties["host"])
+		assert.Equal(t, map[string]any{"type": "string", "format": "duration", "description": "dial timeout", "default": "1s"}, properties["timeout"])
+		assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"type": "string"}}, properties["tags"])
+		assert.Contains(t, properties, "password")
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, writeSchema(buf))
+		var schema map[string]any
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &schema))
+		assert.Contains(t, schema["properties"], "inspectdefault")
+	})
+}
@@ -0,0 +1,303 @@
+package vflags
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+var (
+	reloadMu sync.Mutex
+	// lastGoodConfig is the raw config content that was last applied successfully.
+	// It is used to roll back viper when a new config fails validation.
+	lastGoodConfig []byte
+	// lastSettings is the flattened settings of the last applied config.
+	lastSettings map[string]any
+
+	structMu      sync.RWMutex
+	keyWatchers   = make(map[string][]structBinding)
+	changeNotifys []func(changed []string)
+	parsed        bool
+)
+
+// structBinding is the target of a struct config key.
+// A reload first decodes a fresh value for every binding, and only
+// swaps them in once all of them decode and validate successfully.
+type structBinding interface {
+	decode(key string) (any, error)
+	swap(val any)
+}
+
+// pointerBinding keeps the out pointer passed to the getter returned by Struct.
+type pointerBinding struct {
+	out any
+}
+
+func (b *pointerBinding) decode(key string) (any, error) {
+	nv := reflect.New(reflect.TypeOf(b.out).Elem()).Interface()
+	if err := unmarshalStruct(key, nv); err != nil {
+		return nil, err
+	}
+	return nv, nil
+}
+
+// swap overwrites the out without any lock, since its readers do not hold one.
+// It is kept for the legacy configs, and Watch should be used for hot reload.
+func (b *pointerBinding) swap(val any) {
+	reflect.ValueOf(b.out).Elem().Set(reflect.ValueOf(val).Elem())
+	if r, ok := b.out.(HasReloader); ok {
+		r.Reload()
+	}
+}
+
+// watchBinding holds the latest value of a key subscribed by Watch.
+type watchBinding[T any] struct {
+	current atomic.Pointer[T]
+	fn      func(old, new T)
+}
+
+func (b *watchBinding[T]) decode(key string) (any, error) {
+	nv := new(T)
+	if err := unmarshalStruct(key, nv); err != nil {
+		return nil, err
+	}
+	return nv, nil
+}
+
+func (b *watchBinding[T]) swap(val any) {
+	nv := val.(*T)
+	old := b.current.Swap(nv)
+	if old != nil && b.fn != nil {
+		b.fn(*old, *nv)
+	}
+}
+
+func (b *watchBinding[T]) load() T {
+	if p := b.current.Load(); p != nil {
+		return *p
+	}
+	var zero T
+	return zero
+}
+
+// Watch subscribes to the struct config of key, which should be registered by Struct.
+// The returned getter always returns the latest validated value, it is safe to call
+// concurrently with config reloads since every reload builds a new value and swaps it atomically.
+// fn will be called with the old and new value after each successful reload of key, it can be nil.
+func Watch[T any](key string, fn func(old, new T)) func() T {
+	b := &watchBinding[T]{fn: fn}
+
+	structMu.Lock()
+	keyWatchers[key] = append(keyWatchers[key], b)
+	loadNow := parsed
+	structMu.Unlock()
+
+	if loadNow {
+		val, err := b.decode(key)
+		if err != nil {
+			lg.PanicError(errors.Wrapf(err, "watch %v", key))
+		}
+		b.swap(val)
+	}
+
+	return b.load
+}
+
+// OnChange registers fn to be called with the changed keys after each successful config reload.
+func OnChange(fn func(changed []string)) {
+	structMu.Lock()
+	defer structMu.Unlock()
+	changeNotifys = append(changeNotifys, fn)
+}
+
+func unmarshalStruct(key string, out any) error {
+	if err := v.UnmarshalKey(key, out); err != nil {
+		return err
+	}
+
+	if err := structCheck(out); err != nil {
+		return errors.Wrap(err, "check")
+	}
+
+	return nil
+}
+
+// loadWatchers decodes the initial value of all keys subscribed before Parse.
+func loadWatchers() {
+	structMu.Lock()
+	parsed = true
+	watchers := make(map[string][]structBinding, len(keyWatchers))
+	for key, bs := range keyWatchers {
+		watchers[key] = append([]structBinding(nil), bs...)
+	}
+	structMu.Unlock()
+
+	for key, bs := range watchers {
+		for _, b := range bs {
+			val, err := b.decode(key)
+			if err != nil {
+				lg.Fatalf("Load struct config %v error: %v", key, err)
+			}
+			b.swap(val)
+		}
+	}
+
+	reloadMu.Lock()
+	lastSettings = flattenSettings(v.AllSettings())
+	reloadMu.Unlock()
+}
+
+// rememberConfig records data as the last config which is applied successfully.
+func rememberConfig(data []byte) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	lastGoodConfig = data
+}
+
+// applyConfig reads data into viper and reloads all struct configs.
+// If any struct config fails to decode or validate, nothing is applied
+// and viper is rolled back to the last good config.
+func applyConfig(source string, data []byte) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		lg.Errorc(lg.Ctx, "Read %v config error: %v", source, err)
+		rollbackConfig()
+		return errors.Wrap(err, "read config")
+	}
+
+	settings := flattenSettings(v.AllSettings())
+	changed := diffSettings(lastSettings, settings)
+	if len(changed) == 0 {
+		lg.Debugc(lg.Ctx, "%v config not change.", source)
+		lastGoodConfig = data
+		return nil
+	}
+	lg.Infoc(lg.Ctx, "%v config change. Keys=%v", source, changed)
+
+	if killWhileChange != nil && killWhileChange() {
+		killToRestartServer()
+		return nil
+	}
+
+	if err := reloadStructs(changed); err != nil {
+		lg.Errorc(lg.Ctx, "Reload %v config error, rollback to the last good config: %v", source, err)
+		rollbackConfig()
+		return err
+	}
+
+	lastGoodConfig = data
+	lastSettings = settings
+
+	structMu.RLock()
+	notifys := append([]func([]string){}, changeNotifys...)
+	structMu.RUnlock()
+	for _, fn := range notifys {
+		fn(changed)
+	}
+	return nil
+}
+
+func rollbackConfig() {
+	if lastGoodConfig == nil {
+		lg.Warnc(lg.Ctx, "No last good config to rollback.")
+		return
+	}
+
+	if err := v.ReadConfig(bytes.NewReader(lastGoodConfig)); err != nil {
+		lg.Errorc(lg.Ctx, "Rollback config error: %v", err)
+	}
+}
+
+// reloadStructs rebuilds every struct config affected by changed,
+// then swaps the new values in only if all of them are valid.
+func reloadStructs(changed []string) error {
+	type pending struct {
+		binding structBinding
+		val     any
+	}
+
+	structMu.RLock()
+	bindings := make(map[string][]structBinding, len(keyStructMap)+len(keyWatchers))
+	for key, b := range keyStructMap {
+		bindings[key] = append(bindings[key], b)
+	}
+	for key, bs := range keyWatchers {
+		bindings[key] = append(bindings[key], bs...)
+	}
+	structMu.RUnlock()
+
+	var pendings []pending
+	for key, bs := range bindings {
+		if !keyChanged(key, changed) {
+			continue
+		}
+		for _, b := range bs {
+			val, err := b.decode(key)
+			if err != nil {
+				return errors.Wrapf(err, "reload %v", key)
+			}
+			pendings = append(pendings, pending{binding: b, val: val})
+		}
+	}
+
+	for _, p := range pendings {
+		p.binding.swap(p.val)
+	}
+	return nil
+}
+
+func keyChanged(key string, changed []string) bool {
+	key = strings.ToLower(key)
+	for _, c := range changed {
+		if c == key || strings.HasPrefix(c, key+".") || strings.HasPrefix(key, c+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenSettings flattens the nested settings of viper into dot separated keys.
+func flattenSettings(settings map[string]any) map[string]any {
+	out := make(map[string]any)
+	var flatten func(prefix string, m map[string]any)
+	flatten = func(prefix string, m map[string]any) {
+		for k, val := range m {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			if sub, ok := val.(map[string]any); ok && len(sub) > 0 {
+				flatten(key, sub)
+				continue
+			}
+			out[key] = val
+		}
+	}
+	flatten("", settings)
+	return out
+}
+
+// diffSettings returns the sorted keys whose value differs between old and new.
+func diffSettings(old, new map[string]any) []string {
+	var changed []string
+	for key, val := range new {
+		if oldVal, ok := old[key]; !ok || !reflect.DeepEqual(oldVal, val) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
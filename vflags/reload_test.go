@@ -0,0 +1,81 @@
+package vflags
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type reloadConfig struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+func (c *reloadConfig) Validate() error {
+	if c.Port <= 0 {
+		return errors.New("invalid port")
+	}
+	return nil
+}
+
+func TestWatchReload(t *testing.T) {
+	resetConfigState()
+	v.SetConfigType("yaml")
+	assert.Nil(t, v.ReadConfig(bytes.NewBufferString("reload:\n  name: a\n  port: 80\nother: 1\n")))
+	rememberConfig([]byte("reload:\n  name: a\n  port: 80\nother: 1\n"))
+
+	confFlag := Struct("reload", &reloadConfig{}, "reload config")
+	legacy := &reloadConfig{}
+	assert.Nil(t, confFlag(legacy))
+
+	var (
+		olds, news []reloadConfig
+		changes    [][]string
+	)
+	current := Watch[reloadConfig]("reload", func(old, new reloadConfig) {
+		olds = append(olds, old)
+		news = append(news, new)
+	})
+	OnChange(func(changed []string) {
+		changes = append(changes, changed)
+	})
+	loadWatchers()
+	assert.Equal(t, reloadConfig{Name: "a", Port: 80}, current())
+
+	// only unrelated keys change
+	applyConfig("test", []byte("reload:\n  name: a\n  port: 80\nother: 2\n"))
+	assert.Len(t, news, 0)
+	assert.Equal(t, [][]string{{"other"}}, changes)
+
+	applyConfig("test", []byte("reload:\n  name: b\n  port: 81\nother: 2\n"))
+	assert.Equal(t, []reloadConfig{{Name: "a", Port: 80}}, olds)
+	assert.Equal(t, []reloadConfig{{Name: "b", Port: 81}}, news)
+	assert.Equal(t, reloadConfig{Name: "b", Port: 81}, current())
+	assert.Equal(t, reloadConfig{Name: "b", Port: 81}, *legacy)
+	assert.Equal(t, []string{"reload.name", "reload.port"}, changes[1])
+
+	// invalid config should be rollback
+	applyConfig("test", []byte("reload:\n  name: c\n  port: 0\nother: 3\n"))
+	assert.Len(t, news, 1)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, reloadConfig{Name: "b", Port: 81}, current())
+	assert.Equal(t, reloadConfig{Name: "b", Port: 81}, *legacy)
+	assert.Equal(t, "b", v.GetString("reload.name"))
+	assert.Equal(t, 2, v.GetInt("other"))
+}
+
+func TestDiffSettings(t *testing.T) {
+	old := flattenSettings(map[string]any{
+		"a": map[string]any{"b": 1, "c": []any{"x"}},
+		"d": "keep",
+		"e": true,
+	})
+	new := flattenSettings(map[string]any{
+		"a": map[string]any{"b": 2, "c": []any{"x"}},
+		"d": "keep",
+		"f": 1,
+	})
+	assert.Equal(t, []string{"a.b", "e", "f"}, diffSettings(old, new))
+}
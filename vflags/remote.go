@@ -0,0 +1,278 @@
+package vflags
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+var (
+	ErrConfigNotFound = errors.New("remote config not found")
+
+	remoteConfig         StringGetter
+	remoteConfigPath     StringGetter
+	remoteConfigInterval func() time.Duration
+
+	providerMu      sync.RWMutex
+	remoteProviders = map[string]RemoteProviderFactory{
+		"consul": newConsulProvider,
+		"etcd":   newEtcdProvider,
+		"etcds":  newEtcdProvider,
+		"http":   newHttpProvider,
+		"https":  newHttpProvider,
+		"file":   newDirProvider,
+	}
+)
+
+// RemoteConfigProvider is the source of the remote config.
+type RemoteConfigProvider interface {
+	// Get fetches the config content of path.
+	// It returns ErrConfigNotFound if the config of path does not exist.
+	Get(ctx context.Context, path string) ([]byte, error)
+	// Watch blocks until ctx is done, and calls onChange with the new content
+	// every time the config of path changes after Watch is called.
+	Watch(ctx context.Context, path string, onChange func(data []byte)) error
+}
+
+// RemoteProviderFactory creates a RemoteConfigProvider from the `--remoteConfig` url.
+type RemoteProviderFactory func(u *url.URL) (RemoteConfigProvider, error)
+
+// RegisterRemoteProvider registers the factory of the `--remoteConfig` url scheme.
+func RegisterRemoteProvider(scheme string, factory RemoteProviderFactory) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	remoteProviders[scheme] = factory
+}
+
+// NewRemoteProvider creates the RemoteConfigProvider of rawURL by its scheme, such as
+// consul://127.0.0.1:8500, etcd://127.0.0.1:2379, https://config.example.com/configs and file:///etc/configs
+func NewRemoteProvider(rawURL string) (RemoteConfigProvider, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse remote config url")
+	}
+
+	providerMu.RLock()
+	factory, ok := remoteProviders[u.Scheme]
+	providerMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("unsupport remote config provider: %v", u.Scheme)
+	}
+	return factory(u)
+}
+
+func declareRemoteFlags() {
+	remoteConfig = String("remoteConfig", "", `Set the remote config url, such as consul://127.0.0.1:8500, etcd://127.0.0.1:2379,
+https://config.example.com/configs or file:///etc/configs.`)
+	remoteConfigPath = String("remoteConfigPath", "", `Set the path template of the remote config, which is the key of consul and etcd,
+and is joined to the url path of http(s) and file. Default is /etc/configs/{{.Service}}/{{.Tag}}.yaml for consul and etcd,
+and {{.Service}}/{{.Tag}}.yaml relative to the url for the others.`)
+	remoteConfigInterval = Duration("remoteConfigInterval", 30*time.Second, "Set the poll interval of the remote config which can not be watched.")
+}
+
+// remoteProvider returns the provider of the remote config and its url,
+// or nil if the remote config is not used.
+func remoteProvider(opt *VflagOption) (RemoteConfigProvider, string) {
+	rawURL := remoteConfig()
+	if rawURL == "" {
+		if !opt.useConsul || !useRemoteConfig() {
+			return nil, ""
+		}
+		// the address is set by the consul config, which may contain the scheme
+		rawURL = "consul://"
+	}
+
+	provider, err := NewRemoteProvider(rawURL)
+	if err != nil {
+		lg.Fatalf("Create remote config provider: %v, error: %v", redactURL(rawURL, ""), err)
+	}
+	return provider, rawURL
+}
+
+// redactURL formats the remote config url with the config path as scheme://host/path for logging,
+// the password and the query, which may carry the token, are dropped.
+func redactURL(rawURL, configPath string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid url>"
+	}
+	redacted := &url.URL{
+		Scheme: u.Scheme,
+		User:   u.User,
+		Host:   u.Host,
+		Path:   strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(configPath, "/"),
+	}
+	return redacted.Redacted()
+}
+
+// defaultRemoteConfigPath is the path template of the remote config if remoteConfigPath is not set,
+// the key-value stores keep the key of the legacy consul config, and the others are relative to the url.
+func defaultRemoteConfigPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err == nil && (u.Scheme == "consul" || u.Scheme == "etcd") {
+		return "/etc/configs/{{.Service}}/{{.Tag}}.yaml"
+	}
+	return "{{.Service}}/{{.Tag}}.yaml"
+}
+
+// remoteKeyPath renders the remote config path template of rawURL with the service name and tag.
+func remoteKeyPath(rawURL string) (string, error) {
+	pathTmpl := remoteConfigPath()
+	if pathTmpl == "" {
+		pathTmpl = defaultRemoteConfigPath(rawURL)
+	}
+	tmpl, err := template.New("path").Parse(pathTmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "parse remote config path")
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, map[string]string{
+		"Service": getServiceNameWithoutTag(),
+		"Tag":     getServiceTag(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "execute remote config path")
+	}
+	return buf.String(), nil
+}
+
+// readRemoteConfig reads the remote config, it falls back to the snapshot of
+// the last fetched remote config if the remote config is unreachable.
+func readRemoteConfig(provider RemoteConfigProvider, rawURL string) (path string, fromSnapshot bool) {
+	path, err := remoteKeyPath(rawURL)
+	if err != nil {
+		lg.Fatalf("Render remote config path error: %v", err)
+	}
+
+	timeout := 30 * time.Second
+	if remoteConfigTimeout != nil {
+		timeout = remoteConfigTimeout()
+	}
+
+	data, err := fetchWithRetry(lg.Ctx, provider, path, timeout)
+	if err != nil {
+		lg.Errorc(lg.Ctx, "Fetch remote config -> %v, error: %v", path, err)
+		if errors.Is(err, ErrConfigNotFound) {
+			lg.Fatal("Failed to read remote config.")
+		}
+
+		var file string
+		data, file, err = loadSnapshot()
+		if err != nil {
+			lg.Errorc(lg.Ctx, "Load remote config snapshot error: %v", err)
+			lg.Fatal("Failed to read remote config.")
+		}
+		remoteConfigFallbacks.Add(1)
+		lg.Warnc(lg.Ctx, "!!! Remote config -> %v is unreachable, fallback to the snapshot: %v. The config may be stale !!!", path, file)
+		fromSnapshot = true
+	}
+
+	v.SetConfigType(detectConfigFormat(path, data))
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		lg.Fatalf("Read remote config -> %v, error: %v", path, err)
+	}
+	rememberConfig(data)
+	if !fromSnapshot {
+		saveSnapshot(data)
+	}
+	return path, fromSnapshot
+}
+
+// watchRemoteConfig watches the remote config until ctx is done.
+// It restarts the watch with backoff if the provider fails.
+func watchRemoteConfig(ctx context.Context, provider RemoteConfigProvider, path string) {
+	b := newBackoff()
+	for {
+		err := provider.Watch(ctx, path, func(data []byte) {
+			b.reset()
+			if err := applyConfig("remote", data); err == nil {
+				saveSnapshot(data)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		remoteConfigErrors.Add(1)
+
+		wait := b.next()
+		lg.Errorc(ctx, "Watch remote config -> %v, error: %v, retry after %v", path, err, wait)
+		if sleepContext(ctx, wait) != nil {
+			return
+		}
+	}
+}
+
+// detectConfigFormat detects the format of the config by the extension of path,
+// and by the content if path has no known extension.
+func detectConfigFormat(path string, data []byte) string {
+	switch ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")); ext {
+	case "yaml", "yml", "json", "toml":
+		return ext
+	}
+
+	content := bytes.TrimSpace(data)
+	if bytes.HasPrefix(content, []byte("{")) {
+		return "json"
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			return "toml"
+		}
+		if key, _, ok := strings.Cut(line, "="); ok && !strings.Contains(key, ":") {
+			return "toml"
+		}
+		break
+	}
+	return "yaml"
+}
+
+// pollWatch calls fetch every interval, and calls onChange when the content changes.
+func pollWatch(ctx context.Context, interval time.Duration, fetch func() ([]byte, error), onChange func([]byte)) error {
+	current, err := fetch()
+	if err != nil && !errors.Is(err, ErrConfigNotFound) {
+		lg.Warnc(ctx, "Poll remote config error: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		data, err := fetch()
+		if err != nil {
+			remoteConfigErrors.Add(1)
+			lg.Warnc(ctx, "Poll remote config error: %v", err)
+			continue
+		}
+		if bytes.Equal(data, current) {
+			continue
+		}
+		current = data
+		onChange(data)
+	}
+}
+
+// killToRestartServer will kill the server first.
+// If the service runs with docker and is set to start automatically,
+// it can implement configuration updates and refresh the service
+func killToRestartServer() {
+	lg.Infoc(lg.Ctx, "Remote config changed. Shutting down...")
+	kill()
+}
@@ -0,0 +1,80 @@
+package vflags
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+// dirProvider reads the remote config from a local directory, such as a mounted config map.
+type dirProvider struct {
+	dir string
+}
+
+func newDirProvider(u *url.URL) (RemoteConfigProvider, error) {
+	dir := u.Path
+	if u.Host != "" {
+		// relative directory, e.g. file://configs
+		dir = filepath.Join(u.Host, u.Path)
+	}
+	if dir == "" {
+		return nil, errors.New("empty config directory")
+	}
+	return &dirProvider{dir: dir}, nil
+}
+
+func (p *dirProvider) file(path string) string {
+	return filepath.Join(p.dir, filepath.FromSlash(path))
+}
+
+func (p *dirProvider) Get(ctx context.Context, path string) ([]byte, error) {
+	data, err := os.ReadFile(p.file(path))
+	if os.IsNotExist(err) {
+		return nil, ErrConfigNotFound
+	}
+	return data, err
+}
+
+func (p *dirProvider) Watch(ctx context.Context, path string, onChange func([]byte)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "new watcher")
+	}
+	defer watcher.Close()
+
+	// watch the directory instead of the file, since editors and config maps replace the file
+	file := p.file(path)
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return errors.Wrap(err, "watch config directory")
+	}
+
+	current, _ := p.Get(ctx, path)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-watcher.Errors:
+			lg.Warnc(ctx, "Watch config directory error: %v", err)
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) != filepath.Clean(file) {
+				continue
+			}
+			data, err := p.Get(ctx, path)
+			// the file is empty while it is truncated and rewritten
+			if err != nil || len(data) == 0 {
+				continue
+			}
+			if bytes.Equal(data, current) {
+				continue
+			}
+			current = data
+			onChange(data)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package vflags
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func pollInterval(u *url.URL) time.Duration {
+	if d, err := time.ParseDuration(u.Query().Get("interval")); err == nil && d > 0 {
+		return d
+	}
+	if remoteConfigInterval != nil {
+		return remoteConfigInterval()
+	}
+	return 30 * time.Second
+}
+
+// httpProvider reads the remote config from a plain http(s) endpoint by joining the url and the path.
+// The config is polled since http can not be watched.
+type httpProvider struct {
+	baseURL  string
+	client   *http.Client
+	interval time.Duration
+}
+
+func newHttpProvider(u *url.URL) (RemoteConfigProvider, error) {
+	base := *u
+	q := base.Query()
+	q.Del("interval")
+	base.RawQuery = q.Encode()
+
+	return &httpProvider{
+		baseURL:  base.String(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: pollInterval(u),
+	}, nil
+}
+
+func (p *httpProvider) url(path string) string {
+	base, query, _ := strings.Cut(p.baseURL, "?")
+	u := strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+func (p *httpProvider) Get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url(path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, ErrConfigNotFound
+	default:
+		return nil, errors.Errorf("fetch %v: unexpected status %v", p.url(path), resp.Status)
+	}
+}
+
+func (p *httpProvider) Watch(ctx context.Context, path string, onChange func([]byte)) error {
+	return pollWatch(ctx, p.interval, func() ([]byte, error) {
+		return p.Get(ctx, path)
+	}, onChange)
+}
+
+// etcdProvider reads the remote config from etcd by the v3 json gateway.
+// The config is polled, and the change is detected by comparing its content.
+type etcdProvider struct {
+	endpoint string
+	client   *http.Client
+	interval time.Duration
+}
+
+func newEtcdProvider(u *url.URL) (RemoteConfigProvider, error) {
+	scheme := "http"
+	if u.Scheme == "etcds" {
+		scheme = "https"
+	}
+
+	return &etcdProvider{
+		endpoint: fmt.Sprintf("%v://%v/v3/kv/range", scheme, u.Host),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: pollInterval(u),
+	}, nil
+}
+
+type etcdRangeResponse struct {
+	Kvs []struct {
+		Value string `json:"value"`
+	} `json:"kvs"`
+}
+
+func (p *etcdProvider) Get(ctx context.Context, path string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(path)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("range %v: unexpected status %v", path, resp.Status)
+	}
+
+	ret := &etcdRangeResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(ret); err != nil {
+		return nil, errors.Wrap(err, "decode etcd response")
+	}
+	if len(ret.Kvs) == 0 {
+		return nil, ErrConfigNotFound
+	}
+	return base64.StdEncoding.DecodeString(ret.Kvs[0].Value)
+}
+
+func (p *etcdProvider) Watch(ctx context.Context, path string, onChange func([]byte)) error {
+	return pollWatch(ctx, p.interval, func() ([]byte, error) {
+		return p.Get(ctx, path)
+	}, onChange)
+}
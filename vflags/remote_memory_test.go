@@ -0,0 +1,80 @@
+package vflags
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+// memoryProvider is an in-memory RemoteConfigProvider,
+// register it with RegisterRemoteProvider and use it by `--remoteConfig=memory://`.
+type memoryProvider struct {
+	mu       sync.Mutex
+	configs  map[string][]byte
+	watchers map[string]map[int]func([]byte)
+	nextID   int
+}
+
+func newMemoryProvider() *memoryProvider {
+	return &memoryProvider{
+		configs:  make(map[string][]byte),
+		watchers: make(map[string]map[int]func([]byte)),
+	}
+}
+
+// Factory returns the RemoteProviderFactory which always returns p.
+func (p *memoryProvider) Factory() RemoteProviderFactory {
+	return func(*url.URL) (RemoteConfigProvider, error) {
+		return p, nil
+	}
+}
+
+// Set sets the config of path, and notifies the watchers of path synchronously.
+func (p *memoryProvider) Set(path string, data []byte) {
+	p.mu.Lock()
+	p.configs[path] = data
+	watchers := make([]func([]byte), 0, len(p.watchers[path]))
+	for _, w := range p.watchers[path] {
+		watchers = append(watchers, w)
+	}
+	p.mu.Unlock()
+
+	for _, w := range watchers {
+		w(data)
+	}
+}
+
+// Watching returns the number of watchers of path.
+func (p *memoryProvider) Watching(path string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.watchers[path])
+}
+
+func (p *memoryProvider) Get(ctx context.Context, path string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data, ok := p.configs[path]
+	if !ok {
+		return nil, ErrConfigNotFound
+	}
+	return data, nil
+}
+
+func (p *memoryProvider) Watch(ctx context.Context, path string, onChange func([]byte)) error {
+	p.mu.Lock()
+	id := p.nextID
+	p.nextID++
+	if p.watchers[path] == nil {
+		p.watchers[path] = make(map[int]func([]byte))
+	}
+	p.watchers[path][id] = onChange
+	p.mu.Unlock()
+
+	<-ctx.Done()
+
+	p.mu.Lock()
+	delete(p.watchers[path], id)
+	p.mu.Unlock()
+	return ctx.Err()
+}
@@ -0,0 +1,170 @@
+package vflags
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/lg/v2"
+)
+
+var (
+	remoteConfigCache   StringGetter
+	remoteConfigTimeout func() time.Duration
+
+	// remoteConfigFallbacks counts how many times the config snapshot is used because the remote config is unreachable.
+	remoteConfigFallbacks = expvar.NewInt("vflags.remoteConfigFallbacks")
+	// remoteConfigErrors counts the failed fetches and watches of the remote config.
+	remoteConfigErrors = expvar.NewInt("vflags.remoteConfigErrors")
+)
+
+func declareSnapshotFlags() {
+	remoteConfigCache = String("remoteConfigCache", "./tmp/config/{{.Service}}-{{.Tag}}.snapshot", `Set the snapshot file of the remote config,
+which is used while the remote config is unreachable. Set empty to disable it.`)
+	remoteConfigTimeout = Duration("remoteConfigTimeout", 30*time.Second, "Set how long to retry fetching the remote config before falling back to the snapshot.")
+}
+
+// backoff is an exponential backoff between min and max.
+type backoff struct {
+	min, max time.Duration
+	cur      time.Duration
+}
+
+func newBackoff() *backoff {
+	return &backoff{min: 500 * time.Millisecond, max: 30 * time.Second}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.cur == 0 {
+		b.cur = b.min
+	} else {
+		b.cur *= 2
+	}
+	if b.cur > b.max {
+		b.cur = b.max
+	}
+	return b.cur
+}
+
+func (b *backoff) reset() {
+	b.cur = 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// fetchWithRetry fetches the remote config with backoff until it succeeds or timeout.
+// ErrConfigNotFound is returned immediately, since retrying can't fix it.
+func fetchWithRetry(ctx context.Context, provider RemoteConfigProvider, path string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	b := newBackoff()
+	for {
+		data, err := provider.Get(ctx, path)
+		if err == nil || errors.Is(err, ErrConfigNotFound) {
+			return data, err
+		}
+		remoteConfigErrors.Add(1)
+
+		wait := b.next()
+		lg.Warnc(ctx, "Fetch remote config -> %v error: %v, retry after %v", path, err, wait)
+		if sleepContext(ctx, wait) != nil {
+			return nil, err
+		}
+	}
+}
+
+// snapshotFile returns the path of the remote config snapshot, or empty if the snapshot is disabled.
+func snapshotFile() string {
+	if remoteConfigCache == nil || remoteConfigCache() == "" {
+		return ""
+	}
+
+	tmpl, err := template.New("snapshot").Parse(remoteConfigCache())
+	if err != nil {
+		lg.Errorc(lg.Ctx, "Parse remote config snapshot path error: %v", err)
+		return ""
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, map[string]string{
+		"Service": getServiceNameWithoutTag(),
+		"Tag":     getServiceTag(),
+	})
+	if err != nil {
+		lg.Errorc(lg.Ctx, "Execute remote config snapshot path error: %v", err)
+		return ""
+	}
+	return buf.String()
+}
+
+// saveSnapshot persists the remote config which is fetched successfully.
+// The file is replaced atomically, so a crash will not leave a broken snapshot.
+func saveSnapshot(data []byte) {
+	file := snapshotFile()
+	if file == "" {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		lg.Warnc(lg.Ctx, "Create remote config snapshot dir error: %v", err)
+		return
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		lg.Warnc(lg.Ctx, "Write remote config snapshot error: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		lg.Warnc(lg.Ctx, "Save remote config snapshot error: %v", err)
+	}
+}
+
+func loadSnapshot() ([]byte, string, error) {
+	file := snapshotFile()
+	if file == "" {
+		return nil, "", errors.New("remote config snapshot disabled")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, file, errors.Wrap(err, "read remote config snapshot")
+	}
+	return data, file, nil
+}
+
+// recoverRemoteConfig keeps fetching the remote config after the service starts with the snapshot,
+// and applies it once the remote config is reachable again.
+func recoverRemoteConfig(ctx context.Context, provider RemoteConfigProvider, path string) {
+	b := newBackoff()
+	for {
+		data, err := provider.Get(ctx, path)
+		if err == nil {
+			lg.Infoc(ctx, "Remote config -> %v is reachable again.", path)
+			if err := applyConfig("remote", data); err == nil {
+				saveSnapshot(data)
+			}
+			return
+		}
+		remoteConfigErrors.Add(1)
+
+		if sleepContext(ctx, b.next()) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package vflags
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type unreachableProvider struct {
+	*memoryProvider
+	down bool
+}
+
+func (p *unreachableProvider) Get(ctx context.Context, path string) ([]byte, error) {
+	if p.down {
+		return nil, errors.New("connection refused")
+	}
+	return p.memoryProvider.Get(ctx, path)
+}
+
+func TestSnapshotFallback(t *testing.T) {
+	resetConfigState()
+	snapshot := filepath.Join(t.TempDir(), "{{.Service}}.snapshot")
+	remoteConfigPath = func() string { return "/etc/configs/app.yaml" }
+	remoteConfigCache = func() string { return snapshot }
+	remoteConfigTimeout = func() time.Duration { return 100 * time.Millisecond }
+
+	p := &unreachableProvider{memoryProvider: newMemoryProvider()}
+	p.Set("/etc/configs/app.yaml", []byte("name: a\n"))
+
+	_, fromSnapshot := readRemoteConfig(p, "memory://")
+	assert.False(t, fromSnapshot)
+	data, err := os.ReadFile(snapshotFile())
+	assert.Nil(t, err)
+	assert.Equal(t, "name: a\n", string(data))
+
+	resetConfigState()
+	p.down = true
+	fallbacks := remoteConfigFallbacks.Value()
+	_, fromSnapshot = readRemoteConfig(p, "memory://")
+	assert.True(t, fromSnapshot)
+	assert.Equal(t, "a", v.GetString("name"))
+	assert.Equal(t, fallbacks+1, remoteConfigFallbacks.Value())
+
+	// the remote config is applied once it is reachable again
+	p.Set("/etc/configs/app.yaml", []byte("name: b\n"))
+	p.down = false
+	loadWatchers()
+	recoverRemoteConfig(context.Background(), p, "/etc/configs/app.yaml")
+	assert.Equal(t, "b", v.GetString("name"))
+	data, err = os.ReadFile(snapshotFile())
+	assert.Nil(t, err)
+	assert.Equal(t, "name: b\n", string(data))
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff()
+	assert.Equal(t, 500*time.Millisecond, b.next())
+	assert.Equal(t, time.Second, b.next())
+	for i := 0; i < 10; i++ {
+		b.next()
+	}
+	assert.Equal(t, 30*time.Second, b.next())
+	b.reset()
+	assert.Equal(t, 500*time.Millisecond, b.next())
+}
@@ -2,6 +2,7 @@ package vflags
 
 import (
 	"fmt"
+	"os"
 	"reflect"
 	"strings"
 	"time"
@@ -15,7 +16,7 @@ import (
 var (
 	ErrNotStruct = errors.New("not struct")
 
-	keyStructMap = make(map[string]any)
+	keyStructMap = make(map[string]structBinding)
 )
 
 type HasDefault interface {
@@ -30,8 +31,11 @@ type HasReloader interface {
 	Reload()
 }
 
+// Struct registers the struct config of key, and returns the getter decoding it into out.
+// The out is overwritten in place when the config is reloaded, which races with the goroutines reading it,
+// so the config read concurrently should be subscribed by Watch, which is the only safe way of hot reload.
 func Struct(key string, defaultVal any, usage string) func(out any) error {
-	err := setPFlagRecursively(key, defaultVal)
+	err := setPFlagRecursively(pflag.CommandLine, nestedKey, key, defaultVal)
 	if err != nil {
 		if !errors.Is(err, ErrNotStruct) {
 			lg.Errorc(lg.Ctx, "Bind struct flags error: %v", err)
@@ -40,16 +44,19 @@ func Struct(key string, defaultVal any, usage string) func(out any) error {
 		lg.Debugc(lg.Ctx, "it won't display `%v` desciption with not struct default val", key)
 	}
 	v.SetDefault(key, defaultVal)
+	structDefaults[key] = structDefault{val: defaultVal, usage: usage}
+	return structGetter(key)
+}
+
+func structGetter(key string) func(out any) error {
 	return func(out any) error {
-		if err := v.UnmarshalKey(key, out); err != nil {
+		if err := unmarshalStruct(key, out); err != nil {
 			return err
 		}
 
-		if err := structCheck(out); err != nil {
-			return errors.Wrap(err, "check")
-		}
-
-		keyStructMap[key] = out
+		structMu.Lock()
+		keyStructMap[key] = &pointerBinding{out: out}
+		structMu.Unlock()
 
 		return nil
 	}
@@ -66,42 +73,29 @@ func structCheck(out any) error {
 	return nil
 }
 
-func structConfReload() {
-	for key, out := range keyStructMap {
-		if err := v.UnmarshalKey(key, out); err != nil {
-			lg.Errorc(lg.Ctx, "reunmarshal %v error: %v", key, err)
-			continue
-		}
-
-		if err := structCheck(out); err != nil {
-			lg.Errorc(lg.Ctx, "%v check error: %v", key, err)
-			continue
-		}
-
-		if r, ok := out.(HasReloader); ok {
-			r.Reload()
-		}
-	}
-}
-
 func setStructConfWatch() {
 	v.WatchConfig()
 	v.OnConfigChange(func(in fsnotify.Event) {
 		lg.Debugf("local config change")
-		if killWhileChange() {
-			killToRestartServer()
+		data, err := os.ReadFile(v.ConfigFileUsed())
+		if err != nil {
+			lg.Errorc(lg.Ctx, "Read local config: %v error: %v", v.ConfigFileUsed(), err)
 			return
 		}
-		structConfReload()
+		applyConfig("local", data)
 	})
 }
 
-func setPFlag(key string, ptr interface{}) {
-	v.BindPFlag(key, pflag.Lookup(key))
-	nestedKey[key] = ptr
+// setPFlag records the flag value pointer of the nested key.
+// Flags of a Command are bound to viper when the command is executed.
+func setPFlag(fs *pflag.FlagSet, nested map[string]interface{}, key string, ptr interface{}) {
+	if fs == pflag.CommandLine {
+		v.BindPFlag(key, fs.Lookup(key))
+	}
+	nested[key] = ptr
 }
 
-func setPFlagRecursively(prefix string, i interface{}) error {
+func setPFlagRecursively(fs *pflag.FlagSet, nested map[string]interface{}, prefix string, i interface{}) error {
 	vf := reflect.ValueOf(i)
 	if vf.Kind() == reflect.Ptr {
 		vf = vf.Elem()
@@ -111,50 +105,50 @@ func setPFlagRecursively(prefix string, i interface{}) error {
 	}
 	for i := 0; i < vf.NumField(); i++ {
 		field := vf.Type().Field(i)
-		name := field.Name
-		for _, tag := range []string{"vflags", "json"} {
-			if content := field.Tag.Get(tag); content != "" {
-				name = strings.SplitN(content, ",", 2)[0]
-				break
-			}
+		// the field tagged with `vflags:"-"` can not be configured, such as the funcs
+		if fieldName(field) == "-" {
+			continue
+		}
+		usage := fieldUsage(field)
+		name := prefix + "." + fieldName(field)
+		if field.Tag.Get("secret") == "true" {
+			registerSecretKey(name)
 		}
-		usage := field.Tag.Get("usage")
-		name = prefix + "." + name
 
 		switch vf.Field(i).Kind() {
 		case reflect.String:
-			setPFlag(name, pflag.String(name, vf.Field(i).String(), usage))
+			setPFlag(fs, nested, name, fs.String(name, vf.Field(i).String(), usage))
 		case reflect.Bool:
-			setPFlag(name, pflag.Bool(name, vf.Field(i).Bool(), usage))
+			setPFlag(fs, nested, name, fs.Bool(name, vf.Field(i).Bool(), usage))
 		case reflect.Int, reflect.Int64:
 			if field.Type.String() == "time.Duration" {
-				setPFlag(name, pflag.Duration(name, time.Duration(vf.Field(i).Int()), usage))
+				setPFlag(fs, nested, name, fs.Duration(name, time.Duration(vf.Field(i).Int()), usage))
 			} else {
-				setPFlag(name, pflag.Int(name, int(vf.Field(i).Int()), usage))
+				setPFlag(fs, nested, name, fs.Int(name, int(vf.Field(i).Int()), usage))
 			}
 		case reflect.Float64:
-			setPFlag(name, pflag.Float64(name, vf.Field(i).Float(), usage))
+			setPFlag(fs, nested, name, fs.Float64(name, vf.Field(i).Float(), usage))
 		case reflect.Map:
 			// The map type can only be read from the configuration file, so it does not need to be set in pflag
 		case reflect.Slice:
 			switch field.Type.String() {
 			case "[]int":
-				setPFlag(name, pflag.IntSlice(name, vf.Field(i).Interface().([]int), usage))
+				setPFlag(fs, nested, name, fs.IntSlice(name, vf.Field(i).Interface().([]int), usage))
 			case "[]string":
-				setPFlag(name, pflag.StringSlice(name, vf.Field(i).Interface().([]string), usage))
+				setPFlag(fs, nested, name, fs.StringSlice(name, vf.Field(i).Interface().([]string), usage))
 			case "[]float64":
-				setPFlag(name, pflag.Float64Slice(name, vf.Field(i).Interface().([]float64), usage))
+				setPFlag(fs, nested, name, fs.Float64Slice(name, vf.Field(i).Interface().([]float64), usage))
 			case "[]bool":
-				setPFlag(name, pflag.BoolSlice(name, vf.Field(i).Interface().([]bool), usage))
+				setPFlag(fs, nested, name, fs.BoolSlice(name, vf.Field(i).Interface().([]bool), usage))
 			case "[]time.Duration":
-				setPFlag(name, pflag.DurationSlice(name, vf.Field(i).Interface().([]time.Duration), usage))
+				setPFlag(fs, nested, name, fs.DurationSlice(name, vf.Field(i).Interface().([]time.Duration), usage))
 			case "[]map[string]interface {}", "[]map[string]string", "[]map[string]int":
 				// The map type can only be read from the configuration file, so it does not need to be set in pflag
 			default:
 				return fmt.Errorf("unsupport type of field %s %s", field.Name, field.Type.String())
 			}
 		case reflect.Struct, reflect.Ptr:
-			if err := setPFlagRecursively(name, vf.Field(i).Interface()); err != nil {
+			if err := setPFlagRecursively(fs, nested, name, vf.Field(i).Interface()); err != nil {
 				return err
 			}
 		default:
@@ -165,6 +159,24 @@ func setPFlagRecursively(prefix string, i interface{}) error {
 	return nil
 }
 
+// fieldName returns the config key of the field, which is read from the `vflags` or `json` tag.
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"vflags", "json"} {
+		if content := field.Tag.Get(tag); content != "" {
+			return strings.SplitN(content, ",", 2)[0]
+		}
+	}
+	return field.Name
+}
+
+// fieldUsage returns the description of the field, which is read from the `usage` or `desc` tag.
+func fieldUsage(field reflect.StructField) string {
+	if usage := field.Tag.Get("usage"); usage != "" {
+		return usage
+	}
+	return field.Tag.Get("desc")
+}
+
 func injectNestedKey() {
 	for key, valuePtr := range nestedKey {
 		flag := pflag.Lookup(key)
@@ -26,6 +26,8 @@ var (
 	config            StringGetter
 	useRemoteConfig   BoolGetter
 	watchConfig       BoolGetter
+	discoverOverride  StringSliceGetter
+	consulConfig      func(out any) error
 	// killWhileChange will kill this service while config change
 	// If used in conjunction with the restart configuration of docker,
 	// the service can be restarted immediately upon configuration change
@@ -47,6 +49,28 @@ func declareConsulFlags() {
 	shared.UseConsul = Bool("useConsul", true, "Whether to use the consul service center.")
 	shared.ConsulAddr = String("consulAddr", fmt.Sprintf("%v:8500", discover.HostAddress), "Set the conusl addr.")
 	useRemoteConfig = Bool("useRemoteConfig", false, "Set true to use remote config.")
+	declareConsulConfig()
+}
+
+// declareConsulConfig declares the consul config, which is decoded without binding the out pointer
+// to be overwritten on reload, since a fresh one is decoded each time.
+func declareConsulConfig() {
+	Struct("consul", &discover.ConsulConfig{}, "Set the consul client config, which is used by service discovery and remote config.")
+	consulConfig = func(out any) error {
+		return unmarshalStruct("consul", out)
+	}
+}
+
+// getConsulConfig returns the consul client config bound by flags and config.
+func getConsulConfig() (*discover.ConsulConfig, error) {
+	conf := &discover.ConsulConfig{}
+	if consulConfig == nil {
+		return conf, nil
+	}
+	if err := consulConfig(conf); err != nil {
+		return nil, errors.Wrap(err, "parse consul config")
+	}
+	return conf, nil
 }
 
 func declareDefaultFlags(o *VflagOption) {
@@ -54,9 +78,15 @@ func declareDefaultFlags(o *VflagOption) {
 	debug = Bool("debug", false, "Whether to enable debug mode.")
 	shared.ServiceName = StringP("service", "s", os.Getenv("VENKIT_SERVICE"), "Set the service name.")
 	watchConfig = Bool("watchConfig", false, "Set true to watch config.")
+	shared.Discovery = String("discovery", "", `The service discovery, one of consul, direct, file:services.yaml and dns[:domain[:port]].
+Default is consul if useConsul is set, otherwise direct.`)
+	discoverOverride = StringSlice("discoverOverride", nil, "Override the addresses of services, e.g. mysql=127.0.0.1:3306,user:dev=127.0.0.1:8080")
 	killWhileChange = Bool("killWhenChange", false, `It will kill this service while config change. 
 If used in conjunction with the restart configuration of docker,
 the service can be restarted immediately upon configuration change.`)
+	declareInspectFlags()
+	declareRemoteFlags()
+	declareSnapshotFlags()
 	if o.useConsul {
 		declareConsulFlags()
 	}
@@ -79,6 +109,9 @@ func initVFlags(o *VflagOption) {
 	v.SetConfigFile("config.yaml")
 
 	declareDefaultFlags(o)
+}
+
+func bindVFlags() {
 	if err := v.BindPFlags(pflag.CommandLine); err != nil {
 		lg.Fatal("BindPFlags error: %v", err)
 	}
@@ -114,7 +147,7 @@ func ConfigFile() ([]byte, error) {
 	return b, nil
 }
 
-func Parse(opts ...VflagOptionFunc) {
+func packOption(opts ...VflagOptionFunc) *VflagOption {
 	o := &VflagOption{
 		autoParseConfig: true,
 	}
@@ -122,13 +155,25 @@ func Parse(opts ...VflagOptionFunc) {
 	for _, opt := range opts {
 		opt(o)
 	}
+	return o
+}
+
+func Parse(opts ...VflagOptionFunc) {
+	o := packOption(opts...)
 
 	initVFlags(o)
+	bindVFlags()
+	parse(o)
+}
+
+func parse(o *VflagOption) {
 	pflag.Parse()
 
 	injectNestedKey()
 	readConfig(o)
+	handleInspectFlags()
 	checkFlagKey()
+	loadWatchers()
 	optionInit()
 	snail.Init()
 }
@@ -138,15 +183,48 @@ func optionInit() {
 		lg.EnableDebug()
 	}
 
-	if shared.GetIsUseConsul() {
-		discover.SetConsulFinderToDefault()
-	}
+	setServiceFinder()
 
 	if watchConfig() {
 		setStructConfWatch()
 	}
 }
 
+func setServiceFinder() {
+	conf, err := getConsulConfig()
+	if err != nil {
+		lg.Fatalf("Get consul config error: %v", err)
+	}
+	discover.SetConsulConfig(conf)
+
+	var finder discover.ServiceFinder
+	switch spec := shared.GetDiscovery(); {
+	case spec != "":
+		f, err := discover.NewFinder(spec)
+		if err != nil {
+			lg.Fatalf("Create service finder %v error: %v", spec, err)
+		}
+		finder = f
+	case shared.GetIsUseConsul():
+		f, err := discover.NewFinder("consul")
+		if err != nil {
+			lg.Fatalf("Create consul finder error: %v", err)
+		}
+		finder = f
+	default:
+		finder = discover.GetServiceFinder()
+	}
+
+	if items := discoverOverride(); len(items) != 0 {
+		overrides, err := discover.ParseOverrides(items)
+		if err != nil {
+			lg.Fatalf("Parse discoverOverride error: %v", err)
+		}
+		finder = discover.Chain(discover.NewOverrideFinder(overrides), finder)
+	}
+	discover.SetServiceFinder(finder)
+}
+
 func getServiceNameWithoutTag() string {
 	s := GetServiceName()
 	segs := strings.SplitN(s, ":", 2)
@@ -166,19 +244,27 @@ func getServiceTag() string {
 }
 
 func readConfig(opt *VflagOption) {
-	if opt.useConsul && useRemoteConfig() {
-		path := readConsulConfig()
+	if provider, rawURL := remoteProvider(opt); provider != nil {
+		path, fromSnapshot := readRemoteConfig(provider, rawURL)
 		if watchConfig() {
-			go watchCnosulConfigChange(path)
+			if fromSnapshot {
+				go recoverRemoteConfig(lg.Ctx, provider, path)
+			}
+			go watchRemoteConfig(lg.Ctx, provider, path)
 		}
-		lg.Infoc(lg.Ctx, "Read consul config success. Config=%v", path)
+		configSource = fmt.Sprintf("remote %v", redactURL(rawURL, path))
+		lg.Infoc(lg.Ctx, "Read remote config success. Config=%v", redactURL(rawURL, path))
 	} else if opt.autoParseConfig && config() != "" && venkitUtils.FileExists(config()) {
 		// use local config
 		v.SetConfigFile(config())
 		if err := v.ReadInConfig(); err != nil {
 			lg.Errorc(lg.Ctx, "Read on local file: %v, error: %v", config(), err)
 		} else {
+			configSource = fmt.Sprintf("file %v", config())
 			lg.Infoc(lg.Ctx, "Read local config success. Config=%v", config())
+			if data, err := os.ReadFile(config()); err == nil {
+				rememberConfig(data)
+			}
 		}
 	}
 }
@@ -12,6 +12,7 @@ type dbType int
 const (
 	mysql = iota
 	sqlite
+	postgres
 )
 
 type Config interface {
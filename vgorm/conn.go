@@ -0,0 +1,44 @@
+package vgorm
+
+import (
+	"time"
+
+	"github.com/superwhys/venkit/v2/dialer"
+)
+
+// ConnConfig is the connection config shared by the databases, the zero values use the defaults of dialer.
+type ConnConfig struct {
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	ConnectTimeout  time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	// TimeZone is the time zone of the connection, e.g. Asia/Shanghai
+	TimeZone string
+	// Params is the extra params appended to the dsn
+	Params map[string]string
+	TLS    dialer.TLSFiles
+}
+
+func (c *ConnConfig) dialOptions() ([]dialer.OptionFunc, error) {
+	opts := []dialer.OptionFunc{
+		dialer.WithPool(c.MaxIdleConns, c.MaxOpenConns),
+		dialer.WithConnMaxLifetime(c.ConnMaxLifetime),
+		dialer.WithConnMaxIdleTime(c.ConnMaxIdleTime),
+		dialer.WithTimeouts(c.ConnectTimeout, c.ReadTimeout, c.WriteTimeout),
+		dialer.WithTimeZone(c.TimeZone),
+	}
+	for key, value := range c.Params {
+		opts = append(opts, dialer.WithParam(key, value))
+	}
+	if c.TLS.Enabled() {
+		conf, err := c.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, dialer.WithTLSConfig(conf))
+	}
+	return opts, nil
+}
@@ -0,0 +1,41 @@
+package vgorm
+
+import (
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/v2/dialer"
+	"github.com/superwhys/venkit/v2/migrate"
+	"gorm.io/gorm"
+)
+
+func (t dbType) dialect() (migrate.Dialect, error) {
+	switch t {
+	case mysql:
+		return migrate.MySQL, nil
+	case sqlite:
+		return migrate.SQLite, nil
+	case postgres:
+		return migrate.Postgres, nil
+	}
+	return "", errors.Errorf("unknown db type %v", t)
+}
+
+// NewMigrator returns the migrator of the db of the conf. The db is shared with the registered instance
+// of the conf by the default registry of dialer, which closes it at shutdown. The versioned migrations
+// should be preferred to AutoMigrate in production, since they can drop or rename the columns and fix the data.
+func NewMigrator(conf Config, opts ...migrate.OptionFunc) (*migrate.Migrator, error) {
+	dialect, err := conf.GetDBType().dialect()
+	if err != nil {
+		return nil, err
+	}
+
+	key := conf.GetUid()
+	conn, err := dialer.DefaultRegistry().GetOrRegister("vgorm-migrate:"+key, dialer.GormResource(key, conf.DialGorm))
+	if err != nil {
+		return nil, errors.Wrap(err, "dial db")
+	}
+	sqlDB, err := conn.(*gorm.DB).DB()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(sqlDB, dialect, opts...)
+}
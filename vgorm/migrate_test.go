@@ -0,0 +1,31 @@
+package vgorm
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/superwhys/venkit/v2/dialer"
+	"gorm.io/gorm"
+)
+
+func TestNewMigratorSharesDB(t *testing.T) {
+	conf := &SqliteConfig{DbFile: filepath.Join(t.TempDir(), "migrate.db")}
+
+	for i := 0; i < 2; i++ {
+		if _, err := NewMigrator(conf); err != nil {
+			t.Fatalf("NewMigrator() error = %v", err)
+		}
+	}
+	if err := RegisterSqlModelWithConf(conf); err != nil {
+		t.Fatalf("RegisterSqlModelWithConf() error = %v", err)
+	}
+
+	// the migrators and the registered instance share the db closed by the registry
+	migrateDB, err := dialer.GetAs[*gorm.DB](dialer.DefaultRegistry(), "vgorm-migrate:"+conf.GetUid())
+	if err != nil {
+		t.Fatalf("get migrate db error = %v", err)
+	}
+	if db := getDbByConfig(conf); db != migrateDB {
+		t.Errorf("registered db %p, want the migrate one %p", db, migrateDB)
+	}
+}
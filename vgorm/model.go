@@ -4,11 +4,12 @@ import (
 	"context"
 	"fmt"
 	"reflect"
-	"sync"
 	
 	"github.com/pkg/errors"
 	"github.com/superwhys/venkit/lg/v2"
+	"github.com/superwhys/venkit/v2/dialer"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 type SqlModel interface {
@@ -61,21 +62,38 @@ func GetDbByConf(conf Config) *gorm.DB {
 	return getDbByConfig(conf).WithContext(context.Background())
 }
 
-func registerInstance(conf Config) {
+// registerInstance registers the db into the default registry of dialer, which dials it lazily
+// and closes it at shutdown.
+func registerInstance(conf Config) error {
 	key := conf.GetUid()
-	dbInstanceClientFuncMap[key] = func() getClientFunc {
-		var cli *client
-		var once sync.Once
-		
-		f := func() *client {
-			once.Do(func() {
-				cli = NewClient(conf)
-			})
-			return cli
+	name := "vgorm:" + key
+	err := dialer.DefaultRegistry().Register(name, dialer.GormResource(key, func() (*gorm.DB, error) {
+		if conf.GetService() == "" {
+			return nil, errors.Errorf("vgorm: %v db service name can not be empty", conf.GetDBType())
 		}
-		
-		return f
-	}()
+		return conf.DialGorm()
+	}))
+	if err != nil {
+		return err
+	}
+	
+	dbInstanceClientFuncMap[key] = func() *client {
+		db, err := dialer.GetAs[*gorm.DB](dialer.DefaultRegistry(), name)
+		if errors.Is(err, dialer.ErrRegistryClosed) {
+			lg.Errorf("Get db %v error: %v", key, err)
+			return &client{db: errorDB(err), config: conf}
+		}
+		lg.PanicError(err, "mqlClient: new client error")
+		return &client{db: db, config: conf}
+	}
+	return nil
+}
+
+// errorDB returns the db whose statements all fail with err, for the ones executed after shutdown.
+func errorDB(err error) *gorm.DB {
+	db, _ := gorm.Open(nil, &gorm.Config{Logger: logger.Discard})
+	db.AddError(err)
+	return db
 }
 
 func RegisterSqlModelWithConf(conf Config, ms ...SqlModel) error {
@@ -83,7 +101,9 @@ func RegisterSqlModelWithConf(conf Config, ms ...SqlModel) error {
 		return fmt.Errorf("%v has been register", conf.GetUid())
 	}
 	
-	registerInstance(conf)
+	if err := registerInstance(conf); err != nil {
+		return err
+	}
 	
 	for _, m := range ms {
 		rt := reflect.TypeOf(m)
@@ -110,7 +130,7 @@ func RegisterSqlModel(conf Config, ms ...SqlModel) {
 		panic(fmt.Sprintf("%v has been register", conf.GetUid()))
 	}
 	
-	registerInstance(conf)
+	lg.PanicError(registerInstance(conf))
 	
 	for _, m := range ms {
 		rt := reflect.TypeOf(m)
@@ -0,0 +1,21 @@
+package vgorm
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/v2/dialer"
+)
+
+func TestErrorDB(t *testing.T) {
+	db := errorDB(dialer.ErrRegistryClosed)
+
+	var users []*UserModel
+	err := db.Model(&UserModel{}).Where("age > ?", 18).Find(&users).Error
+	if !errors.Is(err, dialer.ErrRegistryClosed) {
+		t.Errorf("Find() error = %v, want %v", err, dialer.ErrRegistryClosed)
+	}
+	if err := db.Create(&UserModel{Name: "yang"}).Error; !errors.Is(err, dialer.ErrRegistryClosed) {
+		t.Errorf("Create() error = %v, want %v", err, dialer.ErrRegistryClosed)
+	}
+}
@@ -8,13 +8,22 @@ import (
 	
 	"github.com/superwhys/venkit/v2/dialer"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
+// Primary forces the query to be sent to the primary, e.g. reading after writing
+// vgorm.GetDbByModel(&User{}).Clauses(vgorm.Primary).First(&user)
+var Primary = dbresolver.Write
+
 type MysqlConfig struct {
 	Instance string
 	Database string
 	Username string
 	Password string
+	// Replicas are the services of the read replicas, the reads are routed to them
+	// while the writes and transactions are sent to the primary
+	Replicas []string
+	ConnConfig
 }
 
 func (m *MysqlConfig) Validate() error {
@@ -43,10 +52,14 @@ func (m *MysqlConfig) DialGorm() (*gorm.DB, error) {
 	m.TrimSpace()
 	logPrefix := fmt.Sprintf("mysql:%s", m.Database)
 	
-	return dialer.DialMysqlGorm(
-		m.Instance,
+	opts, err := m.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
 		dialer.WithAuth(m.Username, m.Password),
 		dialer.WithDBName(m.Database),
+		dialer.WithReplicas(m.Replicas...),
 		dialer.WithLogger(
 			NewGormLogger(
 				WithPrefix(logPrefix),
@@ -54,6 +67,7 @@ func (m *MysqlConfig) DialGorm() (*gorm.DB, error) {
 			),
 		),
 	)
+	return dialer.DialMysqlGorm(m.Instance, opts...)
 }
 
 func (m *MysqlConfig) TrimSpace() {
@@ -61,4 +75,7 @@ func (m *MysqlConfig) TrimSpace() {
 	m.Password = strings.TrimSpace(m.Password)
 	m.Instance = strings.TrimSpace(m.Instance)
 	m.Database = strings.TrimSpace(m.Database)
+	for i, replica := range m.Replicas {
+		m.Replicas[i] = strings.TrimSpace(replica)
+	}
 }
@@ -0,0 +1,78 @@
+package vgorm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/superwhys/venkit/v2/dialer"
+	"gorm.io/gorm"
+)
+
+type PostgresConfig struct {
+	Instance string
+	Database string
+	Username string
+	Password string
+	// SSLMode is the sslmode of postgres, default is disable
+	SSLMode string
+	// SearchPath is the schemas searched in order, e.g. ["app", "public"]
+	SearchPath []string
+	ConnConfig
+}
+
+func (p *PostgresConfig) Validate() error {
+	if p.Instance == "" {
+		return errors.New("postgres config need instace name")
+	}
+	if p.Database == "" {
+		return errors.New("postgres config need database")
+	}
+	return nil
+}
+
+func (p *PostgresConfig) GetDBType() dbType {
+	return postgres
+}
+
+func (p *PostgresConfig) GetService() string {
+	return p.Instance
+}
+
+func (p *PostgresConfig) GetUid() string {
+	return fmt.Sprintf("postgres-%v-%v", p.Instance, p.Database)
+}
+
+func (p *PostgresConfig) DialGorm() (*gorm.DB, error) {
+	p.TrimSpace()
+	logPrefix := fmt.Sprintf("postgres:%s", p.Database)
+
+	opts, err := p.dialOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		dialer.WithDBName(p.Database),
+		dialer.WithSSLMode(p.SSLMode),
+		dialer.WithSearchPath(p.SearchPath...),
+		dialer.WithLogger(
+			NewGormLogger(
+				WithPrefix(logPrefix),
+				WithSlowThreshold(time.Millisecond*200),
+			),
+		),
+	)
+	if p.Username != "" {
+		opts = append(opts, dialer.WithAuth(p.Username, p.Password))
+	}
+	return dialer.DialPostgresGorm(p.Instance, opts...)
+}
+
+func (p *PostgresConfig) TrimSpace() {
+	p.Username = strings.TrimSpace(p.Username)
+	p.Password = strings.TrimSpace(p.Password)
+	p.Instance = strings.TrimSpace(p.Instance)
+	p.Database = strings.TrimSpace(p.Database)
+	p.SSLMode = strings.TrimSpace(p.SSLMode)
+}
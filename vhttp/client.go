@@ -5,9 +5,13 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/v2/discover"
 	"golang.org/x/net/context"
 )
 
@@ -17,6 +21,9 @@ type Client struct {
 	httpClient *http.Client
 	isDefault  bool
 	isDebug    bool
+
+	balancerMu sync.Mutex
+	balancers  map[string]*discover.Balancer
 }
 
 func newClient(conf *Config) (*Client, error) {
@@ -59,6 +66,7 @@ func newClient(conf *Config) (*Client, error) {
 	return &Client{
 		conf:       conf,
 		httpClient: cli,
+		balancers:  make(map[string]*discover.Balancer),
 	}, nil
 }
 
@@ -102,6 +110,73 @@ func (cli *Client) SetTimeout(timeout time.Duration) {
 	cli.httpClient.Timeout = timeout
 }
 
+func (cli *Client) balancer(service, tag string) *discover.Balancer {
+	key := service + ":" + tag
+
+	cli.balancerMu.Lock()
+	defer cli.balancerMu.Unlock()
+	b, ok := cli.balancers[key]
+	if !ok {
+		b = discover.NewBalancer(service, tag, cli.conf.BalancerOptions...)
+		cli.balancers[key] = b
+	}
+	return b
+}
+
+// servicePick is the address of a service picked by the balancer for a request.
+type servicePick struct {
+	service string
+	tag     string
+	addr    string
+	// done reports the result of the request to the balancer
+	done func(err error)
+}
+
+// resolveServiceURL replaces the service:tag of the url like http://service:tag/path with
+// an address picked by the balancer of the service. The url is returned as it is if its
+// host is not a service, that is the port is a number or there is no port.
+func (cli *Client) resolveServiceURL(rawURL, key string) (string, *servicePick, error) {
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return rawURL, nil, nil
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	service, tag, ok := strings.Cut(rest[:end], ":")
+	if !ok || service == "" || strings.Contains(rest[:end], "@") {
+		return rawURL, nil, nil
+	}
+	if _, err := strconv.Atoi(tag); err == nil {
+		return rawURL, nil, nil
+	}
+
+	pick, err := cli.pick(service, tag, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return scheme + "://" + pick.addr + rest[end:], pick, nil
+}
+
+func (cli *Client) pick(service, tag, key string) (*servicePick, error) {
+	addr, done, err := cli.balancer(service, tag).Pick(key)
+	if err != nil {
+		return nil, err
+	}
+	return &servicePick{service: service, tag: tag, addr: addr, done: done}, nil
+}
+
+// Close stops the balancers of the services requested by the client.
+func (cli *Client) Close() {
+	cli.balancerMu.Lock()
+	defer cli.balancerMu.Unlock()
+	for key, b := range cli.balancers {
+		b.Close()
+		delete(cli.balancers, key)
+	}
+}
+
 func (cli *Client) Start() *Context {
 	ctx := NewContext(cli)
 	defaultHandler := []HandleFunc{
@@ -1,8 +1,14 @@
 package vhttp
 
-import "time"
+import (
+	"time"
+
+	"github.com/superwhys/venkit/v2/discover"
+)
 
 type Config struct {
 	RequestTimeOut time.Duration
 	Proxy          string
+	// BalancerOptions are used by the balancers of the url like http://service:tag/path
+	BalancerOptions []discover.BalancerOption
 }
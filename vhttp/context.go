@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -27,6 +28,10 @@ type Context struct {
 	Body       []byte
 	basicAuth  *baseAuth
 	bodyReader io.Reader
+	balanceKey string
+	pick       *servicePick
+	// rejected is the error of the circuit breaker which rejects the request
+	rejected error
 
 	handlers HandlersChain
 	index    int8
@@ -109,6 +114,12 @@ func (c *Context) SetBody(body []byte) *Context {
 	return c
 }
 
+// SetBalanceKey sets the key used by the consistent hash balancer, such as the user id.
+func (c *Context) SetBalanceKey(key string) *Context {
+	c.balanceKey = key
+	return c
+}
+
 func (c *Context) FormBody(form *Form) *Context {
 	if form != nil {
 		c.Body = form.Encode()
@@ -116,11 +127,36 @@ func (c *Context) FormBody(form *Form) *Context {
 	return c
 }
 
+// balanceResult returns the error of the request as a failure of the address,
+// which is a server error or an error without response.
+func (c *Context) balanceResult() error {
+	switch {
+	case c.rejected != nil:
+		return c.rejected
+	case c.Response != nil && c.Response.StatusCode >= http.StatusInternalServerError:
+		return errors.Errorf("response status %v", c.Response.Status)
+	case c.Request != nil && c.Response == nil:
+		return c.err
+	}
+	return nil
+}
+
 func (c *Context) Fetch(ctx context.Context) *Response {
 	if c.err != nil {
 		return &Response{err: c.err}
 	}
 	c.ctx = ctx
+
+	url, pick, err := c.cli.resolveServiceURL(c.Url, c.balanceKey)
+	if err != nil {
+		return &Response{err: errors.Wrap(err, "resolve service")}
+	}
+	c.Url = url
+	c.pick = pick
+
 	c.Next()
+	if c.pick != nil {
+		c.pick.done(c.balanceResult())
+	}
 	return &Response{Response: c.Response, respByte: c.ResponseBody, err: c.err}
 }
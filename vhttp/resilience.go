@@ -0,0 +1,121 @@
+package vhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/venkit/v2/resilience"
+)
+
+// ResilienceHandler guards the requests with the circuit breaker keyed by the service and the address,
+// which is the address picked for the url like http://service:tag/path, or the host of the url.
+// The failed requests are retried on another address of the service within the retry budget of the
+// service, and the non-idempotent requests are retried only if the breaker rejects them.
+// It should be used after RequestBodyReaderHandler, e.g. cli.Use(vhttp.ResilienceHandler(resilience.Default()))
+func ResilienceHandler(m *resilience.Manager) HandleFunc {
+	return func(c *Context) {
+		index := c.index
+		errBefore := c.err
+		service, address := c.resilienceKey()
+		budget := m.Budget(service)
+		budget.Call()
+		policy := m.RetryPolicy()
+
+		for attempt := 1; ; attempt++ {
+			done, err := m.Breaker(service, address).Allow()
+			if err != nil {
+				c.rejected = errors.Wrapf(err, "%s %s", service, address)
+				c.AddError(c.rejected)
+				c.Abort()
+			} else {
+				c.Next()
+				done(c.balanceResult() == nil)
+			}
+
+			failure := c.balanceResult()
+			if failure == nil || attempt >= policy.MaxAttempts {
+				return
+			}
+			if c.rejected == nil && !idempotent(c.Method) {
+				return
+			}
+			if !budget.TryRetry() || !c.sleep(policy.Wait(attempt)) {
+				return
+			}
+			if err := c.retry(index, errBefore, failure); err != nil {
+				c.AddError(errors.Wrap(err, "retry"))
+				c.Abort()
+				return
+			}
+			service, address = c.resilienceKey()
+		}
+	}
+}
+
+func idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// resilienceKey returns the service and the address of the request.
+func (c *Context) resilienceKey() (service, address string) {
+	if c.pick != nil {
+		service = c.pick.service
+		if c.pick.tag != "" {
+			service += ":" + c.pick.tag
+		}
+		return service, c.pick.addr
+	}
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return c.Url, ""
+	}
+	return u.Host, u.Host
+}
+
+// sleep returns false if the context is done first.
+func (c *Context) sleep(d time.Duration) bool {
+	if c.ctx == nil {
+		time.Sleep(d)
+		return true
+	}
+	select {
+	case <-c.ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// retry resets the context to send the request again by the handlers after index,
+// and picks another address if the url is a service.
+func (c *Context) retry(index int8, err error, failure error) error {
+	if c.pick != nil {
+		old := c.pick
+		c.pick = nil
+		old.done(failure)
+
+		pick, err := c.cli.pick(old.service, old.tag, c.balanceKey)
+		if err != nil {
+			return err
+		}
+		c.pick = pick
+		c.Url = strings.Replace(c.Url, old.addr, pick.addr, 1)
+	}
+
+	c.err = err
+	c.rejected = nil
+	c.Request, c.Response, c.ResponseBody = nil, nil, nil
+	if c.Body != nil {
+		c.bodyReader = bytes.NewReader(c.Body)
+	}
+	c.index = index
+	return nil
+}
@@ -0,0 +1,66 @@
+package vhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superwhys/venkit/v2/discover"
+	"github.com/superwhys/venkit/v2/resilience"
+)
+
+func TestResilienceHandler(t *testing.T) {
+	var badCalls, goodCalls int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&badCalls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&goodCalls, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer good.Close()
+
+	badAddr := strings.TrimPrefix(bad.URL, "http://")
+	goodAddr := strings.TrimPrefix(good.URL, "http://")
+	m := resilience.NewManager(
+		resilience.WithBreakerConfig(resilience.BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute}),
+		resilience.WithRetryPolicy(resilience.RetryPolicy{MaxAttempts: 2}),
+	)
+	cli := New(&Config{
+		RequestTimeOut: 5 * time.Second,
+		BalancerOptions: []discover.BalancerOption{
+			discover.WithFinder(&serviceFinder{addrs: []string{badAddr, goodAddr}}),
+			discover.WithOutlierEjection(0, 0),
+		},
+	})
+	defer cli.Close()
+	cli.Use(RequestBodyReaderHandler(), ResilienceHandler(m))
+
+	// the failed requests are retried on the other address, and the bad one is no longer requested once its breaker opens
+	for i := 0; i < 4; i++ {
+		resp, err := cli.Get(context.Background(), "http://app:dev/", nil, DefaultJsonHeader()).BodyString()
+		assert.Nil(t, err)
+		assert.Equal(t, "ok", resp)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&badCalls))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&goodCalls))
+	assert.Equal(t, resilience.StateOpen, m.Breaker("app:dev", badAddr).State())
+
+	// the non-idempotent request is not retried after it is sent
+	resp := cli.Post(context.Background(), bad.URL, []byte("{}"), DefaultJsonHeader())
+	assert.Nil(t, resp.Error())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&badCalls))
+
+	// the host of the url is rejected by its breaker
+	resp = cli.Get(context.Background(), bad.URL, nil, DefaultJsonHeader())
+	assert.ErrorIs(t, resp.Error(), resilience.ErrOpen)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&badCalls))
+}
@@ -164,6 +164,11 @@ func (rc *RedisClient) UnLock(key string) (err error) {
 	return rc.Delete(key)
 }
 
+// Stats returns the statistics of the pool, such as the active and idle connections.
+func (rc *RedisClient) Stats() redis.PoolStats {
+	return rc.pool.Stats()
+}
+
 func (rc *RedisClient) Close() error {
 	return rc.pool.Close()
 }
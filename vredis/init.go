@@ -1,9 +1,14 @@
 package vredis
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
 	"github.com/superwhys/venkit/lg/v2"
 	"github.com/superwhys/venkit/v2/dialer"
 	"github.com/superwhys/venkit/v2/vflags"
@@ -11,27 +16,91 @@ import (
 
 var redisConfFlag = vflags.Struct("redisConf", (*RedisConf)(nil), "Redis config")
 
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
 type RedisConf struct {
 	Server   string `desc:"redis server name (default localhost:6379)"`
 	Password string `desc:"redis server password"`
 	Db       int    `desc:"redis db (default 0)"`
 	MaxIdle  int    `desc:"redis maxIdle (default 100)"`
+	Username string `desc:"redis ACL username of redis 6+"`
+	Mode     string `desc:"redis mode, standalone, sentinel or cluster (default standalone)"`
+	// Addrs are the sentinels in sentinel mode or the seed nodes in cluster mode, Server is used if it is empty
+	Addrs            []string `desc:"redis sentinel or cluster node addresses"`
+	MasterName       string   `desc:"redis master name monitored by sentinel"`
+	SentinelPassword string   `desc:"redis sentinel password"`
+	TLS              dialer.TLSFiles
+
+	MaxActive       int           `desc:"redis max connections of the pool, 0 means no limit"`
+	Wait            bool          `desc:"wait for a free connection when the pool reaches maxActive"`
+	IdleTimeout     time.Duration `desc:"redis idle connection timeout (default 5m)"`
+	MaxConnLifetime time.Duration `desc:"redis connection max lifetime, 0 means no limit"`
+	PingIdleAfter   time.Duration `desc:"ping the connections idled longer than it when borrowed (default 1m), negative disables"`
 }
 
 func (conf *RedisConf) DialRedisPool() *redis.Pool {
-	return dialer.DialRedisPool(
-		conf.Server,
-		conf.Db,
-		conf.MaxIdle,
-		conf.Password,
-	)
+	opts := []dialer.RedisOptionFunc{
+		dialer.WithRedisDB(conf.Db),
+		dialer.WithRedisAuth(conf.Username, conf.Password),
+		dialer.WithRedisMaxActive(conf.MaxActive, conf.Wait),
+		dialer.WithRedisMaxConnLifetime(conf.MaxConnLifetime),
+	}
+	if conf.IdleTimeout != 0 {
+		opts = append(opts, dialer.WithRedisIdleTimeout(conf.IdleTimeout))
+	}
+	if conf.PingIdleAfter != 0 {
+		opts = append(opts, dialer.WithRedisPingIdleAfter(conf.PingIdleAfter))
+	}
+	if conf.TLS.Enabled() {
+		tlsConf, err := conf.TLS.Load()
+		lg.PanicError(err, "load redis tls")
+		opts = append(opts, dialer.WithRedisTLS(tlsConf))
+	}
+
+	addrs := conf.Addrs
+	if len(addrs) == 0 {
+		addrs = strings.Split(conf.Server, ",")
+	}
+	switch conf.Mode {
+	case ModeSentinel:
+		opts = append(opts, dialer.WithSentinelAuth("", conf.SentinelPassword))
+		return dialer.DialSentinelPool(conf.MasterName, addrs, conf.MaxIdle, opts...)
+	case ModeCluster:
+		return dialer.DialClusterPool(addrs, conf.MaxIdle, opts...)
+	default:
+		return dialer.DialRedisPoolWithOptions(conf.Server, conf.MaxIdle, opts...)
+	}
 }
 
 func (rc *RedisConf) SetDefault() {
-	if rc.Server == "" && rc.MaxIdle == 0 {
+	if rc.Server == "" && len(rc.Addrs) == 0 && rc.MaxIdle == 0 {
 		rc.Server = "localhost:6379"
 		rc.MaxIdle = 100
 	}
+	if rc.Mode == "" {
+		rc.Mode = ModeStandalone
+	}
+}
+
+func (rc *RedisConf) Validate() error {
+	switch rc.Mode {
+	case "", ModeStandalone:
+	case ModeSentinel:
+		if rc.MasterName == "" {
+			return errors.New("redis sentinel mode needs masterName")
+		}
+	case ModeCluster:
+		if rc.Db != 0 {
+			return errors.New("redis cluster only supports db 0")
+		}
+	default:
+		return errors.Errorf("unknown redis mode %v", rc.Mode)
+	}
+	return nil
 }
 
 var (
@@ -51,13 +120,34 @@ func init() {
 	conf := &RedisConf{}
 	lg.PanicError(redisConfFlag(conf))
 
-	var pwd []string
-	if conf.Password != "" {
-		pwd = append(pwd, conf.Password)
-	}
-
 	lg.Debugf("auto connect to redis with config: %v", lg.Jsonify(conf))
 	RedisConn = func() *RedisClient {
-		return NewRedisClient(conf.DialRedisPool())
+		return NewRedisClient(conf.Pool())
+	}
+}
+
+// Pool returns the pool of the config from the default registry of dialer, which is dialed on the
+// first call, shared by the same config, and closed at shutdown.
+func (conf *RedisConf) Pool() *redis.Pool {
+	key := conf.key()
+	pool, err := dialer.DefaultRegistry().GetOrRegister("vredis:"+key, dialer.RedisResource(key, conf.DialRedisPool))
+	if err != nil {
+		// the registry is closed at shutdown, the pool returns the error instead of the connections
+		lg.Errorf("Get redis pool error: %v", err)
+		return &redis.Pool{Dial: func() (redis.Conn, error) { return nil, err }}
+	}
+	return pool.(*redis.Pool)
+}
+
+// key identifies the pool by every field of the config affecting the dial and the pool,
+// the passwords are hashed so that they are not kept in clear.
+func (conf *RedisConf) key() string {
+	addrs := conf.Addrs
+	if len(addrs) == 0 {
+		addrs = strings.Split(conf.Server, ",")
 	}
+	secret := sha256.Sum256([]byte(conf.Password + "\x00" + conf.SentinelPassword))
+	return fmt.Sprintf("%v://%v:%x@%v/%v?master=%v&tls=%+v&maxIdle=%v&maxActive=%v&wait=%v&idleTimeout=%v&maxConnLifetime=%v&pingIdleAfter=%v",
+		conf.Mode, conf.Username, secret[:8], strings.Join(addrs, ","), conf.Db, conf.MasterName, conf.TLS,
+		conf.MaxIdle, conf.MaxActive, conf.Wait, conf.IdleTimeout, conf.MaxConnLifetime, conf.PingIdleAfter)
 }
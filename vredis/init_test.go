@@ -0,0 +1,46 @@
+package vredis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/superwhys/venkit/v2/dialer"
+)
+
+func TestRedisConfKey(t *testing.T) {
+	base := RedisConf{Server: "localhost:6379", Password: "secret-password"}
+	if !strings.Contains(base.key(), "localhost:6379") || strings.Contains(base.key(), "secret-password") {
+		t.Errorf("key() = %v, want the server without the password", base.key())
+	}
+
+	tests := []struct {
+		name   string
+		modify func(conf *RedisConf)
+	}{
+		{name: "password", modify: func(conf *RedisConf) { conf.Password = "other" }},
+		{name: "sentinel-password", modify: func(conf *RedisConf) { conf.SentinelPassword = "other" }},
+		{name: "master-name", modify: func(conf *RedisConf) { conf.MasterName = "other" }},
+		{name: "tls", modify: func(conf *RedisConf) { conf.TLS = dialer.TLSFiles{ServerName: "redis"} }},
+		{name: "max-idle", modify: func(conf *RedisConf) { conf.MaxIdle = 10 }},
+		{name: "max-active", modify: func(conf *RedisConf) { conf.MaxActive = 10 }},
+		{name: "wait", modify: func(conf *RedisConf) { conf.Wait = true }},
+		{name: "idle-timeout", modify: func(conf *RedisConf) { conf.IdleTimeout = time.Minute }},
+		{name: "max-conn-lifetime", modify: func(conf *RedisConf) { conf.MaxConnLifetime = time.Minute }},
+		{name: "ping-idle-after", modify: func(conf *RedisConf) { conf.PingIdleAfter = time.Minute }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := base
+			tt.modify(&conf)
+			if conf.key() == base.key() {
+				t.Errorf("key() of the different configs are the same: %v", conf.key())
+			}
+		})
+	}
+
+	same := base
+	if same.key() != base.key() {
+		t.Errorf("key() of the same configs are different: %v, %v", same.key(), base.key())
+	}
+}
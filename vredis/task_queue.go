@@ -26,6 +26,7 @@ type TaskQueue struct {
 	name     string
 	taskTmpl reflect.Type
 	buckets  []int
+	hashTag  bool
 }
 
 type QueueOption func(*TaskQueue)
@@ -42,6 +43,14 @@ func WithBucket(size int) QueueOption {
 	}
 }
 
+// WithHashTag wraps the name in the keys with a hash tag, so that the keys of the queue are in
+// the same slot of redis cluster. It changes the keys, the tasks pushed without it are not popped.
+func WithHashTag() QueueOption {
+	return func(tq *TaskQueue) {
+		tq.hashTag = true
+	}
+}
+
 func NewTaskQueue(pool *redis.Pool, queueName string, taskObj any, opts ...QueueOption) *TaskQueue {
 	t := reflect.TypeOf(taskObj)
 	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
@@ -71,12 +80,19 @@ func NewTaskQueue(pool *redis.Pool, queueName string, taskObj any, opts ...Queue
 	return q
 }
 
+func (q *TaskQueue) keyName() string {
+	if q.hashTag {
+		return fmt.Sprintf("{%v}", q.name)
+	}
+	return q.name
+}
+
 func (q *TaskQueue) genWipKey() string {
-	return fmt.Sprintf("wip:%v", q.name)
+	return fmt.Sprintf("wip:%v", q.keyName())
 }
 
 func (q *TaskQueue) genQueueKey(bucket int) string {
-	return fmt.Sprintf("queue:%v:%v", q.name, bucket)
+	return fmt.Sprintf("queue:%v:%v", q.keyName(), bucket)
 }
 
 func (q *TaskQueue) checkWorkInProcess(conn redis.Conn, key string) error {
@@ -244,6 +260,15 @@ func (q *TaskQueue) Close() error {
 	conn := q.rc.GetConn()
 	defer conn.Close()
 
-	_, err := redis.Int(conn.Do("DEL", "wip:"+q.name, "queue:"+q.name))
-	return err
+	// the keys are deleted one by one, since they are in different slots of the cluster without the hash tag
+	keys := []string{q.genWipKey()}
+	for _, bucket := range q.buckets {
+		keys = append(keys, q.genQueueKey(bucket))
+	}
+	for _, key := range keys {
+		if _, err := conn.Do("DEL", key); err != nil {
+			return errors.Wrapf(err, "delete %v", key)
+		}
+	}
+	return nil
 }